@@ -0,0 +1,89 @@
+package benchmark
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type countersKey struct{}
+
+// Counters 1回のベンチマーク中に発生したDBクエリ数とRedisの往復回数
+type Counters struct {
+	DBQueries       atomic.Int64
+	RedisRoundTrips atomic.Int64
+}
+
+// WithCounters ctxにカウンタを紐付ける（計測はこのctxを使った呼び出しだけが対象）
+func WithCounters(ctx context.Context, c *Counters) context.Context {
+	return context.WithValue(ctx, countersKey{}, c)
+}
+
+func countersFrom(ctx context.Context) *Counters {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(countersKey{}).(*Counters)
+	return c
+}
+
+// InstrumentGorm gormのコールバックでDBクエリ数を数える
+func InstrumentGorm(db *gorm.DB) error {
+	count := func(tx *gorm.DB) {
+		if c := countersFrom(tx.Statement.Context); c != nil {
+			c.DBQueries.Add(1)
+		}
+	}
+
+	cb := db.Callback()
+	if err := cb.Query().After("gorm:query").Register("benchmark:count_query", count); err != nil {
+		return err
+	}
+	if err := cb.Row().After("gorm:row").Register("benchmark:count_row", count); err != nil {
+		return err
+	}
+	if err := cb.Raw().After("gorm:raw").Register("benchmark:count_raw", count); err != nil {
+		return err
+	}
+	if err := cb.Create().After("gorm:create").Register("benchmark:count_create", count); err != nil {
+		return err
+	}
+	if err := cb.Update().After("gorm:update").Register("benchmark:count_update", count); err != nil {
+		return err
+	}
+	return cb.Delete().After("gorm:delete").Register("benchmark:count_delete", count)
+}
+
+// InstrumentRedis Redisクライアントにフックを追加し、往復回数を数える（パイプラインは1回）
+func InstrumentRedis(rdb *redis.Client) {
+	rdb.AddHook(redisCounterHook{})
+}
+
+type redisCounterHook struct{}
+
+func (redisCounterHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (redisCounterHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if c := countersFrom(ctx); c != nil {
+			c.RedisRoundTrips.Add(1)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (redisCounterHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if c := countersFrom(ctx); c != nil {
+			c.RedisRoundTrips.Add(1)
+		}
+		return next(ctx, cmds)
+	}
+}
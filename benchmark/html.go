@@ -0,0 +1,118 @@
+package benchmark
+
+import (
+	"html/template"
+	"io"
+)
+
+// chartBar SVGの棒1本分
+type chartBar struct {
+	X, Y, Width, Height float64
+	Color               string
+	Label               string
+	Value               float64
+}
+
+type chartGroup struct {
+	X     float64
+	Label string
+}
+
+type htmlData struct {
+	Title   string
+	Reports []Report
+	Bars    []chartBar
+	Groups  []chartGroup
+	Legend  []chartBar
+	Width   float64
+	Height  float64
+}
+
+var chartColors = []string{"#4e79a7", "#f28e2b", "#59a14f", "#e15759", "#76b7b2"}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; }
+table { border-collapse: collapse; margin-top: 16px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<h2>Latency (ms)</h2>
+<svg width="{{.Width}}" height="{{.Height}}" xmlns="http://www.w3.org/2000/svg">
+{{range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}" fill="{{.Color}}"><title>{{.Label}}: {{printf "%.2f" .Value}} ms</title></rect>
+<text x="{{.X}}" y="{{.Y}}" dy="-2" font-size="10">{{printf "%.1f" .Value}}</text>
+{{end}}{{range .Groups}}<text x="{{.X}}" y="{{$.Height}}" dy="-4" font-size="12" text-anchor="middle">{{.Label}}</text>
+{{end}}{{range $i, $l := .Legend}}<rect x="{{$l.X}}" y="4" width="12" height="12" fill="{{$l.Color}}"/><text x="{{$l.X}}" dx="16" y="14" font-size="12">{{$l.Label}}</text>
+{{end}}</svg>
+<h2>Summary</h2>
+<table>
+<tr><th>scenario</th><th>requests</th><th>errors</th><th>throughput (req/s)</th><th>p50</th><th>p90</th><th>p99</th><th>max</th><th>DB queries</th><th>Redis round trips</th></tr>
+{{range .Reports}}<tr><td>{{.Name}}</td><td>{{.Requests}}</td><td>{{.Errors}}</td><td>{{printf "%.1f" .Throughput}}</td><td>{{printf "%.2f" .LatencyMs.P50}}</td><td>{{printf "%.2f" .LatencyMs.P90}}</td><td>{{printf "%.2f" .LatencyMs.P99}}</td><td>{{printf "%.2f" .LatencyMs.Max}}</td><td>{{.DBQueries}}</td><td>{{.RedisRoundTrips}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteHTML レポートをパーセンタイルごとの棒グラフ付きHTMLで出力する
+func WriteHTML(w io.Writer, title string, reports []Report) error {
+	const (
+		barWidth   = 24.0
+		groupGap   = 40.0
+		chartTop   = 40.0
+		chartH     = 260.0
+		labelSpace = 24.0
+	)
+
+	metrics := []struct {
+		label string
+		value func(Report) float64
+	}{
+		{"p50", func(r Report) float64 { return r.LatencyMs.P50 }},
+		{"p90", func(r Report) float64 { return r.LatencyMs.P90 }},
+		{"p99", func(r Report) float64 { return r.LatencyMs.P99 }},
+		{"max", func(r Report) float64 { return r.LatencyMs.Max }},
+	}
+
+	maxValue := 0.0
+	for _, r := range reports {
+		maxValue = max(maxValue, r.LatencyMs.Max)
+	}
+	if maxValue == 0 {
+		maxValue = 1
+	}
+
+	data := htmlData{Title: title, Reports: reports}
+	groupWidth := barWidth*float64(len(reports)) + groupGap
+	x := groupGap / 2
+	for _, m := range metrics {
+		data.Groups = append(data.Groups, chartGroup{X: x + barWidth*float64(len(reports))/2, Label: m.label})
+		for i, r := range reports {
+			v := m.value(r)
+			h := v / maxValue * chartH
+			data.Bars = append(data.Bars, chartBar{
+				X:      x + barWidth*float64(i),
+				Y:      chartTop + chartH - h,
+				Width:  barWidth - 2,
+				Height: h,
+				Color:  chartColors[i%len(chartColors)],
+				Label:  r.Name + " " + m.label,
+				Value:  v,
+			})
+		}
+		x += groupWidth
+	}
+	for i, r := range reports {
+		data.Legend = append(data.Legend, chartBar{X: 8 + float64(i)*160, Color: chartColors[i%len(chartColors)], Label: r.Name})
+	}
+	data.Width = max(x, 160*float64(len(reports)))
+	data.Height = chartTop + chartH + labelSpace
+
+	return htmlTemplate.Execute(w, data)
+}
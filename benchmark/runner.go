@@ -0,0 +1,145 @@
+// Package benchmark は関数を指定した並列数・時間で実行し続け、レイテンシ分布などを計測する
+package benchmark
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config ベンチマークの設定
+type Config struct {
+	Concurrency int           // 並列数
+	Duration    time.Duration // 計測時間
+}
+
+// Latency レイテンシの分布（ミリ秒）
+type Latency struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Report 1シナリオの計測結果
+type Report struct {
+	Name             string  `json:"name"`
+	Concurrency      int     `json:"concurrency"`
+	DurationMs       int64   `json:"duration_ms"`
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"`
+	Throughput       float64 `json:"throughput_rps"`
+	LatencyMs        Latency `json:"latency_ms"`
+	DBQueries        int64   `json:"db_queries"`
+	RedisRoundTrips  int64   `json:"redis_round_trips"`
+	DBQueriesPerReq  float64 `json:"db_queries_per_request"`
+	RedisTripsPerReq float64 `json:"redis_round_trips_per_request"`
+}
+
+// Run fnをConcurrency個のgoroutineでDuration秒間繰り返し実行する
+//
+// fnに渡すctxにはカウンタが紐付いているため、InstrumentGorm/InstrumentRedis済みの
+// クライアントにそのctxを渡せばDBクエリ数とRedis往復回数も集計される。
+func Run(ctx context.Context, name string, config Config, fn func(ctx context.Context) error) Report {
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.Duration <= 0 {
+		config.Duration = time.Second
+	}
+
+	counters := &Counters{}
+	runCtx, cancel := context.WithTimeout(WithCounters(ctx, counters), config.Duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var latencies []time.Duration
+	var errCount int64
+
+	start := time.Now()
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// goroutineごとに集計し、最後にまとめる（ロック競合で計測を歪めないため）
+			var local []time.Duration
+			var localErrs int64
+			for runCtx.Err() == nil {
+				t := time.Now()
+				if err := fn(runCtx); err != nil {
+					if runCtx.Err() != nil {
+						break
+					}
+					localErrs++
+				}
+				local = append(local, time.Since(t))
+			}
+
+			mu.Lock()
+			latencies = append(latencies, local...)
+			errCount += localErrs
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := Report{
+		Name:            name,
+		Concurrency:     config.Concurrency,
+		DurationMs:      elapsed.Milliseconds(),
+		Requests:        int64(len(latencies)),
+		Errors:          errCount,
+		LatencyMs:       Summarize(latencies),
+		DBQueries:       counters.DBQueries.Load(),
+		RedisRoundTrips: counters.RedisRoundTrips.Load(),
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+	if report.Requests > 0 {
+		report.DBQueriesPerReq = float64(report.DBQueries) / float64(report.Requests)
+		report.RedisTripsPerReq = float64(report.RedisRoundTrips) / float64(report.Requests)
+	}
+	return report
+}
+
+// Summarize レイテンシの平均・パーセンタイル（nearest-rank法）・最大値を求める
+func Summarize(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return toMs(sorted[rank-1])
+	}
+
+	return Latency{
+		Mean: toMs(total / time.Duration(len(sorted))),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P99:  percentile(99),
+		Max:  toMs(sorted[len(sorted)-1]),
+	}
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,52 @@
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	latencies := make([]time.Duration, 0, 100)
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	got := Summarize(latencies)
+	expected := Latency{Mean: 50.5, P50: 50, P90: 90, P99: 99, Max: 100}
+	if got != expected {
+		t.Errorf("Summarize() = %+v; expected %+v", got, expected)
+	}
+
+	if got := Summarize(nil); got != (Latency{}) {
+		t.Errorf("Summarize(nil) = %+v; expected zero value", got)
+	}
+}
+
+func TestRun_CountsRequestsErrorsAndQueries(t *testing.T) {
+	calls := 0
+	report := Run(context.Background(), "test", Config{Concurrency: 1, Duration: 50 * time.Millisecond}, func(ctx context.Context) error {
+		calls++
+		countersFrom(ctx).DBQueries.Add(2)
+		time.Sleep(time.Millisecond)
+		if calls%2 == 0 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	// 計測終了と同時に失敗した最後の1回は集計されないことがある
+	if report.Requests == 0 || report.Requests < int64(calls-1) || report.Requests > int64(calls) {
+		t.Fatalf("Requests = %d; expected %d (or one less)", report.Requests, calls)
+	}
+	if report.Errors < int64(calls/2-1) || report.Errors > int64(calls/2) {
+		t.Errorf("Errors = %d; expected about %d", report.Errors, calls/2)
+	}
+	if report.DBQueries != int64(calls*2) {
+		t.Errorf("DBQueries = %d; expected %d", report.DBQueries, calls*2)
+	}
+	if report.Throughput <= 0 {
+		t.Errorf("Throughput = %f; expected > 0", report.Throughput)
+	}
+}
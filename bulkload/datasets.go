@@ -0,0 +1,94 @@
+package bulkload
+
+import (
+	"fmt"
+	"time"
+)
+
+// インデックス性能デモ（demo/db_index_performance）と同じ形のテーブルをPostgreSQLに作るDataset
+//
+// インデックスは作らないので、投入後にCREATE INDEXの有無でEXPLAIN ANALYZEを比較する。
+
+var (
+	productCategories = []int{0, 1, 2, 3, 4} // Electronics, Clothing, Food, Books, Sports
+	orderStatuses     = []string{"pending", "processing", "shipped", "delivered", "cancelled"}
+	firstNames        = []string{"太郎", "花子", "一郎", "次郎", "美咲", "健太", "陽子", "翔太"}
+	lastNames         = []string{"山田", "佐藤", "鈴木", "田中", "高橋", "伊藤", "渡辺", "中村"}
+	departments       = []string{"Engineering", "Sales", "Marketing", "HR", "Finance"}
+	positions         = []string{"Junior", "Senior", "Lead", "Manager", "Director"}
+)
+
+// Products 商品テーブル（category_idとpriceが分布に従う）
+func Products() Dataset {
+	return Dataset{
+		Table:   "products",
+		Columns: []string{"name", "category_id", "price", "stock", "description", "created_at"},
+		Schema: `CREATE TABLE IF NOT EXISTS products (
+	id bigserial PRIMARY KEY,
+	name varchar(200),
+	category_id bigint,
+	price bigint,
+	stock bigint,
+	description varchar(500),
+	created_at timestamptz
+)`,
+		Row: func(i int, g *Generator) []any {
+			return []any{
+				fmt.Sprintf("Product_%d", i+1),
+				Pick(g, i, productCategories),
+				g.Dist.Sample(i, 10000) + 100,
+				g.Rand.Intn(1000),
+				fmt.Sprintf("Description for product %d", i+1),
+				g.Now.Add(-time.Duration(g.Rand.Intn(365*24)) * time.Hour),
+			}
+		},
+	}
+}
+
+// Orders 注文テーブル（user_idが分布に従う。zipfにすると一部のユーザーに注文が集中する）
+func Orders(users int) Dataset {
+	return Dataset{
+		Table:   "orders",
+		Columns: []string{"user_id", "total_price", "status", "order_date"},
+		Schema: `CREATE TABLE IF NOT EXISTS orders (
+	id bigserial PRIMARY KEY,
+	user_id bigint,
+	total_price bigint,
+	status varchar(50),
+	order_date timestamptz
+)`,
+		Row: func(i int, g *Generator) []any {
+			return []any{
+				g.Dist.Sample(i, users) + 1,
+				g.Rand.Intn(50000) + 500,
+				Pick(g, i, orderStatuses),
+				g.Now.Add(-time.Duration(g.Rand.Intn(365*24)) * time.Hour),
+			}
+		},
+	}
+}
+
+// Employees 従業員テーブル（複合インデックスの比較用）
+func Employees() Dataset {
+	return Dataset{
+		Table:   "employees",
+		Columns: []string{"first_name", "last_name", "department", "position", "salary"},
+		Schema: `CREATE TABLE IF NOT EXISTS employees (
+	id bigserial PRIMARY KEY,
+	first_name varchar(100),
+	last_name varchar(100),
+	department varchar(100),
+	position varchar(100),
+	salary bigint
+)`,
+		Row: func(i int, g *Generator) []any {
+			return []any{
+				firstNames[g.Rand.Intn(len(firstNames))],
+				Pick(g, i, lastNames),
+				Pick(g, i, departments),
+				positions[g.Rand.Intn(len(positions))],
+				300000 + g.Dist.Sample(i, 100)*10000,
+			}
+		},
+	}
+}
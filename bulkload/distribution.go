@@ -0,0 +1,93 @@
+package bulkload
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Distribution 0..n-1の値をどう偏らせて選ぶか
+type Distribution interface {
+	Name() string
+	// Sample i行目で使う値を[0, n)から選ぶ
+	Sample(i, n int) int
+}
+
+// 分布の名前
+const (
+	DistributionSequential = "sequential" // i % n（従来のテストデータと同じ並び）
+	DistributionUniform    = "uniform"    // 一様分布
+	DistributionZipf       = "zipf"       // 少数の値に集中する（skew > 1、大きいほど偏る）
+	DistributionNormal     = "normal"     // 中央に集中する（skewは標準偏差のnに対する比率）
+)
+
+// NewDistribution 名前から分布を作成（rは1つのジョブの中だけで使う）
+func NewDistribution(name string, skew float64, r *rand.Rand) (Distribution, error) {
+	switch name {
+	case "", DistributionSequential:
+		return sequential{}, nil
+	case DistributionUniform:
+		return uniform{r: r}, nil
+	case DistributionZipf:
+		if skew == 0 {
+			skew = 1.2
+		}
+		if skew <= 1 {
+			return nil, fmt.Errorf("zipf skew must be > 1, got %v", skew)
+		}
+		return &zipf{r: r, s: skew, byN: map[int]*rand.Zipf{}}, nil
+	case DistributionNormal:
+		if skew == 0 {
+			skew = 0.15
+		}
+		if skew <= 0 {
+			return nil, fmt.Errorf("normal skew must be > 0, got %v", skew)
+		}
+		return normal{r: r, ratio: skew}, nil
+	default:
+		return nil, fmt.Errorf("unknown distribution %q", name)
+	}
+}
+
+type sequential struct{}
+
+func (sequential) Name() string        { return DistributionSequential }
+func (sequential) Sample(i, n int) int { return i % n }
+
+type uniform struct{ r *rand.Rand }
+
+func (uniform) Name() string          { return DistributionUniform }
+func (u uniform) Sample(_, n int) int { return u.r.Intn(n) }
+
+type zipf struct {
+	r   *rand.Rand
+	s   float64
+	byN map[int]*rand.Zipf // rand.Zipfは上限ごとに作る必要がある
+}
+
+func (*zipf) Name() string { return DistributionZipf }
+
+func (z *zipf) Sample(_, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	g, ok := z.byN[n]
+	if !ok {
+		g = rand.NewZipf(z.r, z.s, 1, uint64(n-1))
+		z.byN[n] = g
+	}
+	return int(g.Uint64())
+}
+
+type normal struct {
+	r     *rand.Rand
+	ratio float64
+}
+
+func (normal) Name() string { return DistributionNormal }
+
+func (d normal) Sample(_, n int) int {
+	mean := float64(n-1) / 2
+	v := math.Round(d.r.NormFloat64()*d.ratio*float64(n) + mean)
+	return int(math.Max(0, math.Min(float64(n-1), v)))
+}
@@ -0,0 +1,227 @@
+package bulkload
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status ジョブの状態
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// ErrJobRunning 同じ名前のジョブが実行中
+var ErrJobRunning = errors.New("job with the same name is already running")
+
+// maxFinishedJobs 保持しておく終了済みジョブの数（古いものから捨てる）
+const maxFinishedJobs = 50
+
+// Job バックグラウンドで実行中の投入ジョブ
+type Job struct {
+	ID    string
+	Name  string
+	Total int64
+
+	done   atomic.Int64
+	cancel context.CancelFunc
+	finish chan struct{}
+
+	mu         sync.Mutex
+	status     Status
+	err        error
+	result     any
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// Progress ジョブの進捗（ポーリング・SSEで返す）
+type Progress struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Status     Status  `json:"status"`
+	Total      int64   `json:"total"`
+	Done       int64   `json:"done"`
+	Percent    float64 `json:"percent"`
+	RowsPerSec float64 `json:"rows_per_sec"`
+	ElapsedMs  int64   `json:"elapsed_ms"`
+	Error      string  `json:"error,omitempty"`
+	Result     any     `json:"result,omitempty"`
+}
+
+// SetDone 投入済みの件数を更新
+func (j *Job) SetDone(n int64) { j.done.Store(n) }
+
+// SetResult 完了時にProgressへ含める値を設定
+func (j *Job) SetResult(v any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.result = v
+}
+
+// Cancel ジョブを中断
+func (j *Job) Cancel() { j.cancel() }
+
+// Finished 終了すると閉じられるチャネル
+func (j *Job) Finished() <-chan struct{} { return j.finish }
+
+func (j *Job) finished() bool {
+	select {
+	case <-j.finish:
+		return true
+	default:
+		return false
+	}
+}
+
+// Err 失敗・中断した場合のエラー
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Progress 現在の進捗
+func (j *Job) Progress() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	p := Progress{
+		ID:     j.ID,
+		Name:   j.Name,
+		Status: j.status,
+		Total:  j.Total,
+		Done:   j.done.Load(),
+		Result: j.result,
+	}
+	end := j.finishedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	elapsed := end.Sub(j.startedAt)
+	p.ElapsedMs = elapsed.Milliseconds()
+	if p.Total > 0 {
+		p.Percent = float64(p.Done) / float64(p.Total) * 100
+	}
+	if elapsed > 0 {
+		p.RowsPerSec = float64(p.Done) / elapsed.Seconds()
+	}
+	if j.err != nil {
+		p.Error = j.err.Error()
+	}
+	return p
+}
+
+// Manager ジョブの起動と一覧を管理する
+type Manager struct {
+	ctx context.Context
+
+	mu   sync.Mutex
+	seq  int
+	jobs map[string]*Job
+}
+
+// NewManager ジョブを起動するManagerを作成（ctxが終了すると実行中のジョブも中断される）
+func NewManager(ctx context.Context) *Manager {
+	return &Manager{ctx: ctx, jobs: map[string]*Job{}}
+}
+
+// Start fnをバックグラウンドで実行するジョブを起動
+//
+// nameは投入先を表し、同じ名前のジョブが実行中の場合はErrJobRunningを返す（TRUNCATEの競合を防ぐ）。
+// fnに渡すctxはリクエストではなくManagerに紐付くため、HTTPレスポンスを返した後も実行が続く。
+func (m *Manager) Start(name string, total int64, fn func(ctx context.Context, job *Job) error) (*Job, error) {
+	m.mu.Lock()
+	for _, j := range m.jobs {
+		if j.Name == name && !j.finished() {
+			m.mu.Unlock()
+			return j, ErrJobRunning
+		}
+	}
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.seq++
+	job := &Job{
+		ID:        name + "-" + strconv.Itoa(m.seq),
+		Name:      name,
+		Total:     total,
+		cancel:    cancel,
+		finish:    make(chan struct{}),
+		status:    StatusRunning,
+		startedAt: time.Now(),
+	}
+	m.jobs[job.ID] = job
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	go func() {
+		defer close(job.finish)
+		defer cancel()
+		err := fn(ctx, job)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.finishedAt = time.Now()
+		switch {
+		case err == nil:
+			job.status = StatusCompleted
+		case errors.Is(err, context.Canceled):
+			job.status = StatusCanceled
+			job.err = err
+		default:
+			job.status = StatusFailed
+			job.err = err
+		}
+	}()
+	return job, nil
+}
+
+// Get IDでジョブを取得
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// List 全ジョブの進捗（新しい順）
+func (m *Manager) List() []Progress {
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].startedAt.After(jobs[k].startedAt) })
+	list := make([]Progress, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, j.Progress())
+	}
+	return list
+}
+
+// pruneLocked 終了済みのジョブが多くなりすぎたら古いものから削除
+func (m *Manager) pruneLocked() {
+	var finished []*Job
+	for _, j := range m.jobs {
+		if j.finished() {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].startedAt.Before(finished[k].startedAt) })
+	for _, j := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, j.ID)
+	}
+}
@@ -0,0 +1,114 @@
+package bulkload
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func waitFinished(t *testing.T, job *Job) {
+	t.Helper()
+	select {
+	case <-job.Finished():
+	case <-time.After(time.Second):
+		t.Fatal("job did not finish")
+	}
+}
+
+func TestManager_JobLifecycle(t *testing.T) {
+	m := NewManager(context.Background())
+
+	release := make(chan struct{})
+	job, err := m.Start("logs", 100, func(ctx context.Context, job *Job) error {
+		job.SetDone(40)
+		<-release
+		job.SetDone(100)
+		job.SetResult(map[string]int{"rows": 100})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if running, err := m.Start("logs", 100, nil); !errors.Is(err, ErrJobRunning) || running != job {
+		t.Errorf("Start() while running = %v, %v; expected ErrJobRunning", running, err)
+	}
+
+	got, ok := m.Get(job.ID)
+	if !ok || got != job {
+		t.Fatalf("Get(%q) = %v, %v", job.ID, got, ok)
+	}
+	if p := job.Progress(); p.Status != StatusRunning {
+		t.Errorf("Status = %s; expected running", p.Status)
+	}
+
+	close(release)
+	waitFinished(t, job)
+
+	p := job.Progress()
+	if p.Status != StatusCompleted || p.Done != 100 || p.Percent != 100 || p.Result == nil {
+		t.Errorf("Progress() = %+v; expected completed 100/100 with result", p)
+	}
+}
+
+func TestManager_FailedAndCanceled(t *testing.T) {
+	m := NewManager(context.Background())
+
+	failed, _ := m.Start("fail", 1, func(ctx context.Context, job *Job) error {
+		return errors.New("boom")
+	})
+	waitFinished(t, failed)
+	if p := failed.Progress(); p.Status != StatusFailed || p.Error != "boom" {
+		t.Errorf("Progress() = %+v; expected failed with error", p)
+	}
+
+	canceled, _ := m.Start("cancel", 1, func(ctx context.Context, job *Job) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	canceled.Cancel()
+	waitFinished(t, canceled)
+	if p := canceled.Progress(); p.Status != StatusCanceled {
+		t.Errorf("Status = %s; expected canceled", p.Status)
+	}
+
+	if list := m.List(); len(list) != 2 || list[0].ID != canceled.ID {
+		t.Errorf("List() = %+v; expected newest first", list)
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, name := range []string{DistributionSequential, DistributionUniform, DistributionZipf, DistributionNormal} {
+		d, err := NewDistribution(name, 0, r)
+		if err != nil {
+			t.Fatalf("NewDistribution(%q) error = %v", name, err)
+		}
+		counts := make([]int, 10)
+		for i := 0; i < 10000; i++ {
+			v := d.Sample(i, len(counts))
+			if v < 0 || v >= len(counts) {
+				t.Fatalf("%s: Sample() = %d; out of range", name, v)
+			}
+			counts[v]++
+		}
+		switch name {
+		case DistributionZipf:
+			if counts[0] < counts[9]*5 {
+				t.Errorf("zipf counts = %v; expected skew towards 0", counts)
+			}
+		case DistributionNormal:
+			if counts[4]+counts[5] < counts[0]+counts[9] {
+				t.Errorf("normal counts = %v; expected peak in the middle", counts)
+			}
+		}
+	}
+
+	if _, err := NewDistribution("pareto", 0, r); err == nil {
+		t.Error("expected error for unknown distribution")
+	}
+	if _, err := NewDistribution(DistributionZipf, 0.5, r); err == nil {
+		t.Error("expected error for zipf skew <= 1")
+	}
+}
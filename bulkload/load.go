@@ -0,0 +1,195 @@
+// Package bulkload はPostgreSQLのCOPY FROM STDINでテストデータを高速に投入する
+//
+// 行は1行ずつ生成しながら送るため、件数が多くてもメモリにすべてを載せない。
+package bulkload
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Dataset 投入するテーブルと行の生成方法
+type Dataset struct {
+	Table   string
+	Columns []string
+	// Schema テーブルが無い場合に実行するDDL（省略時は既存テーブル前提）
+	Schema string
+	// Row i行目（0始まり）の値をColumnsの順に返す
+	Row func(i int, g *Generator) []any
+}
+
+// Generator 行の生成に使う乱数・分布・基準時刻
+type Generator struct {
+	Rand *rand.Rand
+	Dist Distribution
+	Now  time.Time
+}
+
+// Pick Distに従ってchoicesから1つ選ぶ
+func Pick[T any](g *Generator, i int, choices []T) T {
+	return choices[g.Dist.Sample(i, len(choices))]
+}
+
+// Config 投入件数や分布の設定
+type Config struct {
+	Rows         int     `json:"rows"`
+	Distribution string  `json:"distribution"`
+	Skew         float64 `json:"skew,omitempty"`
+	Seed         int64   `json:"seed,omitempty"` // 0の場合は現在時刻
+	Truncate     bool    `json:"truncate"`       // 投入前にTRUNCATE ... RESTART IDENTITYする
+}
+
+// MaxRows 1ジョブで投入できる最大件数
+const MaxRows = 10_000_000
+
+// progressInterval 何行ごとに進捗を通知するか
+const progressInterval = 1000
+
+// Validate 設定値を検証
+func (c Config) Validate() error {
+	if c.Rows <= 0 || c.Rows > MaxRows {
+		return fmt.Errorf("rows must be 1-%d", MaxRows)
+	}
+	if _, err := NewDistribution(c.Distribution, c.Skew, rand.New(rand.NewSource(1))); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Copy datasetをcfg.Rows件COPYで投入し、投入した件数を返す
+//
+// TRUNCATEとCOPYは同じトランザクションで実行するため、失敗した場合は元のデータが残る。
+// progressには投入済みの件数が定期的に渡される（nil可）。
+func Copy(ctx context.Context, db *sql.DB, ds Dataset, cfg Config, progress func(done int64)) (int64, error) {
+	if err := cfg.Validate(); err != nil {
+		return 0, err
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+	dist, err := NewDistribution(cfg.Distribution, cfg.Skew, r)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var copied int64
+	err = conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("COPY requires the pgx driver, got %T", driverConn)
+		}
+		copied, err = copyRows(ctx, c.Conn(), ds, cfg, &Generator{Rand: r, Dist: dist, Now: time.Now()}, progress)
+		return err
+	})
+	return copied, err
+}
+
+func copyRows(ctx context.Context, conn *pgx.Conn, ds Dataset, cfg Config, g *Generator, progress func(int64)) (int64, error) {
+	if ds.Schema != "" {
+		if _, err := conn.Exec(ctx, ds.Schema); err != nil {
+			return 0, fmt.Errorf("failed to create %s: %w", ds.Table, err)
+		}
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if cfg.Truncate {
+		if _, err := tx.Exec(ctx, "TRUNCATE TABLE "+pgx.Identifier{ds.Table}.Sanitize()+" RESTART IDENTITY"); err != nil {
+			return 0, fmt.Errorf("failed to truncate %s: %w", ds.Table, err)
+		}
+	}
+
+	src := &rowSource{ds: ds, g: g, total: cfg.Rows, progress: progress}
+	copied, err := tx.CopyFrom(ctx, pgx.Identifier{ds.Table}, ds.Columns, src)
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy into %s: %w", ds.Table, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	if progress != nil {
+		progress(copied)
+	}
+	return copied, nil
+}
+
+// rowSource pgx.CopyFromSourceの実装（COPYが読むたびに1行ずつ生成する）
+type rowSource struct {
+	ds       Dataset
+	g        *Generator
+	total    int
+	i        int
+	row      []any
+	progress func(int64)
+}
+
+func (s *rowSource) Next() bool {
+	if s.i >= s.total {
+		return false
+	}
+	s.row = s.ds.Row(s.i, s.g)
+	s.i++
+	if s.progress != nil && s.i%progressInterval == 0 {
+		s.progress(int64(s.i))
+	}
+	return true
+}
+
+func (s *rowSource) Values() ([]any, error) {
+	if len(s.row) != len(s.ds.Columns) {
+		return nil, fmt.Errorf("%s: row has %d values, expected %d", s.ds.Table, len(s.row), len(s.ds.Columns))
+	}
+	return s.row, nil
+}
+
+func (s *rowSource) Err() error { return nil }
+
+// ConfigFromQuery クエリパラメータ（rows, distribution, skew, seed）から設定を作る
+func ConfigFromQuery(q url.Values, defaults Config) (Config, error) {
+	cfg := defaults
+	if raw := q.Get("rows"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid rows: %w", err)
+		}
+		cfg.Rows = n
+	}
+	if raw := q.Get("distribution"); raw != "" {
+		cfg.Distribution = raw
+	}
+	if raw := q.Get("skew"); raw != "" {
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid skew: %w", err)
+		}
+		cfg.Skew = f
+	}
+	if raw := q.Get("seed"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid seed: %w", err)
+		}
+		cfg.Seed = n
+	}
+	return cfg, cfg.Validate()
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultBusChannel 無効化メッセージを流すRedis Pub/Subチャネル
+const DefaultBusChannel = "cache:invalidate"
+
+// invalidation 他インスタンスへ送る無効化メッセージ
+type invalidation struct {
+	Origin string `json:"origin"` // 送信元インスタンスID（自分のメッセージは無視する）
+	Prefix string `json:"prefix"`
+	Key    string `json:"key"`
+}
+
+// Bus Redis Pub/Subで他のアプリインスタンスにL1(インプロセス)キャッシュの無効化を伝える
+type Bus struct {
+	redis    *redis.Client
+	channel  string
+	instance string
+
+	mu       sync.RWMutex
+	handlers map[string]func(key string) // Prefixごとの無効化処理
+}
+
+// NewBus 無効化バスを作成
+func NewBus(rdb *redis.Client, channel string) *Bus {
+	if channel == "" {
+		channel = DefaultBusChannel
+	}
+	return &Bus{
+		redis:    rdb,
+		channel:  channel,
+		instance: uuid.NewString(),
+		handlers: make(map[string]func(key string)),
+	}
+}
+
+// register Prefixに対応する無効化処理を登録
+func (b *Bus) register(prefix string, fn func(key string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[prefix] = fn
+}
+
+// Publish 他インスタンスへキーの無効化を通知
+func (b *Bus) Publish(ctx context.Context, prefix, key string) error {
+	data, err := json.Marshal(invalidation{Origin: b.instance, Prefix: prefix, Key: key})
+	if err != nil {
+		return err
+	}
+	return b.redis.Publish(ctx, b.channel, data).Err()
+}
+
+// Run 無効化メッセージを購読し続ける（ctxがキャンセルされるまでブロック）
+func (b *Bus) Run(ctx context.Context) {
+	for {
+		if err := b.subscribe(ctx); err != nil {
+			log.Printf("cache bus: subscription error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+			// 再接続
+		}
+	}
+}
+
+func (b *Bus) subscribe(ctx context.Context) error {
+	sub := b.redis.Subscribe(ctx, b.channel)
+	defer sub.Close()
+
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			b.dispatch(msg.Payload)
+		}
+	}
+}
+
+func (b *Bus) dispatch(payload string) {
+	var msg invalidation
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		log.Printf("cache bus: invalid message %q: %v", payload, err)
+		return
+	}
+	if msg.Origin == b.instance {
+		return
+	}
+
+	b.mu.RLock()
+	fn, ok := b.handlers[msg.Prefix]
+	b.mu.RUnlock()
+	if ok {
+		fn(msg.Key)
+	}
+}
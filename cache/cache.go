@@ -0,0 +1,506 @@
+// Package cache はインプロセスLRU(L1)とRedis(L2)を組み合わせたリードスルーキャッシュ
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound ローダーが「存在しない」と判断した場合に返すエラー（ネガティブキャッシュされる）
+var ErrNotFound = errors.New("cache: not found")
+
+// Source 値の取得元
+type Source string
+
+const (
+	SourceL1     Source = "memory"
+	SourceL2     Source = "redis"
+	SourceLoader Source = "loader"
+)
+
+// Loader キャッシュミス時に値を読み込む関数
+type Loader[T any] func(ctx context.Context) (T, error)
+
+// Config キャッシュの設定
+type Config struct {
+	Prefix      string        // Redisキーのプレフィックス
+	L1Capacity  int           // インプロセスLRUの最大エントリ数
+	L1TTL       time.Duration // インプロセスLRUのTTL
+	L2TTL       time.Duration // RedisのTTL（エントリごとにWithTTLで上書き可能）
+	NegativeTTL time.Duration // 「存在しない」結果をキャッシュする期間
+
+	// キャッシュスタンピード対策
+	Coalesce         bool          // 同一キーの読み込みをプロセス内(singleflight)とインスタンス間(Redisロック)で1本化する
+	LockTTL          time.Duration // Redisロックの有効期間（ロック待ちの最大時間も兼ねる）
+	StaleTTL         time.Duration // 期限切れ後も古い値を返しつつ裏で更新する期間（0で無効）
+	EarlyRefreshBeta float64       // XFetchによる確率的早期更新の係数（0で無効、1が標準）
+
+	// L2に保存する際の形式（nilの場合はJSON・圧縮なし）
+	Codec       Codec
+	Compression Compression
+}
+
+// DefaultConfig デフォルト設定を返す
+func DefaultConfig(prefix string) Config {
+	return Config{
+		Prefix:      prefix,
+		L1Capacity:  1000,
+		L1TTL:       30 * time.Second,
+		L2TTL:       5 * time.Minute,
+		NegativeTTL: 30 * time.Second,
+		LockTTL:     5 * time.Second,
+	}
+}
+
+// Option エントリ単位のオプション
+type Option func(*entryOptions)
+
+type entryOptions struct {
+	ttl  time.Duration
+	tags []string
+}
+
+// WithTTL エントリごとのTTLを指定（L1はConfig.L1TTLとの短い方を使う）
+func WithTTL(ttl time.Duration) Option {
+	return func(o *entryOptions) {
+		o.ttl = ttl
+	}
+}
+
+// Result 取得結果と取得元
+type Result[T any] struct {
+	Value  T
+	Source Source
+	Stale  bool // 期限切れの値を返した（裏で更新中）
+}
+
+// entry L1/L2に保存する値（Negative=trueの場合は「存在しない」ことをキャッシュ）
+type entry[T any] struct {
+	Value     T     `json:"v,omitempty"`
+	Negative  bool  `json:"neg,omitempty"`
+	ExpiresAt int64 `json:"exp,omitempty"`   // 論理的な有効期限（UnixMilli）
+	Delta     int64 `json:"delta,omitempty"` // 読み込みにかかった時間（ミリ秒、XFetch用）
+}
+
+// Cache L1(LRU) + L2(Redis)の2層リードスルーキャッシュ
+type Cache[T any] struct {
+	config Config
+	l1     *LRU[entry[T]]
+	redis  *redis.Client // nilの場合はL1のみで動作
+	bus    *Bus          // nilの場合は他インスタンスへ無効化を伝えない
+
+	flight     singleflight.Group
+	refreshing sync.Map // 裏で更新中のキー
+
+	tagsMu    sync.Mutex
+	localTags map[string]map[string]struct{} // タグ -> キー（L1のみで動作する場合の索引）
+
+	l1Hits       atomic.Int64
+	l2Hits       atomic.Int64
+	negativeHits atomic.Int64
+	misses       atomic.Int64
+	loads        atomic.Int64
+	loadErrors   atomic.Int64
+	l2Errors     atomic.Int64
+	coalesced    atomic.Int64
+	lockWaits    atomic.Int64
+	staleServed  atomic.Int64
+	refreshes    atomic.Int64
+}
+
+// New キャッシュを作成
+func New[T any](rdb *redis.Client, config Config) *Cache[T] {
+	defaults := DefaultConfig(config.Prefix)
+	if config.L1Capacity <= 0 {
+		config.L1Capacity = defaults.L1Capacity
+	}
+	if config.L1TTL <= 0 {
+		config.L1TTL = defaults.L1TTL
+	}
+	if config.L2TTL <= 0 {
+		config.L2TTL = defaults.L2TTL
+	}
+	if config.NegativeTTL <= 0 {
+		config.NegativeTTL = defaults.NegativeTTL
+	}
+	if config.LockTTL <= 0 {
+		config.LockTTL = defaults.LockTTL
+	}
+	if config.Codec == nil {
+		config.Codec = JSON
+	}
+	if config.Compression == nil {
+		config.Compression = NoCompression
+	}
+
+	return &Cache[T]{
+		config:    config,
+		l1:        NewLRU[entry[T]](config.L1Capacity),
+		redis:     rdb,
+		localTags: make(map[string]map[string]struct{}),
+	}
+}
+
+// Get キーに対応する値を取得し、なければloaderで読み込んでL1/L2に保存する
+func (c *Cache[T]) Get(ctx context.Context, key string, loader Loader[T], opts ...Option) (T, error) {
+	result, err := c.Fetch(ctx, key, loader, opts...)
+	return result.Value, err
+}
+
+// Fetch Getと同じだが、値の取得元も返す
+func (c *Cache[T]) Fetch(ctx context.Context, key string, loader Loader[T], opts ...Option) (Result[T], error) {
+	o := c.entryOptions(opts)
+
+	if e, source, ok := c.lookup(ctx, key); ok {
+		now := time.Now()
+		switch {
+		case !e.expired(now):
+			// XFetch: 期限が近いほど高い確率で1リクエストだけ早期に更新する
+			if c.shouldRefreshEarly(e, now) {
+				c.refreshes.Add(1)
+				c.refreshInBackground(ctx, key, loader, o)
+			}
+			return c.resultOf(e, source)
+		case c.config.StaleTTL > 0:
+			// stale-while-revalidate: 古い値を返しつつ裏で更新
+			c.staleServed.Add(1)
+			c.refreshInBackground(ctx, key, loader, o)
+			result, err := c.resultOf(e, source)
+			result.Stale = true
+			return result, err
+		}
+	}
+
+	c.misses.Add(1)
+	e, err := c.load(ctx, key, loader, o)
+	if err != nil {
+		return Result[T]{Source: SourceLoader}, err
+	}
+	if e.Negative {
+		return Result[T]{Source: SourceLoader}, ErrNotFound
+	}
+	return Result[T]{Value: e.Value, Source: SourceLoader}, nil
+}
+
+// lookup L1 -> L2の順に探す
+func (c *Cache[T]) lookup(ctx context.Context, key string) (entry[T], Source, bool) {
+	if e, ok := c.l1.Get(key); ok {
+		c.l1Hits.Add(1)
+		return e, SourceL1, true
+	}
+	if e, ok := c.getL2(ctx, key); ok {
+		c.l2Hits.Add(1)
+		c.setL1(key, e)
+		return e, SourceL2, true
+	}
+	return entry[T]{}, "", false
+}
+
+// load ローダーを呼び出して保存する（Coalesce有効時は同一キーの読み込みを1本化）
+func (c *Cache[T]) load(ctx context.Context, key string, loader Loader[T], o entryOptions) (entry[T], error) {
+	if !c.config.Coalesce {
+		return c.loadAndStore(ctx, key, loader, o)
+	}
+
+	v, err, shared := c.flight.Do(key, func() (interface{}, error) {
+		return c.loadWithLock(ctx, key, loader, o)
+	})
+	if shared {
+		c.coalesced.Add(1)
+	}
+	if err != nil {
+		return entry[T]{}, err
+	}
+	return v.(entry[T]), nil
+}
+
+// loadAndStore ローダーを呼び出し、結果（ErrNotFoundならネガティブエントリ）を保存する
+func (c *Cache[T]) loadAndStore(ctx context.Context, key string, loader Loader[T], o entryOptions) (entry[T], error) {
+	c.loads.Add(1)
+	start := time.Now()
+	value, err := loader(ctx)
+
+	var e entry[T]
+	switch {
+	case errors.Is(err, ErrNotFound):
+		e = entry[T]{Negative: true}
+	case err != nil:
+		c.loadErrors.Add(1)
+		return entry[T]{}, err
+	default:
+		e = entry[T]{Value: value}
+	}
+
+	e.Delta = time.Since(start).Milliseconds()
+	e.ExpiresAt = time.Now().Add(c.ttlOf(e, o)).UnixMilli()
+	c.store(ctx, key, e)
+	c.tag(ctx, key, o)
+	return e, nil
+}
+
+// Set 値を直接L1/L2に保存
+func (c *Cache[T]) Set(ctx context.Context, key string, value T, opts ...Option) {
+	o := c.entryOptions(opts)
+	e := entry[T]{Value: value}
+	e.ExpiresAt = time.Now().Add(c.ttlOf(e, o)).UnixMilli()
+	c.store(ctx, key, e)
+	c.tag(ctx, key, o)
+}
+
+// Delete キーをL1/L2の両方から削除し、他インスタンスのL1も無効化する
+func (c *Cache[T]) Delete(ctx context.Context, key string) error {
+	c.l1.Delete(key)
+	if c.redis == nil {
+		return nil
+	}
+	if err := c.redis.Del(ctx, c.redisKey(key)).Err(); err != nil {
+		return fmt.Errorf("failed to delete %s from redis: %w", key, err)
+	}
+	c.broadcast(ctx, key)
+	return nil
+}
+
+// InvalidateLocal このインスタンスのL1からだけキーを削除
+func (c *Cache[T]) InvalidateLocal(key string) {
+	c.l1.Delete(key)
+}
+
+// AttachBus 無効化バスに登録し、他インスタンスからの無効化を受け取れるようにする
+func (c *Cache[T]) AttachBus(b *Bus) {
+	c.bus = b
+	b.register(c.config.Prefix, c.InvalidateLocal)
+}
+
+// Update キャッシュ済みの値をfnで差分更新する（キャッシュされていなければ何もしない）
+//
+// fnがfalseを返した場合は差分更新を諦めてキーを削除する。
+// L2はWATCHによる楽観的ロックで更新し、TTLと論理的な有効期限は元のエントリを引き継ぐ。
+func (c *Cache[T]) Update(ctx context.Context, key string, fn func(current T) (T, bool)) error {
+	if c.redis == nil {
+		e, ok := c.l1.Get(key)
+		if !ok || e.Negative {
+			c.l1.Delete(key)
+			return nil
+		}
+		next, keep := fn(e.Value)
+		if !keep {
+			c.l1.Delete(key)
+			return nil
+		}
+		e.Value = next
+		c.setL1(key, e)
+		return nil
+	}
+
+	redisKey := c.redisKey(key)
+	var updated *entry[T]
+	txf := func(tx *redis.Tx) error {
+		updated = nil
+		data, err := tx.Get(ctx, redisKey).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		e, err := decodeEntry[T](data)
+		if err != nil || e.Negative {
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, redisKey)
+				return nil
+			})
+			return err
+		}
+
+		next, keep := fn(e.Value)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if !keep {
+				pipe.Del(ctx, redisKey)
+				return nil
+			}
+			e.Value = next
+			encoded, err := encodeEntry(e, c.config.Codec, c.config.Compression)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, redisKey, encoded, redis.KeepTTL)
+			updated = &e
+			return nil
+		})
+		return err
+	}
+
+	const maxRetries = 5
+	var err error
+	for i := 0; i < maxRetries; i++ {
+		err = c.redis.Watch(ctx, txf, redisKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		// 差分更新に失敗した場合は削除して次回の読み込みに任せる
+		log.Printf("cache: failed to update %s, invalidating: %v", key, err)
+		return c.Delete(ctx, key)
+	}
+
+	if updated != nil {
+		c.setL1(key, *updated)
+	} else {
+		c.l1.Delete(key)
+	}
+	c.broadcast(ctx, key)
+	return nil
+}
+
+// broadcast 他インスタンスのL1を無効化する
+func (c *Cache[T]) broadcast(ctx context.Context, key string) {
+	if c.bus == nil {
+		return
+	}
+	if err := c.bus.Publish(ctx, c.config.Prefix, key); err != nil {
+		log.Printf("cache: failed to publish invalidation of %s: %v", key, err)
+	}
+}
+
+func (c *Cache[T]) entryOptions(opts []Option) entryOptions {
+	o := entryOptions{ttl: c.config.L2TTL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (c *Cache[T]) resultOf(e entry[T], source Source) (Result[T], error) {
+	if e.Negative {
+		c.negativeHits.Add(1)
+		return Result[T]{Source: source}, ErrNotFound
+	}
+	return Result[T]{Value: e.Value, Source: source}, nil
+}
+
+func (c *Cache[T]) ttlOf(e entry[T], o entryOptions) time.Duration {
+	if e.Negative {
+		return c.config.NegativeTTL
+	}
+	return o.ttl
+}
+
+// physicalTTL 論理的な有効期限 + stale期間（この間はL1/L2に残す）
+func (c *Cache[T]) physicalTTL(e entry[T]) time.Duration {
+	if e.ExpiresAt == 0 {
+		return c.config.L2TTL
+	}
+	return time.Until(time.UnixMilli(e.ExpiresAt)) + c.config.StaleTTL
+}
+
+// setL1 L1に保存する（TTLはConfig.L1TTLと残り期間の短い方）
+func (c *Cache[T]) setL1(key string, e entry[T]) {
+	if ttl := min(c.config.L1TTL, c.physicalTTL(e)); ttl > 0 {
+		c.l1.Set(key, e, ttl)
+	} else {
+		c.l1.Delete(key)
+	}
+}
+
+func (c *Cache[T]) store(ctx context.Context, key string, e entry[T]) {
+	ttl := c.physicalTTL(e)
+	if ttl <= 0 {
+		return
+	}
+	c.setL1(key, e)
+
+	if c.redis == nil {
+		return
+	}
+	data, err := encodeEntry(e, c.config.Codec, c.config.Compression)
+	if err != nil {
+		c.l2Errors.Add(1)
+		log.Printf("cache: failed to encode %s: %v", key, err)
+		return
+	}
+	if err := c.redis.Set(ctx, c.redisKey(key), data, ttl).Err(); err != nil {
+		c.l2Errors.Add(1)
+		log.Printf("cache: failed to store %s in redis: %v", key, err)
+	}
+}
+
+func (c *Cache[T]) getL2(ctx context.Context, key string) (entry[T], bool) {
+	var e entry[T]
+	if c.redis == nil {
+		return e, false
+	}
+
+	data, err := c.redis.Get(ctx, c.redisKey(key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			// Redis障害時はローダーにフォールバック
+			c.l2Errors.Add(1)
+			log.Printf("cache: failed to get %s from redis: %v", key, err)
+		}
+		return e, false
+	}
+
+	e, err = decodeEntry[T](data)
+	if err != nil {
+		c.l2Errors.Add(1)
+		log.Printf("cache: failed to decode %s: %v", key, err)
+		return e, false
+	}
+	return e, true
+}
+
+func (c *Cache[T]) redisKey(key string) string {
+	if c.config.Prefix == "" {
+		return key
+	}
+	return c.config.Prefix + ":" + key
+}
+
+// Stats ヒット・ミスの統計
+type Stats struct {
+	L1Hits         int64   `json:"l1_hits"`
+	L2Hits         int64   `json:"l2_hits"`
+	NegativeHits   int64   `json:"negative_hits"`
+	Misses         int64   `json:"misses"`
+	Loads          int64   `json:"loads"`
+	LoadErrors     int64   `json:"load_errors"`
+	L2Errors       int64   `json:"l2_errors"`
+	Coalesced      int64   `json:"coalesced"`
+	LockWaits      int64   `json:"lock_waits"`
+	StaleServed    int64   `json:"stale_served"`
+	EarlyRefreshes int64   `json:"early_refreshes"`
+	L1Size         int     `json:"l1_size"`
+	HitRatio       float64 `json:"hit_ratio"`
+}
+
+// Stats 現在の統計を返す
+func (c *Cache[T]) Stats() Stats {
+	s := Stats{
+		L1Hits:         c.l1Hits.Load(),
+		L2Hits:         c.l2Hits.Load(),
+		NegativeHits:   c.negativeHits.Load(),
+		Misses:         c.misses.Load(),
+		Loads:          c.loads.Load(),
+		LoadErrors:     c.loadErrors.Load(),
+		L2Errors:       c.l2Errors.Load(),
+		Coalesced:      c.coalesced.Load(),
+		LockWaits:      c.lockWaits.Load(),
+		StaleServed:    c.staleServed.Load(),
+		EarlyRefreshes: c.refreshes.Load(),
+		L1Size:         c.l1.Len(),
+	}
+	if total := s.L1Hits + s.L2Hits + s.Misses; total > 0 {
+		s.HitRatio = float64(s.L1Hits+s.L2Hits) / float64(total)
+	}
+	return s
+}
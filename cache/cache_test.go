@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRU_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU[int](2)
+	c.Set("a", 1, 0)
+	c.Set("b", 2, 0)
+	c.Get("a") // aを最新にする
+	c.Set("c", 3, 0)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %d, %v; expected 1, true", v, ok)
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Errorf("Get(c) = %d, %v; expected 3, true", v, ok)
+	}
+}
+
+func TestLRU_ExpiresEntries(t *testing.T) {
+	c := NewLRU[string](10)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	c.Set("short", "x", time.Second)
+	c.Set("forever", "y", 0)
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("short"); ok {
+		t.Error("expected short to be expired")
+	}
+	if _, ok := c.Get("forever"); !ok {
+		t.Error("expected forever to be present")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Len() = %d; expected 1", c.Len())
+	}
+}
+
+func TestCache_ReadThroughWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+	c := New[[]string](nil, DefaultConfig("test"))
+
+	loads := 0
+	loader := func(ctx context.Context) ([]string, error) {
+		loads++
+		return []string{"a", "b"}, nil
+	}
+
+	first, err := c.Fetch(ctx, "k", loader)
+	if err != nil || first.Source != SourceLoader {
+		t.Fatalf("first Fetch = %v, %v; expected loader source", first.Source, err)
+	}
+	second, err := c.Fetch(ctx, "k", loader)
+	if err != nil || second.Source != SourceL1 {
+		t.Fatalf("second Fetch = %v, %v; expected memory source", second.Source, err)
+	}
+	if loads != 1 {
+		t.Errorf("loader called %d times; expected 1", loads)
+	}
+
+	stats := c.Stats()
+	if stats.L1Hits != 1 || stats.Misses != 1 || stats.HitRatio != 0.5 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCache_NegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	c := New[int](nil, DefaultConfig("test"))
+
+	loads := 0
+	loader := func(ctx context.Context) (int, error) {
+		loads++
+		return 0, ErrNotFound
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(ctx, "missing", loader); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get() error = %v; expected ErrNotFound", err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loader called %d times; expected 1", loads)
+	}
+	if got := c.Stats().NegativeHits; got != 2 {
+		t.Errorf("NegativeHits = %d; expected 2", got)
+	}
+}
+
+func TestCache_LoaderErrorIsNotCached(t *testing.T) {
+	ctx := context.Background()
+	c := New[int](nil, DefaultConfig("test"))
+
+	boom := errors.New("boom")
+	if _, err := c.Get(ctx, "k", func(ctx context.Context) (int, error) { return 0, boom }); !errors.Is(err, boom) {
+		t.Fatalf("Get() error = %v; expected boom", err)
+	}
+	v, err := c.Get(ctx, "k", func(ctx context.Context) (int, error) { return 42, nil })
+	if err != nil || v != 42 {
+		t.Errorf("Get() = %d, %v; expected 42, nil", v, err)
+	}
+}
+
+func TestCache_CoalescesConcurrentLoads(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultConfig("test")
+	config.Coalesce = true
+	c := New[int](nil, config)
+
+	var loads atomic.Int64
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (int, error) {
+		loads.Add(1)
+		<-release
+		return 1, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(ctx, "k", loader); err != nil {
+				t.Errorf("Get() error = %v", err)
+			}
+		}()
+	}
+	// 全goroutineがsingleflightで待つまで少し待つ
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := loads.Load(); got != 1 {
+		t.Errorf("loader called %d times; expected 1", got)
+	}
+}
+
+func TestCache_StaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultConfig("test")
+	config.StaleTTL = time.Minute
+	c := New[string](nil, config)
+
+	c.Set(ctx, "k", "old", WithTTL(time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	refreshed := make(chan struct{})
+	result, err := c.Fetch(ctx, "k", func(ctx context.Context) (string, error) {
+		defer close(refreshed)
+		return "new", nil
+	})
+	if err != nil || result.Value != "old" || !result.Stale {
+		t.Fatalf("Fetch() = %+v, %v; expected stale old value", result, err)
+	}
+
+	<-refreshed
+	time.Sleep(10 * time.Millisecond)
+	v, err := c.Get(ctx, "k", func(ctx context.Context) (string, error) {
+		t.Error("loader should not be called after refresh")
+		return "", nil
+	})
+	if err != nil || v != "new" {
+		t.Errorf("Get() = %q, %v; expected refreshed value", v, err)
+	}
+}
+
+func TestCache_InvalidateTag(t *testing.T) {
+	ctx := context.Background()
+	c := New[string](nil, DefaultConfig("test"))
+
+	c.Set(ctx, "error-1", "a", WithTags("level:ERROR", "all"))
+	c.Set(ctx, "error-2", "b", WithTags("level:ERROR", "all"))
+	c.Set(ctx, "info-1", "c", WithTags("level:INFO", "all"))
+
+	n, err := c.InvalidateTag(ctx, "level:ERROR")
+	if err != nil || n != 2 {
+		t.Fatalf("InvalidateTag() = %d, %v; expected 2, nil", n, err)
+	}
+
+	loader := func(ctx context.Context) (string, error) { return "reloaded", nil }
+	if v, _ := c.Get(ctx, "error-1", loader); v != "reloaded" {
+		t.Errorf("error-1 = %q; expected to be reloaded", v)
+	}
+	if v, _ := c.Get(ctx, "info-1", loader); v != "c" {
+		t.Errorf("info-1 = %q; expected to remain cached", v)
+	}
+}
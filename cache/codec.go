@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec キャッシュする値のシリアライズ方式
+//
+// IDはL2に保存するヘッダーに書き込まれ、読み込み時はヘッダーのIDで復号する。
+// そのため設定を切り替えている途中（ロールアウト中）でも、古い方式のエントリを読める。
+type Codec interface {
+	ID() byte
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Compression エンコード後のバイト列の圧縮方式
+type Compression interface {
+	ID() byte
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	// JSON encoding/json
+	JSON Codec = jsonCodec{}
+	// Gob encoding/gob
+	Gob Codec = gobCodec{}
+	// MessagePack github.com/vmihailenco/msgpack
+	MessagePack Codec = msgpackCodec{}
+	// Binary 値自身のMarshalBinary/UnmarshalBinaryを使う（protobuf形式などの独自バイナリ用）
+	Binary Codec = binaryCodec{}
+
+	// NoCompression 圧縮しない
+	NoCompression Compression = noCompression{}
+	// Zstd zstandard圧縮（圧縮率重視）
+	Zstd Compression = zstdCompression{}
+	// Snappy snappy互換形式の圧縮（速度重視）
+	Snappy Compression = snappyCompression{}
+)
+
+var (
+	registryMu   sync.RWMutex
+	codecs       = map[byte]Codec{}
+	compressions = map[byte]Compression{}
+)
+
+func init() {
+	for _, c := range []Codec{JSON, Gob, MessagePack, Binary} {
+		RegisterCodec(c)
+	}
+	for _, c := range []Compression{NoCompression, Zstd, Snappy} {
+		RegisterCompression(c)
+	}
+}
+
+// RegisterCodec 独自のCodecを登録（L2から読み込む際にIDで引けるようにする）
+func RegisterCodec(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	codecs[c.ID()] = c
+}
+
+// RegisterCompression 独自のCompressionを登録
+func RegisterCompression(c Compression) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	compressions[c.ID()] = c
+}
+
+func lookupCodec(id byte) Codec {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return codecs[id]
+}
+
+func lookupCompression(id byte) Compression {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return compressions[id]
+}
+
+// L2に保存するエントリのヘッダー
+//
+//	[0]     magic (0xCA)
+//	[1]     version
+//	[2]     codec ID
+//	[3]     compression ID
+//	[4]     flags (bit0: negative)
+//	[5:13]  ExpiresAt (big endian int64)
+//	[13:21] Delta (big endian int64)
+//	[21:]   圧縮済みの値
+const (
+	headerMagic   = 0xCA
+	headerVersion = 1
+	headerSize    = 21
+
+	flagNegative = 1 << 0
+)
+
+// encodeEntry ヘッダー付きでエントリをエンコード
+func encodeEntry[T any](e entry[T], codec Codec, compression Compression) ([]byte, error) {
+	var payload []byte
+	if !e.Negative {
+		encoded, err := codec.Marshal(&e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", codec.Name(), err)
+		}
+		if payload, err = compression.Compress(encoded); err != nil {
+			return nil, fmt.Errorf("%s: %w", compression.Name(), err)
+		}
+	}
+
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	buf[0] = headerMagic
+	buf[1] = headerVersion
+	buf[2] = codec.ID()
+	buf[3] = compression.ID()
+	if e.Negative {
+		buf[4] |= flagNegative
+	}
+	binary.BigEndian.PutUint64(buf[5:13], uint64(e.ExpiresAt))
+	binary.BigEndian.PutUint64(buf[13:21], uint64(e.Delta))
+	return append(buf, payload...), nil
+}
+
+// decodeEntry ヘッダーのcodec/compressionに従ってデコード（ヘッダーのない旧形式のJSONも読める）
+func decodeEntry[T any](data []byte) (entry[T], error) {
+	var e entry[T]
+	if len(data) > 0 && data[0] == '{' {
+		err := json.Unmarshal(data, &e)
+		return e, err
+	}
+
+	if len(data) < headerSize || data[0] != headerMagic {
+		return e, errors.New("unknown cache entry format")
+	}
+	if data[1] != headerVersion {
+		return e, fmt.Errorf("unsupported cache entry version %d", data[1])
+	}
+
+	codec := lookupCodec(data[2])
+	if codec == nil {
+		return e, fmt.Errorf("unknown codec id %d", data[2])
+	}
+	compression := lookupCompression(data[3])
+	if compression == nil {
+		return e, fmt.Errorf("unknown compression id %d", data[3])
+	}
+
+	e.Negative = data[4]&flagNegative != 0
+	e.ExpiresAt = int64(binary.BigEndian.Uint64(data[5:13]))
+	e.Delta = int64(binary.BigEndian.Uint64(data[13:21]))
+	if e.Negative {
+		return e, nil
+	}
+
+	payload, err := compression.Decompress(data[headerSize:])
+	if err != nil {
+		return e, fmt.Errorf("%s: %w", compression.Name(), err)
+	}
+	if err := codec.Unmarshal(payload, &e.Value); err != nil {
+		return e, fmt.Errorf("%s: %w", codec.Name(), err)
+	}
+	return e, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte                           { return 1 }
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte     { return 2 }
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte                           { return 3 }
+func (msgpackCodec) Name() string                       { return "msgpack" }
+func (msgpackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+type binaryCodec struct{}
+
+func (binaryCodec) ID() byte     { return 4 }
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%T does not implement encoding.BinaryMarshaler", v)
+	}
+	return m.MarshalBinary()
+}
+
+func (binaryCodec) Unmarshal(data []byte, v any) error {
+	u, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%T does not implement encoding.BinaryUnmarshaler", v)
+	}
+	return u.UnmarshalBinary(data)
+}
+
+type noCompression struct{}
+
+func (noCompression) ID() byte                              { return 0 }
+func (noCompression) Name() string                          { return "none" }
+func (noCompression) Compress(src []byte) ([]byte, error)   { return src, nil }
+func (noCompression) Decompress(src []byte) ([]byte, error) { return src, nil }
+
+// zstdのエンコーダ・デコーダは生成コストが高いので使い回す（EncodeAll/DecodeAllは並行呼び出し可）
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+type zstdCompression struct{}
+
+func (zstdCompression) ID() byte     { return 1 }
+func (zstdCompression) Name() string { return "zstd" }
+
+func (zstdCompression) Compress(src []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(src, nil), nil
+}
+
+func (zstdCompression) Decompress(src []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(src, nil)
+}
+
+type snappyCompression struct{}
+
+func (snappyCompression) ID() byte     { return 2 }
+func (snappyCompression) Name() string { return "snappy" }
+
+func (snappyCompression) Compress(src []byte) ([]byte, error) {
+	return s2.EncodeSnappy(nil, src), nil
+}
+
+func (snappyCompression) Decompress(src []byte) ([]byte, error) {
+	return s2.Decode(nil, src)
+}
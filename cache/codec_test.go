@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type sample struct {
+	Name  string
+	Count int
+}
+
+// binarySample Binary codecのテスト用（MarshalBinaryを実装した型）
+type binarySample sample
+
+func (s binarySample) MarshalBinary() ([]byte, error) {
+	return json.Marshal(sample(s))
+}
+
+func (s *binarySample) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, (*sample)(s))
+}
+
+func TestEncodeDecodeEntry_AllCombinations(t *testing.T) {
+	value := sample{Name: "logs", Count: 100}
+
+	for _, codec := range []Codec{JSON, Gob, MessagePack} {
+		for _, compression := range []Compression{NoCompression, Snappy, Zstd} {
+			t.Run(codec.Name()+"/"+compression.Name(), func(t *testing.T) {
+				e := entry[sample]{Value: value, ExpiresAt: 1700000000000, Delta: 12}
+				data, err := encodeEntry(e, codec, compression)
+				if err != nil {
+					t.Fatalf("encodeEntry() error = %v", err)
+				}
+				got, err := decodeEntry[sample](data)
+				if err != nil {
+					t.Fatalf("decodeEntry() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, e) {
+					t.Errorf("decodeEntry() = %+v; expected %+v", got, e)
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeDecodeEntry_BinaryCodec(t *testing.T) {
+	e := entry[binarySample]{Value: binarySample{Name: "x", Count: 1}}
+	data, err := encodeEntry(e, Binary, Zstd)
+	if err != nil {
+		t.Fatalf("encodeEntry() error = %v", err)
+	}
+	got, err := decodeEntry[binarySample](data)
+	if err != nil || got.Value != e.Value {
+		t.Errorf("decodeEntry() = %+v, %v; expected %+v", got, err, e)
+	}
+
+	if _, err := encodeEntry(entry[sample]{}, Binary, NoCompression); err == nil {
+		t.Error("expected error for type without MarshalBinary")
+	}
+}
+
+func TestDecodeEntry_MixedFormats(t *testing.T) {
+	// ヘッダーのない旧形式（JSONのエンベロープ）
+	legacy := []byte(`{"v":{"Name":"old","Count":1},"exp":1700000000000}`)
+	got, err := decodeEntry[sample](legacy)
+	if err != nil || got.Value.Name != "old" || got.ExpiresAt != 1700000000000 {
+		t.Errorf("decodeEntry(legacy) = %+v, %v", got, err)
+	}
+
+	// ネガティブエントリはペイロードなし
+	data, err := encodeEntry(entry[sample]{Negative: true}, MessagePack, Zstd)
+	if err != nil {
+		t.Fatalf("encodeEntry() error = %v", err)
+	}
+	if len(data) != headerSize {
+		t.Errorf("negative entry size = %d; expected %d", len(data), headerSize)
+	}
+	if got, err := decodeEntry[sample](data); err != nil || !got.Negative {
+		t.Errorf("decodeEntry(negative) = %+v, %v", got, err)
+	}
+
+	// 未知のcodec
+	unknown := bytes.Clone(data)
+	unknown[2] = 99
+	if _, err := decodeEntry[sample](unknown); err == nil {
+		t.Error("expected error for unknown codec")
+	}
+
+	if _, err := decodeEntry[sample]([]byte("garbage")); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestCompareCodecs(t *testing.T) {
+	reports := CompareCodecs(sample{Name: "logs", Count: 1}, []Codec{JSON, Binary}, []Compression{NoCompression}, 10)
+	if len(reports) != 2 {
+		t.Fatalf("len(reports) = %d; expected 2", len(reports))
+	}
+	if reports[0].RatioToJSON != 1 || reports[0].Error != "" {
+		t.Errorf("json report = %+v; expected ratio 1", reports[0])
+	}
+	if reports[1].Error == "" {
+		t.Error("expected binary codec to report an error for type without MarshalBinary")
+	}
+}
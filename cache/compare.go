@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"time"
+)
+
+// CodecReport Codec・圧縮方式の組み合わせごとのサイズと速度
+type CodecReport struct {
+	Codec         string  `json:"codec"`
+	Compression   string  `json:"compression"`
+	SizeBytes     int     `json:"size_bytes"`
+	RatioToJSON   float64 `json:"ratio_to_json"` // 圧縮なしJSONに対するサイズ比
+	EncodeNsPerOp int64   `json:"encode_ns_per_op"`
+	DecodeNsPerOp int64   `json:"decode_ns_per_op"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// CompareCodecs 値をiterations回エンコード・デコードして、組み合わせごとのサイズと速度を比較する
+//
+// ヘッダーを含めたL2に保存されるバイト列そのものを計測する。
+func CompareCodecs[T any](value T, codecList []Codec, compressionList []Compression, iterations int) []CodecReport {
+	if iterations <= 0 {
+		iterations = 1
+	}
+	e := entry[T]{Value: value}
+
+	baseline := 0
+	if data, err := encodeEntry(e, JSON, NoCompression); err == nil {
+		baseline = len(data)
+	}
+
+	reports := make([]CodecReport, 0, len(codecList)*len(compressionList))
+	for _, codec := range codecList {
+		for _, compression := range compressionList {
+			report := CodecReport{Codec: codec.Name(), Compression: compression.Name()}
+
+			data, err := encodeEntry(e, codec, compression)
+			if err != nil {
+				report.Error = err.Error()
+				reports = append(reports, report)
+				continue
+			}
+			report.SizeBytes = len(data)
+			if baseline > 0 {
+				report.RatioToJSON = float64(len(data)) / float64(baseline)
+			}
+
+			start := time.Now()
+			for i := 0; i < iterations; i++ {
+				if _, err := encodeEntry(e, codec, compression); err != nil {
+					report.Error = err.Error()
+					break
+				}
+			}
+			report.EncodeNsPerOp = time.Since(start).Nanoseconds() / int64(iterations)
+
+			start = time.Now()
+			for i := 0; i < iterations; i++ {
+				if _, err := decodeEntry[T](data); err != nil {
+					report.Error = err.Error()
+					break
+				}
+			}
+			report.DecodeNsPerOp = time.Since(start).Nanoseconds() / int64(iterations)
+
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
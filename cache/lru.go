@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU 容量制限とエントリごとのTTLを持つ、並行アクセス安全なLRUキャッシュ
+type LRU[V any] struct {
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	mu       sync.Mutex
+	now      func() time.Time
+}
+
+type lruItem[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time // ゼロ値の場合は期限なし
+}
+
+// NewLRU LRUキャッシュを作成
+func NewLRU[V any](capacity int) *LRU[V] {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &LRU[V]{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Get キーに対応する値を取得（期限切れの場合は削除して未ヒット扱い）
+func (c *LRU[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	item := elem.Value.(*lruItem[V])
+	if !item.expiresAt.IsZero() && !c.now().Before(item.expiresAt) {
+		c.removeElement(elem)
+		return zero, false
+	}
+
+	c.order.MoveToFront(elem)
+	return item.value, true
+}
+
+// Set 値を保存（ttlが0以下の場合は期限なし）
+func (c *LRU[V]) Set(key string, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = c.now().Add(ttl)
+	}
+
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*lruItem[V])
+		item.value = value
+		item.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem[V]{key: key, value: value, expiresAt: expiresAt})
+
+	// 容量超過なら最も古いものを削除
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Delete キーを削除
+func (c *LRU[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// Purge 全エントリを削除
+func (c *LRU[V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+// Len 現在のエントリ数を返す（期限切れで未削除のものも含む）
+func (c *LRU[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU[V]) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*lruItem[V]).key)
+}
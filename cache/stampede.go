@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// lockPollInterval ロック待ち中にL2を確認する間隔
+const lockPollInterval = 20 * time.Millisecond
+
+// unlockScript 自分が取得したロックだけを解放する
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// expired 論理的な有効期限を過ぎているか
+func (e entry[T]) expired(now time.Time) bool {
+	return e.ExpiresAt != 0 && !now.Before(time.UnixMilli(e.ExpiresAt))
+}
+
+// shouldRefreshEarly XFetch（Optimal Probabilistic Cache Stampede Prevention）の判定
+//
+// now - delta * beta * ln(rand()) >= expiry の時に早期更新する。
+// 読み込みに時間がかかる値ほど、また期限が近いほど更新されやすい。
+func (c *Cache[T]) shouldRefreshEarly(e entry[T], now time.Time) bool {
+	if c.config.EarlyRefreshBeta <= 0 || e.ExpiresAt == 0 || e.Delta <= 0 {
+		return false
+	}
+	gap := -float64(e.Delta) * c.config.EarlyRefreshBeta * math.Log(1-rand.Float64())
+	return now.UnixMilli()+int64(gap) >= e.ExpiresAt
+}
+
+// refreshInBackground 裏で値を更新する（同一キーの更新は同時に1つだけ）
+func (c *Cache[T]) refreshInBackground(ctx context.Context, key string, loader Loader[T], o entryOptions) {
+	if _, loading := c.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return
+	}
+
+	// リクエストのキャンセルに巻き込まれないようにする
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.config.LockTTL)
+	go func() {
+		defer cancel()
+		defer c.refreshing.Delete(key)
+
+		if _, err := c.load(ctx, key, loader, o); err != nil {
+			log.Printf("cache: background refresh of %s failed: %v", key, err)
+		}
+	}()
+}
+
+// loadWithLock Redisロックを取得できたインスタンスだけがローダーを呼び出す
+//
+// ロックを取得できなかった場合は、他のインスタンスがL2に書き込むのを待つ。
+// LockTTL以内に書き込まれなければ自分で読み込む。
+func (c *Cache[T]) loadWithLock(ctx context.Context, key string, loader Loader[T], o entryOptions) (entry[T], error) {
+	if c.redis == nil {
+		return c.loadAndStore(ctx, key, loader, o)
+	}
+
+	lockKey := c.redisKey(key) + ":lock"
+	token := uuid.NewString()
+	acquired, err := c.redis.SetNX(ctx, lockKey, token, c.config.LockTTL).Result()
+	if err != nil {
+		// Redis障害時はロックなしで読み込む
+		c.l2Errors.Add(1)
+		log.Printf("cache: failed to acquire lock for %s: %v", key, err)
+		return c.loadAndStore(ctx, key, loader, o)
+	}
+
+	if acquired {
+		defer func() {
+			if err := unlockScript.Run(context.WithoutCancel(ctx), c.redis, []string{lockKey}, token).Err(); err != nil && !errors.Is(err, redis.Nil) {
+				log.Printf("cache: failed to release lock for %s: %v", key, err)
+			}
+		}()
+		return c.loadAndStore(ctx, key, loader, o)
+	}
+
+	c.lockWaits.Add(1)
+	if e, ok := c.waitForL2(ctx, key); ok {
+		c.setL1(key, e)
+		return e, nil
+	}
+	return c.loadAndStore(ctx, key, loader, o)
+}
+
+// waitForL2 他のインスタンスが新しい値をL2に書き込むまで待つ
+func (c *Cache[T]) waitForL2(ctx context.Context, key string) (entry[T], bool) {
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	deadline := time.After(c.config.LockTTL)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return entry[T]{}, false
+		case <-deadline:
+			return entry[T]{}, false
+		case <-ticker.C:
+			if e, ok := c.getL2(ctx, key); ok && !e.expired(time.Now()) {
+				return e, true
+			}
+		}
+	}
+}
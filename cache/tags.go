@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WithTags エントリにタグを付ける（InvalidateTagでタグ単位にまとめて削除できる）
+func WithTags(tags ...string) Option {
+	return func(o *entryOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+// tagKey タグに属するキーを保持するRedisのSETのキー
+func (c *Cache[T]) tagKey(tag string) string {
+	return c.redisKey("tag:" + tag)
+}
+
+// tag キーをタグの索引に追加
+func (c *Cache[T]) tag(ctx context.Context, key string, o entryOptions) {
+	tags := o.tags
+	if len(tags) == 0 {
+		return
+	}
+
+	if c.redis == nil {
+		c.tagsMu.Lock()
+		defer c.tagsMu.Unlock()
+		for _, tag := range tags {
+			if c.localTags[tag] == nil {
+				c.localTags[tag] = make(map[string]struct{})
+			}
+			c.localTags[tag][key] = struct{}{}
+		}
+		return
+	}
+
+	// 索引は属するエントリより長く残るよう、期限を延ばす方向にだけ更新する
+	indexTTL := max(c.config.L2TTL, o.ttl) + c.config.StaleTTL
+	if _, err := c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, tag := range tags {
+			pipe.SAdd(ctx, c.tagKey(tag), key)
+			pipe.ExpireNX(ctx, c.tagKey(tag), indexTTL)
+			pipe.ExpireGT(ctx, c.tagKey(tag), indexTTL)
+		}
+		return nil
+	}); err != nil {
+		c.l2Errors.Add(1)
+		log.Printf("cache: failed to index %s: %v", key, err)
+	}
+}
+
+// InvalidateTag タグが付いた全エントリを削除し、削除したキーの数を返す
+func (c *Cache[T]) InvalidateTag(ctx context.Context, tag string) (int, error) {
+	var keys []string
+	if c.redis == nil {
+		c.tagsMu.Lock()
+		for key := range c.localTags[tag] {
+			keys = append(keys, key)
+		}
+		delete(c.localTags, tag)
+		c.tagsMu.Unlock()
+	} else {
+		// 読み取りと索引の削除をMULTIでまとめ、その間に追加されたキーを取りこぼさない
+		var members *redis.StringSliceCmd
+		if _, err := c.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			members = pipe.SMembers(ctx, c.tagKey(tag))
+			pipe.Del(ctx, c.tagKey(tag))
+			return nil
+		}); err != nil {
+			return 0, fmt.Errorf("failed to read tag %s: %w", tag, err)
+		}
+		keys = members.Val()
+	}
+
+	for _, key := range keys {
+		if err := c.Delete(ctx, key); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/benchmark"
+	"github.com/keito-isurugi/go-demo/handler"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// キャッシュあり・なしのログ取得APIをプロセス内で負荷比較するCLI
+//
+// 実行コマンド: go run ./cmd/cachebench -concurrency=20 -duration=10s -html=report.html
+func main() {
+	dsn := flag.String("dsn", "host=db user=postgres password=postgres dbname=go_demo port=5432 sslmode=disable TimeZone=Asia/Tokyo", "PostgreSQLの接続文字列")
+	redisAddr := flag.String("redis", "redis:6379", "Redisのアドレス")
+	concurrency := flag.Int("concurrency", 10, "並列数")
+	duration := flag.Duration("duration", 5*time.Second, "計測時間")
+	htmlPath := flag.String("html", "", "HTMLレポートの出力先（省略時はJSONのみ）")
+	flag.Parse()
+
+	dbConn, err := gorm.Open(postgres.Open(*dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: *redisAddr})
+
+	if err := benchmark.InstrumentGorm(dbConn); err != nil {
+		log.Fatalf("Failed to instrument gorm: %v", err)
+	}
+	benchmark.InstrumentRedis(rdb)
+
+	// ベンチマークではテストデータ投入ジョブを使わない
+	cacheHandler := handler.NewCacheHandler(dbConn, rdb, nil)
+	reports := cacheHandler.RunCacheBenchmark(context.Background(), benchmark.Config{
+		Concurrency: *concurrency,
+		Duration:    *duration,
+	})
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(reports); err != nil {
+		log.Fatal(err)
+	}
+
+	if *htmlPath != "" {
+		f, err := os.Create(*htmlPath)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *htmlPath, err)
+		}
+		defer f.Close()
+		if err := benchmark.WriteHTML(f, "Cache benchmark", reports); err != nil {
+			log.Fatalf("Failed to write HTML report: %v", err)
+		}
+	}
+}
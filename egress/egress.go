@@ -0,0 +1,249 @@
+// Package egress はユーザーが指定したURLへリクエストするときのSSRF対策
+//
+// Guard のクライアントは次のすべてを満たすリクエストだけを送る。
+//
+//   - スキームが許可されている（既定は http と https）
+//   - ホストが拒否リストになく、許可リストがあればそれに含まれる
+//   - 接続するIPアドレスがループバック・プライベート・リンクローカルなどの内部向けの範囲でない
+//
+// アドレスの確認は名前解決の結果ではなく、実際に接続する直前（net.Dialer.Control）に行うため、
+// 確認の後にDNSの応答を内部のアドレスへ変えられても（DNSリバインディング）接続しない。
+// リダイレクト先も1ホップごとに同じ確認を行い、レスポンスボディは MaxResponseBytes までしか読まない。
+package egress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrDisallowedScheme 許可されていないスキーム
+	ErrDisallowedScheme = errors.New("egress: scheme is not allowed")
+	// ErrDisallowedHost 拒否リストにある、または許可リストにないホスト
+	ErrDisallowedHost = errors.New("egress: host is not allowed")
+	// ErrDisallowedAddress 内部向けの範囲のIPアドレス
+	ErrDisallowedAddress = errors.New("egress: address is not allowed")
+	// ErrTooManyRedirects リダイレクトの回数が上限を超えた
+	ErrTooManyRedirects = errors.New("egress: too many redirects")
+	// ErrResponseTooLarge レスポンスボディが上限を超えた
+	ErrResponseTooLarge = errors.New("egress: response body is too large")
+)
+
+// blockedNetworks 既定で接続を拒否するアドレス範囲
+var blockedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // このネットワーク
+	netip.MustParsePrefix("10.0.0.0/8"),      // プライベート
+	netip.MustParsePrefix("100.64.0.0/10"),   // キャリアグレードNAT
+	netip.MustParsePrefix("127.0.0.0/8"),     // ループバック
+	netip.MustParsePrefix("169.254.0.0/16"),  // リンクローカル（クラウドのメタデータサーバを含む）
+	netip.MustParsePrefix("172.16.0.0/12"),   // プライベート（Docker のネットワークを含む）
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETFプロトコル割り当て
+	netip.MustParsePrefix("192.0.2.0/24"),    // ドキュメント用
+	netip.MustParsePrefix("192.168.0.0/16"),  // プライベート
+	netip.MustParsePrefix("198.18.0.0/15"),   // ベンチマーク用
+	netip.MustParsePrefix("198.51.100.0/24"), // ドキュメント用
+	netip.MustParsePrefix("203.0.113.0/24"),  // ドキュメント用
+	netip.MustParsePrefix("224.0.0.0/4"),     // マルチキャスト
+	netip.MustParsePrefix("240.0.0.0/4"),     // 予約済み・ブロードキャスト
+	netip.MustParsePrefix("::/128"),          // 未指定
+	netip.MustParsePrefix("::1/128"),         // ループバック
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64（内部のIPv4アドレスを埋め込める）
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // ローカルNAT64
+	netip.MustParsePrefix("2001:db8::/32"),   // ドキュメント用
+	netip.MustParsePrefix("2002::/16"),       // 6to4（内部のIPv4アドレスを埋め込める）
+	netip.MustParsePrefix("fc00::/7"),        // ユニークローカル
+	netip.MustParsePrefix("fe80::/10"),       // リンクローカル
+	netip.MustParsePrefix("fec0::/10"),       // サイトローカル（廃止）
+	netip.MustParsePrefix("ff00::/8"),        // マルチキャスト
+}
+
+// Config Guardの設定
+type Config struct {
+	AllowedSchemes   []string       // 許可するスキーム
+	AllowedHosts     []string       // 空でなければ、このホストだけを許可する（"*.example.com" はサブドメインすべて）
+	DeniedHosts      []string       // 拒否するホスト（AllowedHostsより優先。書式はAllowedHostsと同じ）
+	AllowedNetworks  []netip.Prefix // 既定で拒否する範囲のうち、例外として接続を許可する範囲
+	MaxRedirects     int            // 追従するリダイレクトの最大回数
+	MaxResponseBytes int64          // レスポンスボディの上限（バイト）
+}
+
+// DefaultConfig 既定の設定
+func DefaultConfig() Config {
+	return Config{
+		AllowedSchemes:   []string{"http", "https"},
+		DeniedHosts:      []string{"localhost", "*.localhost", "metadata.google.internal"},
+		MaxRedirects:     5,
+		MaxResponseBytes: 1 << 20,
+	}
+}
+
+// Guard 外部へのリクエストを制限する（複数のgoroutineから使ってよい）
+type Guard struct {
+	config    Config
+	transport *http.Transport
+}
+
+// New 設定からGuardを作成（クライアントは同じコネクションプールを共有する）
+func New(config Config) *Guard {
+	g := &Guard{config: config}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   g.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// プロキシ経由だとプロキシのアドレスしか確認できないため、環境変数のプロキシは使わない
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	g.transport = transport
+	return g
+}
+
+// Client 設定に従ってリクエストするHTTPクライアント
+func (g *Guard) Client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &guardedTransport{guard: g},
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// リダイレクト先のURLはRoundTripで、アドレスは接続時に確認する
+			if len(via) > g.config.MaxRedirects {
+				return fmt.Errorf("%w: %d", ErrTooManyRedirects, g.config.MaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// CheckURL スキームとホストを確認する（ホストがIPアドレスならアドレスも確認する）
+func (g *Guard) CheckURL(u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	if !contains(g.config.AllowedSchemes, scheme) {
+		return fmt.Errorf("%w: %q", ErrDisallowedScheme, u.Scheme)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return fmt.Errorf("%w: empty host", ErrDisallowedHost)
+	}
+	if matchHost(g.config.DeniedHosts, host) {
+		return fmt.Errorf("%w: %s is denied", ErrDisallowedHost, host)
+	}
+	if len(g.config.AllowedHosts) > 0 && !matchHost(g.config.AllowedHosts, host) {
+		return fmt.Errorf("%w: %s is not in the allow list", ErrDisallowedHost, host)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return g.CheckAddr(addr)
+	}
+	return nil
+}
+
+// CheckAddr 接続してよいIPアドレスか確認する
+func (g *Guard) CheckAddr(addr netip.Addr) error {
+	// ::ffff:127.0.0.1 のようなIPv4射影アドレスはIPv4として確認する
+	addr = addr.Unmap().WithZone("")
+	for _, allowed := range g.config.AllowedNetworks {
+		if allowed.Contains(addr) {
+			return nil
+		}
+	}
+	for _, blocked := range blockedNetworks {
+		if blocked.Contains(addr) {
+			return fmt.Errorf("%w: %s is in %s", ErrDisallowedAddress, addr, blocked)
+		}
+	}
+	return nil
+}
+
+// control 接続する直前に、名前解決後の実際のアドレスを確認する
+func (g *Guard) control(network, address string, _ syscall.RawConn) error {
+	if network != "tcp4" && network != "tcp6" {
+		return fmt.Errorf("%w: network %s", ErrDisallowedAddress, network)
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrDisallowedAddress, address)
+	}
+	return g.CheckAddr(addrPort.Addr())
+}
+
+// guardedTransport リクエストごと（リダイレクトの各ホップを含む）にURLを確認し、レスポンスボディの大きさを制限する
+type guardedTransport struct {
+	guard *Guard
+}
+
+func (t *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.guard.CheckURL(req.URL); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.guard.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := t.guard.config.MaxResponseBytes
+	if limit <= 0 {
+		return resp, nil
+	}
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: content length %d exceeds %d bytes", ErrResponseTooLarge, resp.ContentLength, limit)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit}
+	return resp, nil
+}
+
+// limitedBody remainingバイトを超えて読もうとするとErrResponseTooLargeを返す
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	// 上限ちょうどで終わるボディとの区別のため、1バイト多く読む
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, ErrResponseTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// matchHost hostがpatternsのいずれかに一致するか（"*.example.com" は example.com のサブドメインに一致）
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package egress
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGuard_CheckAddr(t *testing.T) {
+	g := New(DefaultConfig())
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.18.0.2", true}, // compose のネットワーク（db, redis など）
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"fe80::1%eth0", true},
+		{"fd00::1", true},
+		{"64:ff9b::a9fe:a9fe", true},
+	}
+	for _, tt := range tests {
+		err := g.CheckAddr(netip.MustParseAddr(tt.addr))
+		if tt.blocked != errors.Is(err, ErrDisallowedAddress) {
+			t.Errorf("CheckAddr(%s) = %v; expected blocked = %v", tt.addr, err, tt.blocked)
+		}
+	}
+
+	allowed := New(Config{AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}})
+	if err := allowed.CheckAddr(netip.MustParseAddr("10.0.0.5")); err != nil {
+		t.Errorf("AllowedNetworks should override the default block list: %v", err)
+	}
+	if err := allowed.CheckAddr(netip.MustParseAddr("10.0.1.5")); !errors.Is(err, ErrDisallowedAddress) {
+		t.Errorf("10.0.1.5 outside AllowedNetworks should be blocked: %v", err)
+	}
+}
+
+func TestGuard_CheckURL(t *testing.T) {
+	config := DefaultConfig()
+	config.AllowedHosts = []string{"example.com", "*.example.org"}
+	config.DeniedHosts = append(config.DeniedHosts, "secret.example.org")
+	g := New(config)
+
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"https://example.com/path", nil},
+		{"http://EXAMPLE.com./", nil},
+		{"https://api.example.org/", nil},
+		{"https://example.org/", ErrDisallowedHost}, // "*." はサブドメインだけ
+		{"https://secret.example.org/", ErrDisallowedHost},
+		{"https://example.net/", ErrDisallowedHost},
+		{"http://localhost/", ErrDisallowedHost},
+		{"file:///etc/passwd", ErrDisallowedScheme},
+		{"gopher://example.com/", ErrDisallowedScheme},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := g.CheckURL(u); !errors.Is(err, tt.want) {
+			t.Errorf("CheckURL(%s) = %v; expected %v", tt.url, err, tt.want)
+		}
+	}
+
+	open := New(DefaultConfig())
+	for _, raw := range []string{"http://169.254.169.254/latest/meta-data/", "http://[::1]:8080/", "http://10.0.0.1/"} {
+		u, _ := url.Parse(raw)
+		if err := open.CheckURL(u); !errors.Is(err, ErrDisallowedAddress) {
+			t.Errorf("CheckURL(%s) = %v; expected ErrDisallowedAddress", raw, err)
+		}
+	}
+}
+
+// 名前解決の結果が内部のアドレスなら、URLの確認を通っても接続しないこと
+func TestClient_ChecksAddressAtDial(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DeniedHosts = nil
+	client := New(config).Client(5 * time.Second)
+
+	target := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	_, err := client.Get(target)
+	if !errors.Is(err, ErrDisallowedAddress) {
+		t.Fatalf("err = %v; expected ErrDisallowedAddress", err)
+	}
+	if hits.Load() != 0 {
+		t.Errorf("server received %d requests", hits.Load())
+	}
+}
+
+// リダイレクト先もホップごとに確認すること
+func TestClient_RevalidatesRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/to-denied-host":
+			http.Redirect(w, r, "http://internal.example/", http.StatusFound)
+		case "/to-metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	// テストサーバーにだけは接続できるようにする
+	config.AllowedNetworks = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	config.DeniedHosts = []string{"internal.example"}
+	client := New(config).Client(5 * time.Second)
+
+	tests := []struct {
+		path string
+		want error
+	}{
+		{"/ok", nil},
+		{"/to-denied-host", ErrDisallowedHost},
+		{"/to-metadata", ErrDisallowedAddress},
+		{"/loop", ErrTooManyRedirects},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(server.URL + tt.path)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("GET %s: err = %v; expected %v", tt.path, err, tt.want)
+		}
+	}
+}
+
+func TestClient_CapsResponseSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/exact":
+			io.WriteString(w, strings.Repeat("a", 16))
+		case "/content-length":
+			io.WriteString(w, strings.Repeat("a", 32))
+		case "/chunked":
+			// Content-Lengthを付けずに送る
+			io.WriteString(w, strings.Repeat("a", 8))
+			w.(http.Flusher).Flush()
+			io.WriteString(w, strings.Repeat("a", 24))
+		}
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.AllowedNetworks = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	config.MaxResponseBytes = 16
+	client := New(config).Client(5 * time.Second)
+
+	resp, err := client.Get(server.URL + "/exact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(body) != 16 {
+		t.Errorf("exact: read %d bytes, err = %v", len(body), err)
+	}
+
+	if _, err := client.Get(server.URL + "/content-length"); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("content-length: err = %v; expected ErrResponseTooLarge", err)
+	}
+
+	resp, err = client.Get(server.URL + "/chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, ErrResponseTooLarge) || len(body) != 16 {
+		t.Errorf("chunked: read %d bytes, err = %v; expected 16 bytes and ErrResponseTooLarge", len(body), err)
+	}
+}
@@ -8,10 +8,14 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/keito-isurugi/go-demo/egress"
 )
 
 // AggregateAPIHandler は複数APIを並列で叩いて結果をまとめる
-type AggregateAPIHandler struct{}
+type AggregateAPIHandler struct {
+	Guard *egress.Guard // 外部へのリクエストの制限（nilなら既定の設定）
+}
 
 // APIRequest は個別APIリクエストの定義
 type APIRequest struct {
@@ -200,9 +204,8 @@ func (h *AggregateAPIHandler) executeAPI(ctx context.Context, apiReq APIRequest)
 		req.Header.Set("Accept", "application/json")
 	}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
+	// 内部のアドレスやリダイレクト先への接続はGuardが拒否する
+	client := egressClient(h.Guard, 30*time.Second)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -0,0 +1,254 @@
+package bank
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/scenario"
+)
+
+// 分離レベルごとの異常（anomaly）の再現デモ
+//
+// 2つのトランザクションT1・T2をscenario.Runnerで別々のコネクションに開き、宣言した順に1ステップずつ進める。
+// 残高を直接書き換えるので、元帳と整合させる必要のないデモ専用テーブル（isolation_demo_accounts）を使う。
+
+// IsolationDemoAccount 異常の再現に使う口座（元帳を通さない）
+type IsolationDemoAccount struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"size:50;not null;index"`
+	Balance   int64  `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+const selectDemoBalance = "SELECT balance FROM isolation_demo_accounts WHERE id = $1"
+
+// demoAccounts デモ用の口座を作り、口座名でIDをVarsに入れるSetupと、prefixの口座を消すTeardown
+func demoAccounts(prefix string, balances map[string]int64) (
+	setup func(ctx context.Context, db *sql.DB, vars *scenario.Vars) error,
+	teardown func(ctx context.Context, db *sql.DB, vars *scenario.Vars) error,
+) {
+	setup = func(ctx context.Context, db *sql.DB, vars *scenario.Vars) error {
+		names := make([]string, 0, len(balances))
+		for name := range balances {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var id int64
+			if err := db.QueryRowContext(ctx,
+				"INSERT INTO isolation_demo_accounts (name, balance, created_at) VALUES ($1, $2, NOW()) RETURNING id",
+				prefix+":"+name, balances[name]).Scan(&id); err != nil {
+				return err
+			}
+			vars.Set(name, id)
+		}
+		return nil
+	}
+	teardown = func(ctx context.Context, db *sql.DB, vars *scenario.Vars) error {
+		_, err := db.ExecContext(ctx, "DELETE FROM isolation_demo_accounts WHERE name LIKE $1", prefix+":%")
+		return err
+	}
+	return setup, teardown
+}
+
+// demoPrefix 同時に実行しても重ならない口座名のprefix
+func demoPrefix(name string) string {
+	return fmt.Sprintf("%s-%d", name, time.Now().UnixNano()%1e12)
+}
+
+func demoBalances(ctx context.Context, db *sql.DB, vars *scenario.Vars, names ...string) (map[string]int64, error) {
+	balances := make(map[string]int64, len(names))
+	for _, name := range names {
+		var balance int64
+		if err := db.QueryRowContext(ctx, selectDemoBalance, vars.Get(name)).Scan(&balance); err != nil {
+			return nil, err
+		}
+		balances[name] = balance
+	}
+	return balances, nil
+}
+
+// setDemoBalance 読んだ値（key）にdeltaを足した残高で上書きする（read-modify-write）
+func setDemoBalance(account, key string, delta int64) scenario.Action {
+	return func(ctx context.Context, tx *sql.Tx, vars *scenario.Vars) (string, error) {
+		v := vars.Get(key) + delta
+		if _, err := tx.ExecContext(ctx, "UPDATE isolation_demo_accounts SET balance = $1 WHERE id = $2", v, vars.Get(account)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s := %d", account, v), nil
+	}
+}
+
+// withdrawIfAbove 読んだ合計（sumKey）から出金しても下限を下回らない場合だけ出金する
+func withdrawIfAbove(account, sumKey string, amount, minimum int64) scenario.Action {
+	return func(ctx context.Context, tx *sql.Tx, vars *scenario.Vars) (string, error) {
+		if sum := vars.Get(sumKey); sum-amount < minimum {
+			return fmt.Sprintf("rejected: %d - %d < %d", sum, amount, minimum), nil
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE isolation_demo_accounts SET balance = balance - $1 WHERE id = $2", amount, vars.Get(account)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s -= %d", account, amount), nil
+	}
+}
+
+func lostUpdateScenario(isolation sql.IsolationLevel) scenario.Scenario {
+	setup, teardown := demoAccounts(demoPrefix("lost-update"), map[string]int64{"X": 10000})
+	return scenario.Scenario{
+		Name:        "lost-update",
+		Description: "T1とT2が同じ残高を読み、それぞれ読んだ値に加算して上書きする。後から書いた方が先の更新を消す",
+		Isolation:   isolation,
+		Setup:       setup,
+		Teardown:    teardown,
+		Steps: []scenario.Step{
+			scenario.Query("T1", "read X", "T1.X", selectDemoBalance, scenario.Var("X")),
+			scenario.Query("T2", "read X", "T2.X", selectDemoBalance, scenario.Var("X")),
+			scenario.Do("T1", "X := read + 1000", setDemoBalance("X", "T1.X", 1000)),
+			scenario.Commit("T1"),
+			scenario.Do("T2", "X := read + 2000", setDemoBalance("X", "T2.X", 2000)),
+			scenario.Commit("T2"),
+		},
+		Check: func(ctx context.Context, db *sql.DB, vars *scenario.Vars, result *scenario.Result) (bool, string, error) {
+			final, err := demoBalances(ctx, db, vars, "X")
+			if err != nil {
+				return false, "", err
+			}
+			if result.Committed["T1"] && result.Committed["T2"] && final["X"] != 13000 {
+				return true, fmt.Sprintf("both committed but X = %d (expected 13000): T1's +1000 was lost", final["X"]), nil
+			}
+			return false, fmt.Sprintf("X = %d; the conflicting update was rejected", final["X"]), nil
+		},
+	}
+}
+
+func nonRepeatableReadScenario(isolation sql.IsolationLevel) scenario.Scenario {
+	setup, teardown := demoAccounts(demoPrefix("non-repeatable-read"), map[string]int64{"X": 10000})
+	return scenario.Scenario{
+		Name:        "non-repeatable-read",
+		Description: "T1が同じ行を2回読む間に、T2が更新してコミットする",
+		Isolation:   isolation,
+		Setup:       setup,
+		Teardown:    teardown,
+		Steps: []scenario.Step{
+			scenario.Query("T1", "read X", "T1.X1", selectDemoBalance, scenario.Var("X")),
+			scenario.Exec("T2", "X += 5000", "UPDATE isolation_demo_accounts SET balance = balance + 5000 WHERE id = $1", scenario.Var("X")),
+			scenario.Commit("T2"),
+			scenario.Query("T1", "read X again", "T1.X2", selectDemoBalance, scenario.Var("X")),
+			scenario.Commit("T1"),
+		},
+		Check: func(ctx context.Context, db *sql.DB, vars *scenario.Vars, result *scenario.Result) (bool, string, error) {
+			first, second := vars.Get("T1.X1"), vars.Get("T1.X2")
+			if first != second {
+				return true, fmt.Sprintf("T1 read X = %d, then %d within the same transaction", first, second), nil
+			}
+			return false, fmt.Sprintf("T1 read X = %d both times", first), nil
+		},
+	}
+}
+
+func readSkewScenario(isolation sql.IsolationLevel) scenario.Scenario {
+	setup, teardown := demoAccounts(demoPrefix("read-skew"), map[string]int64{"X": 5000, "Y": 5000})
+	return scenario.Scenario{
+		Name:        "read-skew",
+		Description: "T1がXとYを別々に読む間に、T2がXからYへ1000振り替えてコミットする（X+Yは常に10000のはず）",
+		Isolation:   isolation,
+		Setup:       setup,
+		Teardown:    teardown,
+		Steps: []scenario.Step{
+			scenario.Query("T1", "read X", "T1.X", selectDemoBalance, scenario.Var("X")),
+			scenario.Exec("T2", "X -= 1000", "UPDATE isolation_demo_accounts SET balance = balance - 1000 WHERE id = $1", scenario.Var("X")),
+			scenario.Exec("T2", "Y += 1000", "UPDATE isolation_demo_accounts SET balance = balance + 1000 WHERE id = $1", scenario.Var("Y")),
+			scenario.Commit("T2"),
+			scenario.Query("T1", "read Y", "T1.Y", selectDemoBalance, scenario.Var("Y")),
+			scenario.Commit("T1"),
+		},
+		Check: func(ctx context.Context, db *sql.DB, vars *scenario.Vars, result *scenario.Result) (bool, string, error) {
+			x, y := vars.Get("T1.X"), vars.Get("T1.Y")
+			if x+y != 10000 {
+				return true, fmt.Sprintf("T1 saw X + Y = %d + %d = %d, a state that never existed", x, y, x+y), nil
+			}
+			return false, "T1 saw a consistent snapshot (X + Y = 10000)", nil
+		},
+	}
+}
+
+func writeSkewScenario(isolation sql.IsolationLevel) scenario.Scenario {
+	setup, teardown := demoAccounts(demoPrefix("write-skew"), map[string]int64{"X": 10000, "Y": 10000})
+	const sumSQL = "SELECT SUM(balance) FROM isolation_demo_accounts WHERE id IN ($1, $2)"
+	return scenario.Scenario{
+		Name:        "write-skew",
+		Description: "XとYの合計は10000以上でなければならない。T1はXから、T2はYから、それぞれ合計を確認して6000出金する",
+		Isolation:   isolation,
+		Setup:       setup,
+		Teardown:    teardown,
+		Steps: []scenario.Step{
+			scenario.Query("T1", "read X + Y", "T1.sum", sumSQL, scenario.Var("X"), scenario.Var("Y")),
+			scenario.Query("T2", "read X + Y", "T2.sum", sumSQL, scenario.Var("X"), scenario.Var("Y")),
+			scenario.Do("T1", "X -= 6000 if X + Y - 6000 >= 10000", withdrawIfAbove("X", "T1.sum", 6000, 10000)),
+			scenario.Do("T2", "Y -= 6000 if X + Y - 6000 >= 10000", withdrawIfAbove("Y", "T2.sum", 6000, 10000)),
+			scenario.Commit("T1"),
+			scenario.Commit("T2"),
+		},
+		Check: func(ctx context.Context, db *sql.DB, vars *scenario.Vars, result *scenario.Result) (bool, string, error) {
+			final, err := demoBalances(ctx, db, vars, "X", "Y")
+			if err != nil {
+				return false, "", err
+			}
+			if sum := final["X"] + final["Y"]; sum < 10000 {
+				return true, fmt.Sprintf("X + Y = %d violates the minimum of 10000", sum), nil
+			}
+			return false, fmt.Sprintf("X + Y = %d; one transaction was aborted", final["X"]+final["Y"]), nil
+		},
+	}
+}
+
+// anomalyScenarios 分離レベルごとに比較する異常
+var anomalyScenarios = []string{"lost-update", "non-repeatable-read", "read-skew", "write-skew"}
+
+// AnomalyDemoHandler 分離レベルごとの異常の再現デモ
+//
+// scenario=lost-update|non-repeatable-read|read-skew|write-skew,
+// isolation=read-uncommitted|read-committed|repeatable-read|serializable（省略時は全て）
+func (h *Handler) AnomalyDemoHandler(w http.ResponseWriter, r *http.Request) {
+	scenarioName := r.URL.Query().Get("scenario")
+	isolations := scenario.Isolations
+	if raw := r.URL.Query().Get("isolation"); raw != "" {
+		level, err := scenario.ParseIsolation(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		isolations = []sql.IsolationLevel{level}
+	}
+
+	runner, err := h.scenarioRunner()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to prepare: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	results := []*scenario.Result{}
+	for _, name := range anomalyScenarios {
+		if scenarioName != "" && name != scenarioName {
+			continue
+		}
+		for _, level := range isolations {
+			result, err := runner.Run(r.Context(), bankScenarios[name](level))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Scenario %s failed: %v", name, err), http.StatusInternalServerError)
+				return
+			}
+			results = append(results, result)
+		}
+	}
+	if len(results) == 0 {
+		http.Error(w, "Unknown scenario", http.StatusBadRequest)
+		return
+	}
+
+	jsonResponse(w, results)
+}
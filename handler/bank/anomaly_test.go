@@ -0,0 +1,69 @@
+package bank
+
+import (
+	"context"
+	"testing"
+
+	"github.com/keito-isurugi/go-demo/scenario"
+)
+
+func testScenarioRunner(t *testing.T) *scenario.Runner {
+	t.Helper()
+	db := openTestDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &scenario.Runner{DB: sqlDB}
+}
+
+func TestAnomalyMatrix(t *testing.T) {
+	runner := testScenarioRunner(t)
+
+	// PostgreSQLで期待される結果（true: 異常が起きる）
+	expected := map[string]map[string]bool{
+		"lost-update":         {"read-uncommitted": true, "read-committed": true, "repeatable-read": false, "serializable": false},
+		"non-repeatable-read": {"read-uncommitted": true, "read-committed": true, "repeatable-read": false, "serializable": false},
+		"read-skew":           {"read-uncommitted": true, "read-committed": true, "repeatable-read": false, "serializable": false},
+		"write-skew":          {"read-uncommitted": true, "read-committed": true, "repeatable-read": true, "serializable": false},
+		"dirty-read":          {"read-uncommitted": false, "read-committed": false, "repeatable-read": false, "serializable": false},
+		"phantom-read":        {"read-uncommitted": true, "read-committed": true, "repeatable-read": false, "serializable": false},
+	}
+
+	for name, levels := range expected {
+		for _, level := range scenario.Isolations {
+			isolation := scenario.IsolationName(level)
+			t.Run(name+"/"+isolation, func(t *testing.T) {
+				s := bankScenarios[name](level)
+				result, err := runner.Run(context.Background(), s)
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+				if want := levels[isolation]; result.Occurred != want {
+					t.Errorf("occurred = %v; expected %v (%s)", result.Occurred, want, result.Explanation)
+				}
+				if len(result.Trace) != len(s.Steps) {
+					t.Errorf("trace has %d events; expected %d", len(result.Trace), len(s.Steps))
+				}
+			})
+		}
+	}
+}
+
+func TestDeadlockScenario(t *testing.T) {
+	runner := testScenarioRunner(t)
+
+	result, err := runner.Run(context.Background(), deadlockScenario(0))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !result.Occurred {
+		t.Fatalf("deadlock was not detected: %+v", result.Trace)
+	}
+	if result.Trace[2].Phase != "blocked" {
+		t.Errorf("step 3 phase = %q; expected blocked", result.Trace[2].Phase)
+	}
+	if result.Committed["T1"] == result.Committed["T2"] {
+		t.Errorf("committed = %v; expected exactly one survivor", result.Committed)
+	}
+}
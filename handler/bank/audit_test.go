@@ -0,0 +1,248 @@
+package bank
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func auditChain(t *testing.T, n int) []*AuditLogEntry {
+	t.Helper()
+	var entries []*AuditLogEntry
+	var prev *AuditLogEntry
+	for i := 1; i <= n; i++ {
+		tx := &Transaction{ID: uint(i), FromAccountID: 1, ToAccountID: 2, Amount: int64(i * 100), Currency: "JPY",
+			ToAmount: int64(i * 100), ToCurrency: "JPY", Status: TransactionStatusCompleted, TransactionType: TransactionTypeTransfer,
+			CreatedAt: time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC)}
+		entry, err := newAuditLogEntry(prev, tx, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+		prev = entry
+	}
+	return entries
+}
+
+// firstBrokenSeq 最初に壊れているエントリの連番（壊れていなければ0）
+func firstBrokenSeq(entries []*AuditLogEntry) uint64 {
+	var prev *AuditLogEntry
+	for _, entry := range entries {
+		if verifyAuditEntry(prev, entry) != "" {
+			return entry.Seq
+		}
+		prev = entry
+	}
+	return 0
+}
+
+func TestVerifyAuditEntry(t *testing.T) {
+	if seq := firstBrokenSeq(auditChain(t, 5)); seq != 0 {
+		t.Fatalf("intact chain broken at %d", seq)
+	}
+
+	edited := auditChain(t, 5)
+	edited[2].Content = `{"id":3,"amount":1}`
+	if seq := firstBrokenSeq(edited); seq != 3 {
+		t.Errorf("edited content: broken at %d; expected 3", seq)
+	}
+
+	// 内容とハッシュを計算し直しても、次のエントリのprev_hashと一致しない
+	rehashed := auditChain(t, 5)
+	rehashed[2].Content = `{"id":3,"amount":1}`
+	rehashed[2].ContentHash = sha256Hex(rehashed[2].Content)
+	rehashed[2].Hash = chainHash(rehashed[2].PrevHash, rehashed[2].Seq, rehashed[2].ContentHash)
+	if seq := firstBrokenSeq(rehashed); seq != 4 {
+		t.Errorf("rehashed entry: broken at %d; expected 4", seq)
+	}
+
+	deleted := auditChain(t, 5)
+	deleted = append(deleted[:1], deleted[2:]...)
+	if seq := firstBrokenSeq(deleted); seq != 3 {
+		t.Errorf("deleted entry: broken at %d; expected 3", seq)
+	}
+}
+
+func TestVerifyAuditCheckpoint(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := key.Public().(ed25519.PublicKey)
+	trusted := map[string]ed25519.PublicKey{auditKeyID(publicKey): publicKey}
+	entry := auditChain(t, 3)[2]
+	checkpoint := &AuditCheckpoint{
+		Seq:       entry.Seq,
+		Hash:      entry.Hash,
+		KeyID:     auditKeyID(publicKey),
+		Signature: hex.EncodeToString(ed25519.Sign(key, checkpointMessage(entry.Seq, entry.Hash))),
+	}
+	if reason := verifyAuditCheckpoint(checkpoint, trusted, entry.Hash); reason != "" {
+		t.Fatalf("valid checkpoint: %s", reason)
+	}
+	// チェーン全体を計算し直すと、署名したハッシュと一致しなくなる
+	if reason := verifyAuditCheckpoint(checkpoint, trusted, sha256Hex("rewritten")); reason == "" {
+		t.Error("rewritten chain should not match the checkpoint")
+	}
+	forged := *checkpoint
+	forged.Hash = sha256Hex("rewritten")
+	if reason := verifyAuditCheckpoint(&forged, trusted, forged.Hash); reason != "invalid signature" {
+		t.Errorf("forged checkpoint: %q; expected invalid signature", reason)
+	}
+
+	// 信頼していない鍵で署名し直したチェックポイントは、署名が正しくても通らない
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resigned := forged
+	resigned.KeyID = auditKeyID(other.Public().(ed25519.PublicKey))
+	resigned.Signature = hex.EncodeToString(ed25519.Sign(other, checkpointMessage(forged.Seq, forged.Hash)))
+	if reason := verifyAuditCheckpoint(&resigned, trusted, resigned.Hash); reason != "signed by an untrusted key" {
+		t.Errorf("re-signed checkpoint: %q; expected an untrusted key", reason)
+	}
+}
+
+func TestParseAuditKey(t *testing.T) {
+	seed := strings.Repeat("ab", ed25519.SeedSize)
+	key, err := ParseAuditKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同じ設定からは再起動しても同じ鍵になる
+	again, _ := ParseAuditKey(seed + "\n")
+	if !key.Equal(again) {
+		t.Error("the same seed should give the same key")
+	}
+	for _, invalid := range []string{"", "zz", strings.Repeat("ab", ed25519.SeedSize-1)} {
+		if _, err := ParseAuditKey(invalid); err == nil {
+			t.Errorf("ParseAuditKey(%q): expected an error", invalid)
+		}
+	}
+
+	publicKey := hex.EncodeToString(key.Public().(ed25519.PublicKey))
+	keys, err := ParseAuditPublicKeys(" " + publicKey + ", ")
+	if err != nil || len(keys) != 1 || !keys[0].Equal(key.Public()) {
+		t.Errorf("ParseAuditPublicKeys() = %v, %v", keys, err)
+	}
+	if _, err := ParseAuditPublicKeys(seed + "00"); err == nil {
+		t.Error("ParseAuditPublicKeys: expected an error for a key of the wrong size")
+	}
+}
+
+// 追記した取引を書き換えると、検証でその取引が最初に壊れた箇所として報告されること
+func TestVerifyAuditLog_DetectsModifiedTransaction(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	from := createTestAccount(t, db, AccountTypeChecking, 10000)
+	to := createTestAccount(t, db, AccountTypeChecking, 0)
+	_, _, transaction, err := executeTransferWithLockOrder(ctx, db, from.ID, to.ID, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		n, err := SealAuditLog(ctx, db, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	// テストDBに前回までのチェックポイントが残っていても検証できるよう、固定の鍵を使う
+	key, err := ParseAuditKey(strings.Repeat("01", ed25519.SeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}
+	if _, err := CreateAuditCheckpoint(ctx, db, key); err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyAuditLog(ctx, db, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid {
+		t.Fatalf("intact log reported broken: %+v", report.FirstBrokenLink)
+	}
+
+	if err := db.Exec("UPDATE transactions SET amount = amount + 1 WHERE id = ?", transaction.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("UPDATE transactions SET amount = amount - 1 WHERE id = ?", transaction.ID) })
+
+	report, err = VerifyAuditLog(ctx, db, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid || report.FirstBrokenLink == nil || report.FirstBrokenLink.TransactionID != transaction.ID {
+		t.Fatalf("report = %+v; expected transaction %d to be reported", report.FirstBrokenLink, transaction.ID)
+	}
+
+	if err := db.Exec("DELETE FROM audit_log_entries WHERE transaction_id = ?", transaction.ID).Error; err == nil {
+		t.Error("audit log entries should not be deletable")
+	}
+}
+
+// デモの初期化は監査ログを消さずにリセットを記録し、消した取引は改ざんとして扱わないこと
+func TestResetAccounts_RecordsAuditReset(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	from := createTestAccount(t, db, AccountTypeChecking, 10000)
+	to := createTestAccount(t, db, AccountTypeChecking, 0)
+	_, _, transaction, err := executeTransferWithLockOrder(ctx, db, from.ID, to.ID, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseAuditKey(strings.Repeat("01", ed25519.SeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SealAuditLog(ctx, db, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateAuditCheckpoint(ctx, db, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// 追記していない取引も、リセットの前に追記される
+	_, _, unsealed, err := executeTransferWithLockOrder(ctx, db, from.ID, to.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resetAccounts(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []AuditLogEntry
+	if err := db.Where("transaction_id IN ?", []uint{transaction.ID, unsealed.ID}).Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit entries = %d; expected both transactions to stay in the log", len(entries))
+	}
+	last, err := lastAuditEntry(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset, ok := parseAuditReset(last); !ok || reset.PurgedThrough < unsealed.ID {
+		t.Fatalf("last entry = %+v; expected a reset covering transaction %d", last, unsealed.ID)
+	}
+
+	report, err := VerifyAuditLog(ctx, db, []ed25519.PublicKey{key.Public().(ed25519.PublicKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid {
+		t.Fatalf("log after reset reported broken: %+v", report.FirstBrokenLink)
+	}
+
+	if err := db.Exec("TRUNCATE TABLE audit_log_entries").Error; err == nil {
+		t.Error("audit log entries should not be truncatable")
+	}
+}
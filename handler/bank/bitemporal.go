@@ -0,0 +1,392 @@
+package bank
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 口座残高の二時点（bitemporal）履歴
+//
+// 残高の版ごとに、残高が有効になった日時（valid time: ValidFrom）と、その残高をDBが知った日時
+// （transaction time: RecordedAt）を記録する。「日時Tの残高を、日時T'の時点ではどう認識していたか」は、
+// RecordedAt <= T' の版のうち ValidFrom <= T で最も新しいものになる（同じValidFromなら後に記録した版）。
+//
+// 通常の残高の変更はaccountsのトリガーが「今から有効」として記録する。過去に遡る訂正仕訳は、
+// 訂正後の残高を同じValidFromで新しい版として追記するだけで、既存の版は書き換えない。
+// 履歴は追記のみ（UPDATE・DELETEは監査ログと同じトリガーで禁止）。
+//
+// RecordedAtはコミット日時ではなく変更した日時（clock_timestamp）。口座の行ロックを持ったまま
+// 記録するので、同じ口座の版の順序は残高を変更した順と一致する。
+
+// AccountBalanceVersion 口座残高の版
+type AccountBalanceVersion struct {
+	ID             uint64    `gorm:"primaryKey" json:"id"`
+	AccountID      uint      `gorm:"not null;index:idx_balance_history,priority:1" json:"account_id"`
+	Balance        int64     `gorm:"not null" json:"balance"`
+	ValidFrom      time.Time `gorm:"not null;index:idx_balance_history,priority:2" json:"valid_from"`  // この残高が有効になった日時
+	RecordedAt     time.Time `gorm:"not null;index:idx_balance_history,priority:3" json:"recorded_at"` // この版を記録した日時
+	JournalEntryID *uint     `json:"journal_entry_id,omitempty"`                                       // 訂正仕訳で追記した版のみ
+}
+
+// TableName テーブル名
+func (AccountBalanceVersion) TableName() string {
+	return "account_balance_history"
+}
+
+var (
+	// ErrCorrectionOutOfRange 訂正の有効日時が履歴の範囲外（口座の最初の版より前、または未来）
+	ErrCorrectionOutOfRange = errors.New("correction is outside the balance history")
+	// ErrInvalidCorrection 訂正の内容が不正
+	ErrInvalidCorrection = errors.New("invalid correction")
+)
+
+// balanceHistorySetting 履歴のトリガーを止めるセッション変数（訂正仕訳では版を自分で追記する）
+const balanceHistorySetting = "bank.balance_history"
+
+// ensureBalanceHistory accountsの残高の変更を履歴に記録するトリガーを作成し、履歴のない口座の現在の残高を記録する
+//
+// 行ごとではなく文ごとのトリガーにして、COPYで大量の口座を投入しても1文で記録する。
+// CREATE OR REPLACE TRIGGERで置き換えるので、トリガーが外れて残高の変更を記録し損ねる瞬間はない。
+// 起動時にMigrateから1回だけ呼ぶ（リクエストのたびにaccountsのトリガーを作り直さない）。
+func ensureBalanceHistory(db *gorm.DB) error {
+	if err := db.Exec(`CREATE OR REPLACE FUNCTION record_account_balance_history() RETURNS trigger AS $$
+DECLARE
+	changed_at timestamptz := clock_timestamp();
+BEGIN
+	IF current_setting('` + balanceHistorySetting + `', true) = 'off' THEN
+		RETURN NULL;
+	END IF;
+	IF TG_OP = 'INSERT' THEN
+		INSERT INTO account_balance_history (account_id, balance, valid_from, recorded_at)
+		SELECT n.id, n.balance, changed_at, changed_at FROM new_rows n;
+	ELSE
+		INSERT INTO account_balance_history (account_id, balance, valid_from, recorded_at)
+		SELECT n.id, n.balance, changed_at, changed_at FROM new_rows n JOIN old_rows o ON o.id = n.id
+		WHERE n.balance IS DISTINCT FROM o.balance;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`).Error; err != nil {
+		return fmt.Errorf("failed to create balance history trigger function: %w", err)
+	}
+	triggers := []string{
+		`CREATE OR REPLACE TRIGGER accounts_balance_history_insert AFTER INSERT ON accounts
+	REFERENCING NEW TABLE AS new_rows
+	FOR EACH STATEMENT EXECUTE FUNCTION record_account_balance_history()`,
+		`CREATE OR REPLACE TRIGGER accounts_balance_history_update AFTER UPDATE ON accounts
+	REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+	FOR EACH STATEMENT EXECUTE FUNCTION record_account_balance_history()`,
+		`CREATE OR REPLACE TRIGGER account_balance_history_append_only BEFORE UPDATE OR DELETE ON account_balance_history
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only()`,
+	}
+	for _, stmt := range triggers {
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("failed to create balance history trigger: %w", err)
+		}
+	}
+	// トリガーより前からある口座は、最後に更新された時点からの残高を今知ったものとして記録する
+	if err := db.Exec(`INSERT INTO account_balance_history (account_id, balance, valid_from, recorded_at)
+		SELECT a.id, a.balance, a.updated_at, clock_timestamp() FROM accounts a
+		WHERE NOT EXISTS (SELECT 1 FROM account_balance_history h WHERE h.account_id = a.id)`).Error; err != nil {
+		return fmt.Errorf("failed to backfill balance history: %w", err)
+	}
+	return nil
+}
+
+// balanceAsOf 有効日時validAtの残高を、knownAtの時点の認識で取得（その時点で版が無ければnil）
+func balanceAsOf(db *gorm.DB, accountID uint, validAt, knownAt time.Time) (*AccountBalanceVersion, error) {
+	var version AccountBalanceVersion
+	result := db.Where("account_id = ? AND valid_from <= ? AND recorded_at <= ?", accountID, validAt, knownAt).
+		Order("valid_from DESC, recorded_at DESC, id DESC").
+		Limit(1).
+		Find(&version)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get balance of account %d: %w", accountID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &version, nil
+}
+
+// backdatedBalanceSQL 有効日時@valid以降の全ての版を、残高を@deltaだけ変えて追記する
+//
+// 現在の認識での各ValidFromの残高（同じValidFromなら最後に記録した版）に@deltaを足す。
+// @validちょうどの版が無ければ、その直前の版の残高に@deltaを足した版を@validに追加する。
+const backdatedBalanceSQL = `WITH known AS (
+	SELECT DISTINCT ON (valid_from) valid_from, balance
+	FROM account_balance_history WHERE account_id = @account
+	ORDER BY valid_from, recorded_at DESC, id DESC
+), clock AS (
+	SELECT clock_timestamp() AS recorded_at
+)
+INSERT INTO account_balance_history (account_id, balance, valid_from, recorded_at, journal_entry_id)
+SELECT CAST(@account AS bigint),
+	COALESCE((SELECT balance FROM known WHERE valid_from < CAST(@valid AS timestamptz) ORDER BY valid_from DESC LIMIT 1), 0) + CAST(@delta AS bigint),
+	CAST(@valid AS timestamptz), clock.recorded_at, CAST(@entry AS bigint)
+FROM clock WHERE NOT EXISTS (SELECT 1 FROM known WHERE valid_from = CAST(@valid AS timestamptz))
+UNION ALL
+SELECT CAST(@account AS bigint), known.balance + CAST(@delta AS bigint), known.valid_from, clock.recorded_at, CAST(@entry AS bigint)
+FROM known, clock WHERE known.valid_from >= CAST(@valid AS timestamptz)`
+
+// recordBackdatedBalance 訂正仕訳による残高の変更を、有効日時validAtに遡って履歴に追記する
+//
+// 口座の行ロックは呼び出し側の責任。
+func recordBackdatedBalance(tx *gorm.DB, accountID, entryID uint, validAt time.Time, delta int64) error {
+	if err := tx.Exec(backdatedBalanceSQL, map[string]interface{}{
+		"account": accountID,
+		"valid":   validAt,
+		"delta":   delta,
+		"entry":   entryID,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to record backdated balance of account %d: %w", accountID, err)
+	}
+	return nil
+}
+
+// CorrectionRequest 訂正仕訳のリクエスト
+type CorrectionRequest struct {
+	AccountID uint      `json:"account_id"`
+	Amount    int64     `json:"amount"`   // 残高の増減（口座の通貨の最小単位。減らす場合は負）
+	ValidAt   time.Time `json:"valid_at"` // 残高に反映する有効日時（過去のみ）
+	Reason    string    `json:"reason"`
+}
+
+// CorrectionResult 訂正仕訳の結果
+type CorrectionResult struct {
+	TransactionID  uint      `json:"transaction_id"`
+	JournalEntryID uint      `json:"journal_entry_id"`
+	AccountID      uint      `json:"account_id"`
+	Amount         int64     `json:"amount"`
+	ValidAt        time.Time `json:"valid_at"`
+	Balance        int64     `json:"balance"` // 訂正後の現在の残高
+}
+
+// postCorrection 訂正仕訳を記帳し、有効日時に遡って残高の履歴を追記する
+//
+// 相手勘定は訂正用のシステム口座（SYS-CORRECTION。外貨はSYS-CORRECTION-USDなど）。
+// 取引履歴（correction）とoutboxのイベントも振込と同じように作成する。記帳日時は現在なので
+// 明細の累積残高には記帳した時点で反映され、有効日時はJournalEntry.ValidAtに残る。
+func postCorrection(tx *gorm.DB, req CorrectionRequest) (*CorrectionResult, error) {
+	if req.Amount == 0 {
+		return nil, fmt.Errorf("%w: amount must not be zero", ErrInvalidCorrection)
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return nil, fmt.Errorf("%w: reason is required", ErrInvalidCorrection)
+	}
+	if req.ValidAt.IsZero() || req.ValidAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: valid_at must be in the past", ErrCorrectionOutOfRange)
+	}
+
+	account, err := lockAccount(tx, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.ID == 0 || account.IsSystem {
+		return nil, ErrAccountNotFound
+	}
+	var first sql.NullTime
+	if err := tx.Raw("SELECT MIN(valid_from) FROM account_balance_history WHERE account_id = ?", account.ID).Row().Scan(&first); err != nil {
+		return nil, fmt.Errorf("failed to get balance history of account %d: %w", account.ID, err)
+	}
+	if !first.Valid || req.ValidAt.Before(first.Time) {
+		return nil, fmt.Errorf("%w: account %d has no balance before %s", ErrCorrectionOutOfRange, account.ID, req.ValidAt.Format(time.RFC3339))
+	}
+	if req.Amount < 0 {
+		if err := checkWithdrawal(account, -req.Amount); err != nil {
+			return nil, err
+		}
+	}
+	// システム口座の作成はトリガーで通常どおり記録する
+	counter, err := currencySystemAccount(tx, SystemAccountCorrection, "訂正", accountCurrency(account))
+	if err != nil {
+		return nil, err
+	}
+
+	from, to, amount := counter, account, req.Amount
+	if amount < 0 {
+		from, to, amount = account, counter, -amount
+	}
+	transaction := Transaction{
+		FromAccountID:   from.ID,
+		ToAccountID:     to.ID,
+		Amount:          amount,
+		Currency:        string(accountCurrency(account)),
+		ToAmount:        amount,
+		ToCurrency:      string(accountCurrency(account)),
+		Status:          TransactionStatusCompleted,
+		TransactionType: TransactionTypeCorrection,
+	}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return nil, fmt.Errorf("failed to create transaction: %w", err)
+	}
+	if err := enqueueTransferEvents(tx, &transaction); err != nil {
+		return nil, err
+	}
+
+	// 「今から有効」の版は記録せず、有効日時に遡った版だけを追記する
+	if err := tx.Exec("SELECT set_config(?, 'off', true)", balanceHistorySetting).Error; err != nil {
+		return nil, fmt.Errorf("failed to disable balance history trigger: %w", err)
+	}
+	validAt := req.ValidAt
+	entry := JournalEntry{TransactionID: &transaction.ID, Description: truncate("correction: "+reason, 200), ValidAt: &validAt}
+	balances, err := postEntry(tx, &entry, []Posting{
+		{AccountID: account.ID, Amount: req.Amount},
+		{AccountID: counter.ID, Amount: -req.Amount},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Exec("SELECT set_config(?, '', true)", balanceHistorySetting).Error; err != nil {
+		return nil, fmt.Errorf("failed to enable balance history trigger: %w", err)
+	}
+	if err := recordBackdatedBalance(tx, account.ID, entry.ID, validAt, req.Amount); err != nil {
+		return nil, err
+	}
+	if err := recordBackdatedBalance(tx, counter.ID, entry.ID, validAt, -req.Amount); err != nil {
+		return nil, err
+	}
+
+	return &CorrectionResult{
+		TransactionID:  transaction.ID,
+		JournalEntryID: entry.ID,
+		AccountID:      account.ID,
+		Amount:         req.Amount,
+		ValidAt:        validAt,
+		Balance:        balances[account.ID],
+	}, nil
+}
+
+// CorrectionHandler 過去の有効日時に遡る訂正仕訳を記帳
+func (h *Handler) CorrectionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CorrectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var result *CorrectionResult
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = postCorrection(tx, req)
+		return err
+	})
+	switch {
+	case errors.Is(err, ErrInvalidCorrection), errors.Is(err, ErrCorrectionOutOfRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrAccountNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrInsufficientFunds):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, result)
+}
+
+// BalanceAsOf as-of照会の結果
+type BalanceAsOf struct {
+	AccountID uint                   `json:"account_id"`
+	ValidAt   time.Time              `json:"valid_at"`
+	KnownAt   time.Time              `json:"known_at"`
+	Balance   *int64                 `json:"balance"` // knownAtの時点でvalidAtの残高を知らなければnull
+	Version   *AccountBalanceVersion `json:"version,omitempty"`
+}
+
+// timeParam RFC3339のクエリパラメータ（省略時はdef）
+func timeParam(values url.Values, name string, def time.Time) (time.Time, error) {
+	raw := strings.TrimSpace(values.Get(name))
+	if raw == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s (use RFC3339): %w", name, err)
+	}
+	return t, nil
+}
+
+// BalanceAsOfHandler 有効日時valid_atの残高を、known_atの時点の認識で返す（どちらも省略すると現在）
+//
+// 例: /api/bank/accounts/balance-as-of?account_id=1&valid_at=2024-03-01T00:00:00Z&known_at=2024-03-10T00:00:00Z
+func (h *Handler) BalanceAsOfHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	accountID, err := strconv.ParseUint(q.Get("account_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "account_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	validAt, err := timeParam(q, "valid_at", now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	knownAt, err := timeParam(q, "known_at", now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version, err := balanceAsOf(h.DB.WithContext(r.Context()), uint(accountID), validAt, knownAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := BalanceAsOf{AccountID: uint(accountID), ValidAt: validAt, KnownAt: knownAt, Version: version}
+	if version != nil {
+		result.Balance = &version.Balance
+	}
+	jsonResponse(w, result)
+}
+
+// BalanceHistoryHandler 口座残高の版を記録の新しい順に返す（limitで件数を指定。既定100件、最大1000件）
+func (h *Handler) BalanceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	accountID, err := strconv.ParseUint(q.Get("account_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "account_id parameter is required", http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if raw := q.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 || limit > 1000 {
+			http.Error(w, "Invalid limit (1-1000)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	versions := []AccountBalanceVersion{}
+	if err := h.DB.WithContext(r.Context()).
+		Where("account_id = ?", accountID).
+		Order("recorded_at DESC, id DESC").
+		Limit(limit).
+		Find(&versions).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{
+		"account_id": accountID,
+		"versions":   versions,
+	})
+}
@@ -0,0 +1,107 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// dbNow DBの時計での現在時刻（履歴のRecordedAtと同じ時計で比較するため）
+func dbNow(t *testing.T, db *gorm.DB) time.Time {
+	t.Helper()
+	var now time.Time
+	if err := db.Raw("SELECT clock_timestamp()").Row().Scan(&now); err != nil {
+		t.Fatal(err)
+	}
+	return now
+}
+
+// 過去に遡る訂正仕訳は、訂正前の認識を残したまま、訂正後の認識でだけ過去の残高を変えること
+func TestBalanceAsOf_Correction(t *testing.T) {
+	db := openTestDB(t)
+	account := createTestAccount(t, db, AccountTypeChecking, 1000)
+	payer := createTestAccount(t, db, AccountTypeChecking, 10000)
+	beforeTransfer := dbNow(t, db)
+
+	if _, _, _, err := executeTransferWithLockOrder(context.Background(), db, payer.ID, account.ID, 500); err != nil {
+		t.Fatal(err)
+	}
+	afterTransfer := dbNow(t, db)
+
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := postCorrection(tx, CorrectionRequest{AccountID: account.ID, Amount: 100, ValidAt: beforeTransfer, Reason: "missed interest"})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	afterCorrection := dbNow(t, db)
+
+	tests := []struct {
+		name             string
+		validAt, knownAt time.Time
+		want             int64
+	}{
+		{"before transfer, known before correction", beforeTransfer, afterTransfer, 1000},
+		{"before transfer, known after correction", beforeTransfer, afterCorrection, 1100},
+		{"after transfer, known before correction", afterTransfer, afterTransfer, 1500},
+		{"after transfer, known after correction", afterTransfer, afterCorrection, 1600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := balanceAsOf(db, account.ID, tt.validAt, tt.knownAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version == nil || version.Balance != tt.want {
+				t.Errorf("balance = %+v; expected %d", version, tt.want)
+			}
+		})
+	}
+	assertLedgerBalance(t, db, account.ID, 1600)
+
+	// 口座の最初の版より前には遡れない
+	err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := postCorrection(tx, CorrectionRequest{AccountID: account.ID, Amount: 100, ValidAt: beforeTransfer.Add(-24 * time.Hour), Reason: "too early"})
+		return err
+	})
+	if !errors.Is(err, ErrCorrectionOutOfRange) {
+		t.Errorf("err = %v; expected ErrCorrectionOutOfRange", err)
+	}
+	// 履歴は書き換えられない
+	if err := db.Exec("UPDATE account_balance_history SET balance = 0 WHERE account_id = ?", account.ID).Error; err == nil {
+		t.Error("updating balance history should fail")
+	}
+}
+
+// Migrateを繰り返してもトリガーは1つずつのままで、残高の変更を記録し続けること
+func TestMigrate_Idempotent(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	var triggers int64
+	if err := db.Raw(`SELECT count(*) FROM pg_trigger
+		WHERE NOT tgisinternal AND tgname IN ('accounts_balance_history_insert', 'accounts_balance_history_update')`).
+		Scan(&triggers).Error; err != nil {
+		t.Fatal(err)
+	}
+	if triggers != 2 {
+		t.Fatalf("balance history triggers = %d; expected 2", triggers)
+	}
+
+	account := createTestAccount(t, db, AccountTypeChecking, 1000)
+	payer := createTestAccount(t, db, AccountTypeChecking, 1000)
+	if _, _, _, err := executeTransferWithLockOrder(context.Background(), db, payer.ID, account.ID, 100); err != nil {
+		t.Fatal(err)
+	}
+	version, err := balanceAsOf(db, account.ID, dbNow(t, db), dbNow(t, db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version == nil || version.Balance != 1100 {
+		t.Errorf("balance = %+v; expected 1100", version)
+	}
+}
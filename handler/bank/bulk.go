@@ -0,0 +1,99 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/keito-isurugi/go-demo/bulkload"
+	"gorm.io/gorm"
+)
+
+var ownerNames = []string{"山田太郎", "佐藤花子", "鈴木一郎", "田中次郎", "高橋美咲", "伊藤健太", "渡辺陽子", "中村翔太"}
+
+// AccountsDataset 口座のテストデータ（残高はDistributionに従って0〜999,000円に散らばる）
+func AccountsDataset() bulkload.Dataset {
+	return bulkload.Dataset{
+		Table:   "accounts",
+		Columns: []string{"account_no", "balance", "owner_name", "created_at", "updated_at"},
+		Row: func(i int, g *bulkload.Generator) []any {
+			return []any{
+				fmt.Sprintf("%010d", i+1),
+				int64(g.Dist.Sample(i, 1000)) * 1000,
+				ownerNames[i%len(ownerNames)],
+				g.Now,
+				g.Now,
+			}
+		},
+	}
+}
+
+// InitBulkAccountsHandler 大量の口座をCOPYで作成するジョブを起動（取引履歴は削除される）
+//
+// rows, distribution, skew, seedで件数と残高の偏りを指定できる。進捗は/demo/bulkload/jobsで確認する。
+func (h *Handler) InitBulkAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := bulkload.ConfigFromQuery(r.URL.Query(), bulkload.Config{Rows: 10000, Distribution: bulkload.DistributionUniform, Truncate: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := autoMigrate(h.DB); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to migrate: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sqlDB, err := h.DB.DB()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	job, err := h.Jobs.Start("accounts", int64(cfg.Rows), func(ctx context.Context, job *bulkload.Job) error {
+		// 取引を消す前に、監査ログにリセットを記録する
+		if err := h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := recordAuditReset(tx, "bulk load accounts"); err != nil {
+				return err
+			}
+			return tx.Exec("TRUNCATE TABLE transactions, idempotency_keys, journal_entries, postings, account_balance_history").Error
+		}); err != nil {
+			return err
+		}
+		copied, err := bulkload.Copy(ctx, sqlDB, AccountsDataset(), cfg, job.SetDone)
+		if err != nil {
+			return err
+		}
+		// COPYで入れた残高を開始残高として元帳に載せる（TRUNCATEでシステム口座も消えている）
+		var migrated int64
+		if err := h.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			migrated, err = migrateOpeningBalances(tx)
+			return err
+		}); err != nil {
+			return err
+		}
+		job.SetResult(map[string]interface{}{"accounts": copied, "ledger_migrated": migrated})
+		return nil
+	})
+	if errors.Is(err, bulkload.ErrJobRunning) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		jsonResponse(w, job.Progress())
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	jsonResponse(w, map[string]interface{}{
+		"job":        job.Progress(),
+		"status_url": "/demo/bulkload/jobs?id=" + job.ID,
+		"events_url": "/demo/bulkload/jobs/events?id=" + job.ID,
+	})
+}
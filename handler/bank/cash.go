@@ -0,0 +1,229 @@
+package bank
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// AccountRule 口座種別ごとの残高ルール
+type AccountRule struct {
+	OverdraftLimit int64 // 当座貸越の上限（この額までマイナス残高を許す）
+	MinBalance     int64 // 出金後に残すべき最低残高（当座貸越がない場合のみ）
+}
+
+// accountRules 口座種別ごとのルール
+var accountRules = map[string]AccountRule{
+	AccountTypeChecking: {OverdraftLimit: 50000},
+	AccountTypeSavings:  {MinBalance: 1000},
+}
+
+// floor 出金後の残高の下限
+func (r AccountRule) floor() int64 {
+	if r.OverdraftLimit > 0 {
+		return -r.OverdraftLimit
+	}
+	return r.MinBalance
+}
+
+var (
+	// ErrInvalidAmount 金額が0以下
+	ErrInvalidAmount = errors.New("amount must be positive")
+	// ErrAccountNotFound 口座が存在しない（またはシステム口座）
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrInsufficientFunds 出金すると残高の下限を下回る
+	ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// CashRequest 入金・出金リクエスト
+type CashRequest struct {
+	AccountID uint  `json:"account_id"`
+	Amount    int64 `json:"amount"`
+}
+
+// CashResponse 入金・出金レスポンス
+type CashResponse struct {
+	Success       bool   `json:"success"`
+	Message       string `json:"message"`
+	TransactionID uint   `json:"transaction_id,omitempty"`
+	Status        string `json:"status,omitempty"`
+	Balance       int64  `json:"balance"`
+}
+
+// checkWithdrawal 口座種別のルールに従って出金できるか確認
+func checkWithdrawal(account *Account, amount int64) error {
+	rule := accountRules[account.AccountType]
+	if after := account.Balance - amount; after < rule.floor() {
+		return fmt.Errorf("%w: balance after withdrawal %d is below %d (%s)", ErrInsufficientFunds, after, rule.floor(), account.AccountType)
+	}
+	return nil
+}
+
+// transitionTransaction 取引のステータスをpendingから遷移させる（pending以外からは遷移できない）
+func transitionTransaction(tx *gorm.DB, transactionID uint, status, reason string) error {
+	result := tx.Model(&Transaction{}).
+		Where("id = ? AND status = ?", transactionID, TransactionStatusPending).
+		Updates(map[string]interface{}{"status": status, "failure_reason": reason})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update transaction %d: %w", transactionID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("transaction %d is not pending", transactionID)
+	}
+	return nil
+}
+
+// executeCash 入金・出金を実行
+//
+//  1. 取引をpendingで記録してコミット（失敗しても受け付けた記録が残る）
+//  2. 口座をlockAccountでロックし、ルールを確認して記帳、同じトランザクションでcompletedにする
+//  3. 失敗した場合はfailedと理由を記録する
+//
+// 現金口座（SYS-CASH。外貨口座はSYS-CASH-USDなど通貨ごと）の残高も同じトランザクションで更新するため、
+// 同じ通貨の入出金同士はこの行で直列化される。
+func (h *Handler) executeCash(ctx context.Context, transactionType string, accountID uint, amount int64) (*Transaction, *Account, error) {
+	if amount <= 0 {
+		return nil, nil, ErrInvalidAmount
+	}
+	db := h.DB.WithContext(ctx)
+
+	// システム口座への入出金は取引を記録する前に拒否する（failedの取引も残さない）
+	var target Account
+	if err := db.Select("id", "currency", "is_system").First(&target, accountID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrAccountNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if target.IsSystem {
+		return nil, nil, ErrAccountNotFound
+	}
+	cash, err := currencySystemAccount(db, SystemAccountCash, "現金", accountCurrency(&target))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transaction := Transaction{
+		FromAccountID:   cash.ID,
+		ToAccountID:     accountID,
+		Amount:          amount,
+		Currency:        string(accountCurrency(&target)),
+		ToAmount:        amount,
+		ToCurrency:      string(accountCurrency(&target)),
+		Status:          TransactionStatusPending,
+		TransactionType: transactionType,
+	}
+	if transactionType == TransactionTypeWithdrawal {
+		transaction.FromAccountID, transaction.ToAccountID = accountID, cash.ID
+	}
+	if err := db.Create(&transaction).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to create transaction: %w", err)
+	}
+
+	var account *Account
+	err = db.Transaction(func(tx *gorm.DB) error {
+		account, err = lockAccount(tx, accountID)
+		if err != nil {
+			return err
+		}
+		if account.ID == 0 || account.IsSystem {
+			return ErrAccountNotFound
+		}
+
+		delta := amount
+		if transactionType == TransactionTypeWithdrawal {
+			if err := checkWithdrawal(account, amount); err != nil {
+				return err
+			}
+			delta = -amount
+		}
+
+		balances, err := postJournalEntry(tx, &transaction.ID, transactionType, []Posting{
+			{AccountID: account.ID, Amount: delta},
+			{AccountID: cash.ID, Amount: -delta},
+		})
+		if err != nil {
+			return err
+		}
+		account.Balance = balances[account.ID]
+		// 記帳がコミットされた入出金だけが下流に配信されるよう、同じトランザクションでoutboxに書き込む
+		if err := enqueueTransferEvents(tx, &transaction); err != nil {
+			return err
+		}
+		return transitionTransaction(tx, transaction.ID, TransactionStatusCompleted, "")
+	})
+	if err != nil {
+		// 記帳はロールバックされているので、取引だけfailedにする
+		if terr := transitionTransaction(h.DB.WithContext(context.WithoutCancel(ctx)), transaction.ID, TransactionStatusFailed, truncate(err.Error(), 200)); terr != nil {
+			return &transaction, account, errors.Join(err, terr)
+		}
+		transaction.Status = TransactionStatusFailed
+		return &transaction, account, err
+	}
+
+	transaction.Status = TransactionStatusCompleted
+	return &transaction, account, nil
+}
+
+func truncate(s string, n int) string {
+	if len([]rune(s)) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// DepositHandler 入金
+func (h *Handler) DepositHandler(w http.ResponseWriter, r *http.Request) {
+	h.handleCash(w, r, TransactionTypeDeposit)
+}
+
+// WithdrawHandler 出金（口座種別ごとの当座貸越・最低残高を確認）
+func (h *Handler) WithdrawHandler(w http.ResponseWriter, r *http.Request) {
+	h.handleCash(w, r, TransactionTypeWithdrawal)
+}
+
+func (h *Handler) handleCash(w http.ResponseWriter, r *http.Request, transactionType string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	transaction, account, err := h.executeCash(r.Context(), transactionType, req.AccountID, req.Amount)
+	switch {
+	case errors.Is(err, ErrInvalidAmount):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrAccountNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrInsufficientFunds):
+		jsonResponse(w, CashResponse{
+			Success:       false,
+			Message:       err.Error(),
+			TransactionID: transaction.ID,
+			Status:        transaction.Status,
+			Balance:       account.Balance,
+		})
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, CashResponse{
+		Success:       true,
+		Message:       fmt.Sprintf("%s completed successfully", transactionType),
+		TransactionID: transaction.ID,
+		Status:        transaction.Status,
+		Balance:       account.Balance,
+	})
+}
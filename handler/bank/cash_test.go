@@ -0,0 +1,132 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestCheckWithdrawal(t *testing.T) {
+	tests := []struct {
+		name    string
+		account Account
+		amount  int64
+		wantErr bool
+	}{
+		{"checking within overdraft", Account{AccountType: AccountTypeChecking, Balance: 1000}, 51000, false},
+		{"checking over overdraft", Account{AccountType: AccountTypeChecking, Balance: 1000}, 51001, true},
+		{"savings keeps min balance", Account{AccountType: AccountTypeSavings, Balance: 10000}, 9000, false},
+		{"savings below min balance", Account{AccountType: AccountTypeSavings, Balance: 10000}, 9001, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWithdrawal(&tt.account, tt.amount)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInsufficientFunds)) {
+				t.Errorf("checkWithdrawal() error = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 同時に入金・出金しても更新が失われないこと
+func TestExecuteCash_ConcurrentNoLostUpdates(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+	account := createTestAccount(t, db, AccountTypeChecking, 100000)
+
+	const workers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*2)
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, _, err := h.executeCash(context.Background(), TransactionTypeDeposit, account.ID, 1000); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, _, err := h.executeCash(context.Background(), TransactionTypeWithdrawal, account.ID, 500); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("executeCash() error = %v", err)
+	}
+
+	assertLedgerBalance(t, db, account.ID, 100000+workers*1000-workers*500)
+}
+
+// 最低残高を下回る出金は、同時に実行しても下限を超えた分だけfailedになること
+func TestExecuteCash_ConcurrentWithdrawalsRespectMinBalance(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+	account := createTestAccount(t, db, AccountTypeSavings, 100000)
+
+	const workers = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	completed, failed := 0, 0
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transaction, _, err := h.executeCash(context.Background(), TransactionTypeWithdrawal, account.ID, 30000)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil && transaction.Status == TransactionStatusCompleted:
+				completed++
+			case errors.Is(err, ErrInsufficientFunds) && transaction.Status == TransactionStatusFailed:
+				failed++
+			default:
+				t.Errorf("executeCash() = %+v, %v", transaction, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 100000 - 30000*3 = 10000 >= 1000、4回目は最低残高を下回る
+	if completed != 3 || failed != workers-3 {
+		t.Errorf("completed = %d, failed = %d; expected 3 and %d", completed, failed, workers-3)
+	}
+	assertLedgerBalance(t, db, account.ID, 10000)
+
+	var pending int64
+	if err := db.Model(&Transaction{}).Where("from_account_id = ? AND status = ?", account.ID, TransactionStatusPending).Count(&pending).Error; err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Errorf("pending transactions = %d; expected 0", pending)
+	}
+}
+
+// システム口座への入出金は、取引を記録せずに拒否すること
+func TestExecuteCash_RejectsSystemAccountWithoutTransaction(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+	cash, err := systemAccount(db, SystemAccountCash, "現金")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, transactionType := range []string{TransactionTypeDeposit, TransactionTypeWithdrawal} {
+		transaction, _, err := h.executeCash(context.Background(), transactionType, cash.ID, 1000)
+		if !errors.Is(err, ErrAccountNotFound) || transaction != nil {
+			t.Errorf("%s: executeCash() = %+v, %v; expected ErrAccountNotFound", transactionType, transaction, err)
+		}
+	}
+
+	// 現金口座から現金口座への取引は、拒否されたリクエスト以外では作られない
+	var stray int64
+	if err := db.Model(&Transaction{}).Where("from_account_id = ? AND to_account_id = ?", cash.ID, cash.ID).Count(&stray).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stray != 0 {
+		t.Errorf("stray transactions = %d; expected 0", stray)
+	}
+}
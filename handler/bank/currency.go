@@ -0,0 +1,118 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Currency ISO 4217の通貨コード（demo/ddd/domain.Moneyと同じく、金額は必ず通貨とセットで扱う）
+type Currency string
+
+// 対応している通貨
+const (
+	CurrencyJPY Currency = "JPY"
+	CurrencyUSD Currency = "USD"
+	CurrencyEUR Currency = "EUR"
+	CurrencyGBP Currency = "GBP"
+	CurrencyKRW Currency = "KRW"
+	CurrencyKWD Currency = "KWD"
+)
+
+// currencyMinorUnits 通貨ごとの補助単位の桁数（残高・金額はこの最小単位の整数で持つ。JPYなら円、USDならセント）
+var currencyMinorUnits = map[Currency]int{
+	CurrencyJPY: 0,
+	CurrencyUSD: 2,
+	CurrencyEUR: 2,
+	CurrencyGBP: 2,
+	CurrencyKRW: 0,
+	CurrencyKWD: 3,
+}
+
+var (
+	// ErrUnsupportedCurrency 対応していない通貨
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	// ErrCurrencyMismatch 通貨の異なる口座間の操作に対応していない
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	// ErrAmountTooSmall 換算すると送金先の最小単位に満たない
+	ErrAmountTooSmall = errors.New("converted amount rounds to zero")
+)
+
+// ParseCurrency 通貨コードを検証する（空文字はJPY）
+func ParseCurrency(code string) (Currency, error) {
+	if code == "" {
+		return CurrencyJPY, nil
+	}
+	c := Currency(code)
+	if _, ok := currencyMinorUnits[c]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedCurrency, code)
+	}
+	return c, nil
+}
+
+// accountCurrency 口座の通貨（通貨を導入する前の口座はJPY）
+func accountCurrency(a *Account) Currency {
+	if a.Currency == "" {
+		return CurrencyJPY
+	}
+	return Currency(a.Currency)
+}
+
+// Money 最小単位の金額と通貨
+type Money struct {
+	Amount   int64    `json:"amount"` // 最小単位
+	Currency Currency `json:"currency"`
+}
+
+// String 補助単位の桁数に合わせて表示する（例: 12.34 USD）
+func (m Money) String() string {
+	digits := currencyMinorUnits[m.Currency]
+	return new(big.Rat).SetFrac(big.NewInt(m.Amount), pow10(digits)).FloatString(digits) + " " + string(m.Currency)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// convertMoney 為替レート（1 from = rate to）で換算し、送金先通貨の最小単位に丸める
+//
+// 端数は最も近い値に丸め、ちょうど半分の場合は偶数側に丸める（銀行丸め）。
+// 計算はbig.Ratで行うため、浮動小数点の誤差は入らない。
+func convertMoney(m Money, to Currency, rate *big.Rat) (Money, error) {
+	fromDigits, ok := currencyMinorUnits[m.Currency]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, m.Currency)
+	}
+	toDigits, ok := currencyMinorUnits[to]
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnsupportedCurrency, to)
+	}
+
+	// amount / 10^fromDigits * rate * 10^toDigits
+	v := new(big.Rat).SetInt64(m.Amount)
+	v.Mul(v, rate)
+	v.Mul(v, new(big.Rat).SetFrac(pow10(toDigits), pow10(fromDigits)))
+
+	rounded := roundHalfEven(v)
+	if !rounded.IsInt64() {
+		return Money{}, fmt.Errorf("converted amount overflows: %s", rounded)
+	}
+	return Money{Amount: rounded.Int64(), Currency: to}, nil
+}
+
+// roundHalfEven 有理数を最も近い整数に丸める（ちょうど半分なら偶数）
+func roundHalfEven(r *big.Rat) *big.Int {
+	num, den := r.Num(), r.Denom() // denは常に正
+	q, m := new(big.Int).DivMod(num, den, new(big.Int))
+	// DivModはユークリッド除算なので 0 <= m < den、qは切り捨て側
+	twice := new(big.Int).Lsh(m, 1)
+	switch twice.Cmp(den) {
+	case 1:
+		q.Add(q, big.NewInt(1))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
@@ -0,0 +1,79 @@
+package bank
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestConvertMoney(t *testing.T) {
+	rat := func(s string) *big.Rat {
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			t.Fatalf("invalid rate %q", s)
+		}
+		return r
+	}
+	tests := []struct {
+		name string
+		from Money
+		to   Currency
+		rate string
+		want int64
+	}{
+		{"USD cents to JPY", Money{1234, CurrencyUSD}, CurrencyJPY, "151.2345", 1866},                   // 12.34 * 151.2345 = 1866.23
+		{"JPY to USD cents", Money{10000, CurrencyJPY}, CurrencyUSD, "0.0066123", 6612},                 // 66.123 -> 66.12
+		{"half rounds to even (down)", Money{1, CurrencyUSD}, CurrencyJPY, "50", 0},                     // 0.5 -> 0
+		{"half rounds to even (up)", Money{3, CurrencyUSD}, CurrencyJPY, "50", 2},                       // 1.5 -> 2
+		{"three minor digits", Money{1000, CurrencyJPY}, CurrencyKWD, "0.00203", 2030},                  // 2.030 KWD
+		{"KWD to EUR", Money{1234, CurrencyKWD}, CurrencyEUR, "2.98765", 369},                           // 1.234 * 2.98765 = 3.6867 -> 3.69
+		{"large amount is exact", Money{900719925474099, CurrencyUSD}, CurrencyJPY, "1", 9007199254741}, // 9007199254740.99 -> ...41
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertMoney(tt.from, tt.to, rat(tt.rate))
+			if err != nil {
+				t.Fatalf("convertMoney() error = %v", err)
+			}
+			if got.Amount != tt.want || got.Currency != tt.to {
+				t.Errorf("convertMoney(%v) = %v; expected %d %s", tt.from, got, tt.want, tt.to)
+			}
+		})
+	}
+
+	if _, err := convertMoney(Money{100, "XYZ"}, CurrencyJPY, rat("1")); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("convertMoney(XYZ) error = %v; expected ErrUnsupportedCurrency", err)
+	}
+}
+
+func TestRoundHalfEven(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"2.5", 2}, {"3.5", 4}, {"2.49", 2}, {"2.51", 3},
+		{"-2.5", -2}, {"-3.5", -4}, {"-2.51", -3}, {"-2.49", -2}, {"0", 0},
+	}
+	for _, tt := range tests {
+		r, _ := new(big.Rat).SetString(tt.in)
+		if got := roundHalfEven(r); got.Int64() != tt.want {
+			t.Errorf("roundHalfEven(%s) = %s; expected %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{1234, CurrencyUSD}, "12.34 USD"},
+		{Money{1234, CurrencyJPY}, "1234 JPY"},
+		{Money{5, CurrencyKWD}, "0.005 KWD"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("String() = %q; expected %q", got, tt.want)
+		}
+	}
+}
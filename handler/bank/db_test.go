@@ -0,0 +1,69 @@
+package bank
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB BANK_TEST_DSNのPostgreSQLに接続する（未設定の場合はテストをスキップ）
+//
+// 例: BANK_TEST_DSN="host=localhost user=postgres password=postgres dbname=go_demo_test port=5432 sslmode=disable" go test ./handler/bank
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("BANK_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BANK_TEST_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同時実行のテストでmax_connectionsを超えないようにする
+	sqlDB.SetMaxOpenConns(20)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// createTestAccount 他のテストと重ならない口座番号で口座を開設
+func createTestAccount(t *testing.T, db *gorm.DB, accountType string, balance int64) *Account {
+	t.Helper()
+	account := &Account{
+		AccountNo:   fmt.Sprintf("T%d", time.Now().UnixNano()%1e15),
+		Balance:     balance,
+		OwnerName:   t.Name(),
+		AccountType: accountType,
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error { return openAccount(tx, account) }); err != nil {
+		t.Fatalf("failed to open account: %v", err)
+	}
+	return account
+}
+
+// assertLedgerBalance 口座の残高が期待値で、記帳の合計とも一致することを確認
+func assertLedgerBalance(t *testing.T, db *gorm.DB, accountID uint, expected int64) {
+	t.Helper()
+	var account Account
+	if err := db.First(&account, accountID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var postings int64
+	if err := db.Raw("SELECT COALESCE(SUM(amount), 0) FROM postings WHERE account_id = ?", accountID).Scan(&postings).Error; err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != expected || postings != expected {
+		t.Errorf("balance = %d, postings = %d; expected %d", account.Balance, postings, expected)
+	}
+}
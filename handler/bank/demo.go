@@ -8,7 +8,7 @@ import (
 	"time"
 )
 
-// DirtyReadDemoHandler Dirty Readを再現するデモ
+// DirtyReadDemoHandler Dirty Readを再現するデモ（2本のリクエストをsleepの間に送る。1リクエストで再現するには /api/bank/scenarios?name=dirty-read）
 func (h *Handler) DirtyReadDemoHandler(w http.ResponseWriter, r *http.Request) {
 	accountIDStr := r.URL.Query().Get("account_id")
 	if accountIDStr == "" {
@@ -89,7 +89,7 @@ func (h *Handler) handleDirtyReadRead(w http.ResponseWriter, accountID uint) {
 	})
 }
 
-// PhantomReadDemoHandler Phantom Readを再現するデモ
+// PhantomReadDemoHandler Phantom Readを再現するデモ（1リクエストで再現するには /api/bank/scenarios?name=phantom-read）
 func (h *Handler) PhantomReadDemoHandler(w http.ResponseWriter, r *http.Request) {
 	action := r.URL.Query().Get("action")
 	if action == "" {
@@ -155,7 +155,7 @@ func (h *Handler) handlePhantomReadInsert(w http.ResponseWriter) {
 		OwnerName: "Phantom User",
 	}
 
-	if err := tx.Create(&newAccount).Error; err != nil {
+	if err := openAccount(tx, &newAccount); err != nil {
 		tx.Rollback()
 		http.Error(w, fmt.Sprintf("Failed to create account: %v", err), http.StatusInternalServerError)
 		return
@@ -175,7 +175,7 @@ func (h *Handler) handlePhantomReadInsert(w http.ResponseWriter) {
 	})
 }
 
-// DeadlockDemoHandler デッドロックを再現するデモ
+// DeadlockDemoHandler デッドロックを再現するデモ（1リクエストで再現するには /api/bank/scenarios?name=deadlock）
 func (h *Handler) DeadlockDemoHandler(w http.ResponseWriter, r *http.Request) {
 	action := r.URL.Query().Get("action")
 	if action == "" {
@@ -237,10 +237,11 @@ func (h *Handler) handleDeadlockTX1(w http.ResponseWriter, account1ID, account2I
 		return
 	}
 
-	account1.Balance -= 1000
-	account2.Balance += 1000
-	tx.Save(account1)
-	tx.Save(account2)
+	if _, err := postTransfer(tx, account1, account2, 1000); err != nil {
+		tx.Rollback()
+		http.Error(w, fmt.Sprintf("Failed to post transfer: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		jsonResponse(w, map[string]interface{}{
@@ -282,10 +283,11 @@ func (h *Handler) handleDeadlockTX2(w http.ResponseWriter, account1ID, account2I
 		return
 	}
 
-	account2.Balance -= 1000
-	account1.Balance += 1000
-	tx.Save(account2)
-	tx.Save(account1)
+	if _, err := postTransfer(tx, account2, account1, 1000); err != nil {
+		tx.Rollback()
+		http.Error(w, fmt.Sprintf("Failed to post transfer: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		jsonResponse(w, map[string]interface{}{
@@ -0,0 +1,151 @@
+package bank
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/outbox"
+	"gorm.io/gorm"
+)
+
+// 口座のイベント（outboxに書き込み、リレーが下流に配信する）
+//
+// 集約は口座で、口座ごとに取引の順に配信される。残高が変わる操作（振込・入出金・訂正・開始残高）は
+// すべて、記帳と同じトランザクションでイベントを書き込む。
+const (
+	AggregateAccount = "account"
+
+	EventAccountDebited  = "account.debited"  // 残高が減った
+	EventAccountCredited = "account.credited" // 残高が増えた
+)
+
+// AccountEvent 口座のイベントのpayload
+type AccountEvent struct {
+	TransactionID         uint   `json:"transaction_id,omitempty"` // 開始残高のように取引履歴がない場合は省略
+	AccountID             uint   `json:"account_id"`
+	CounterpartyAccountID uint   `json:"counterparty_account_id,omitempty"` // 開始残高の移行のように相手が複数の口座の場合は省略
+	Amount                int64  `json:"amount"`                            // 口座の通貨の最小単位
+	Currency              string `json:"currency"`                          // 口座の通貨
+	TransactionType       string `json:"transaction_type"`
+}
+
+// enqueueTransferEvents 取引履歴と同じトランザクションで、送金元・送金先のイベントを書き込む
+//
+// outboxは集約ごとにアドバイザリロックを取る。口座の行ロックを先に取る経路（transfer-safeなど）と
+// デッドロックしないよう、ここでも口座IDの小さい順に行ロックを取ってから書き込む。
+func enqueueTransferEvents(tx *gorm.DB, t *Transaction) error {
+	var locked []uint
+	if err := tx.Raw("SELECT id FROM accounts WHERE id IN ? ORDER BY id FOR UPDATE",
+		[]uint{t.FromAccountID, t.ToAccountID}).Scan(&locked).Error; err != nil {
+		return fmt.Errorf("failed to lock accounts for events: %w", err)
+	}
+
+	debited := AccountEvent{
+		TransactionID:         t.ID,
+		AccountID:             t.FromAccountID,
+		CounterpartyAccountID: t.ToAccountID,
+		Amount:                t.Amount,
+		Currency:              t.Currency,
+		TransactionType:       t.TransactionType,
+	}
+	credited := AccountEvent{
+		TransactionID:         t.ID,
+		AccountID:             t.ToAccountID,
+		CounterpartyAccountID: t.FromAccountID,
+		Amount:                t.ToAmount,
+		Currency:              t.ToCurrency,
+		TransactionType:       t.TransactionType,
+	}
+	events := []struct {
+		eventType string
+		payload   AccountEvent
+	}{{EventAccountDebited, debited}, {EventAccountCredited, credited}}
+	if t.ToAccountID < t.FromAccountID {
+		events[0], events[1] = events[1], events[0]
+	}
+	for _, e := range events {
+		if err := enqueueAccountEvent(tx, e.eventType, e.payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueAccountEvent 口座のイベントを1件書き込む（口座の行ロックは呼び出し側で取っておく）
+func enqueueAccountEvent(tx *gorm.DB, eventType string, payload AccountEvent) error {
+	aggregateID := strconv.FormatUint(uint64(payload.AccountID), 10)
+	_, err := outbox.Enqueue(tx, AggregateAccount, aggregateID, eventType, payload)
+	return err
+}
+
+// enqueueOpeningBalanceEvents 開始残高の記帳と同じトランザクションで、口座と開始残高の口座のイベントを書き込む
+func enqueueOpeningBalanceEvents(tx *gorm.DB, account, opening *Account, amount int64) error {
+	from, to := opening, account
+	if amount < 0 {
+		from, to, amount = account, opening, -amount
+	}
+	currency := string(accountCurrency(account))
+	return enqueueTransferEvents(tx, &Transaction{
+		FromAccountID:   from.ID,
+		ToAccountID:     to.ID,
+		Amount:          amount,
+		Currency:        currency,
+		ToAmount:        amount,
+		ToCurrency:      currency,
+		TransactionType: TransactionTypeOpening,
+	})
+}
+
+// outboxRelay 未設定の場合はログに出力するだけのリレーを使う
+func (h *Handler) outboxRelay() *outbox.Relay {
+	if h.Outbox != nil {
+		return h.Outbox
+	}
+	return outbox.NewRelay(h.DB, outbox.LogSink{})
+}
+
+// RunOutboxRelay intervalごとにoutboxの未配信のイベントを配信する（ctxが終了するまで）
+func (h *Handler) RunOutboxRelay(ctx context.Context, interval time.Duration) {
+	h.outboxRelay().Run(ctx, interval)
+}
+
+// OutboxHandler outboxの一覧（GET ?status=pending|delivered、既定はpending）
+func (h *Handler) OutboxHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := h.DB.WithContext(r.Context()).Model(&outbox.Message{})
+	switch r.URL.Query().Get("status") {
+	case "", "pending":
+		query = query.Where("delivered_at IS NULL").Order("id")
+	case "delivered":
+		query = query.Where("delivered_at IS NOT NULL").Order("id DESC")
+	default:
+		http.Error(w, "Invalid status (pending, delivered)", http.StatusBadRequest)
+		return
+	}
+	var messages []outbox.Message
+	if err := query.Limit(500).Find(&messages).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, messages)
+}
+
+// OutboxRelayHandler リレーを待たずに未配信のイベントを配信する（POST）
+func (h *Handler) OutboxRelayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	result, err := h.outboxRelay().Drain(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, result)
+}
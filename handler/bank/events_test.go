@@ -0,0 +1,125 @@
+package bank
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/keito-isurugi/go-demo/outbox"
+)
+
+// 振込と同じトランザクションでイベントが書き込まれ、送信に失敗した口座のイベントは順序を保って再送されること
+func TestOutboxRelay_TransferEvents(t *testing.T) {
+	db := openTestDB(t)
+	a := createTestAccount(t, db, AccountTypeChecking, 10000)
+	b := createTestAccount(t, db, AccountTypeChecking, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, _, _, err := executeTransferWithLockOrder(ctx, db, a.ID, b.ID, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var pending int64
+	db.Model(&outbox.Message{}).Where("aggregate_type = ? AND aggregate_id IN ? AND delivered_at IS NULL",
+		AggregateAccount, []string{strconv.Itoa(int(a.ID)), strconv.Itoa(int(b.ID))}).Count(&pending)
+	// 振込6件と、送金元の開始残高の1件
+	if pending != 7 {
+		t.Fatalf("pending events = %d; expected 7", pending)
+	}
+
+	// 送金先の最初のイベントだけ1回失敗させる
+	aID, bID := strconv.Itoa(int(a.ID)), strconv.Itoa(int(b.ID))
+	var mu sync.Mutex
+	delivered := map[string][]uint64{}
+	failed := false
+	sink := outbox.SinkFunc(func(ctx context.Context, msg *outbox.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if msg.AggregateID == bID && !failed {
+			failed = true
+			return errors.New("broker unavailable")
+		}
+		delivered[msg.AggregateID] = append(delivered[msg.AggregateID], msg.ID)
+		return nil
+	})
+	relay := outbox.NewRelay(db, sink)
+
+	if _, err := relay.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 失敗したイベントより後の送金先のイベントは送られていない
+	if n := len(delivered[bID]); n != 0 {
+		t.Fatalf("delivered %d events for the payee after a failure", n)
+	}
+	if n := len(delivered[aID]); n != 4 {
+		t.Fatalf("delivered %d events for the payer; expected 4", n)
+	}
+
+	// リトライを待たずに再送させる
+	db.Model(&outbox.Message{}).Where("aggregate_type = ? AND aggregate_id = ?", AggregateAccount, bID).
+		Update("next_attempt_at", db.NowFunc().Add(-1))
+	if _, err := relay.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]int{aID: 4, bID: 3} {
+		ids := delivered[id]
+		if len(ids) != want {
+			t.Fatalf("account %s: delivered %v; expected %d events", id, ids, want)
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] <= ids[i-1] {
+				t.Errorf("account %s: delivered out of order: %v", id, ids)
+			}
+		}
+	}
+}
+
+// 開始残高・入金・出金でも、記帳と同じトランザクションでイベントが書き込まれること
+func TestAccountEvents_Cash(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+	account := createTestAccount(t, db, AccountTypeChecking, 1000)
+	ctx := context.Background()
+
+	if _, _, err := h.executeCash(ctx, TransactionTypeDeposit, account.ID, 500); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h.executeCash(ctx, TransactionTypeWithdrawal, account.ID, 300); err != nil {
+		t.Fatal(err)
+	}
+	// 失敗した出金はイベントを残さない
+	if _, _, err := h.executeCash(ctx, TransactionTypeWithdrawal, account.ID, 1000000); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("err = %v; expected ErrInsufficientFunds", err)
+	}
+
+	var messages []outbox.Message
+	if err := db.Where("aggregate_type = ? AND aggregate_id = ?", AggregateAccount, strconv.Itoa(int(account.ID))).
+		Order("id").Find(&messages).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		eventType       string
+		transactionType string
+		amount          int64
+	}{
+		{EventAccountCredited, TransactionTypeOpening, 1000},
+		{EventAccountCredited, TransactionTypeDeposit, 500},
+		{EventAccountDebited, TransactionTypeWithdrawal, 300},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("events = %d; expected %d", len(messages), len(want))
+	}
+	for i, w := range want {
+		var payload AccountEvent
+		if err := json.Unmarshal([]byte(messages[i].Payload), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if messages[i].EventType != w.eventType || payload.TransactionType != w.transactionType || payload.Amount != w.amount {
+			t.Errorf("event %d = %s %+v; expected %s %s %d", i, messages[i].EventType, payload, w.eventType, w.transactionType, w.amount)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FxRate 為替レート（1 BaseCurrency = Rate QuoteCurrency）
+//
+// 同じ通貨ペアに複数のレートを登録でき、振込時点でEffectiveFromが最も新しいものを使う。
+type FxRate struct {
+	ID            uint      `gorm:"primaryKey"`
+	BaseCurrency  string    `gorm:"size:3;not null;uniqueIndex:idx_fx_rates_pair_effective,priority:1"`
+	QuoteCurrency string    `gorm:"size:3;not null;uniqueIndex:idx_fx_rates_pair_effective,priority:2"`
+	Rate          string    `gorm:"type:numeric(20,10);not null"`
+	EffectiveFrom time.Time `gorm:"not null;uniqueIndex:idx_fx_rates_pair_effective,priority:3"` // この時刻から有効
+	CreatedAt     time.Time `gorm:"autoCreateTime"`
+}
+
+// fxRateScale 記録する為替レートの小数点以下の桁数（fx_rates.rateの精度と同じ）
+const fxRateScale = 10
+
+// SystemAccountFX 為替の持ち高口座のprefix（通貨ごとにSYS-FX-USDなど）
+const SystemAccountFX = "SYS-FX"
+
+// ErrNoFxRate 振込時点で有効な為替レートがない
+var ErrNoFxRate = errors.New("no fx rate available")
+
+// AppliedFxRate 換算に使ったレート
+type AppliedFxRate struct {
+	RateID        uint      `json:"rate_id"`
+	Rate          string    `json:"rate"` // 1 from = rate to（逆方向のレートから求めた場合は丸めた値）
+	EffectiveFrom time.Time `json:"effective_from"`
+	Inverted      bool      `json:"inverted"` // to/fromのレートの逆数を使った
+}
+
+// findFxRate at時点で有効なfrom→toのレートを探す（無ければto→fromのレートの逆数を使う）
+func findFxRate(tx *gorm.DB, from, to Currency, at time.Time) (*AppliedFxRate, *big.Rat, error) {
+	lookup := func(base, quote Currency) (*FxRate, error) {
+		var rate FxRate
+		err := tx.Where("base_currency = ? AND quote_currency = ? AND effective_from <= ?", base, quote, at).
+			Order("effective_from DESC").First(&rate).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return &rate, err
+	}
+
+	rate, err := lookup(from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	inverted := false
+	if rate == nil {
+		if rate, err = lookup(to, from); err != nil {
+			return nil, nil, err
+		}
+		inverted = true
+	}
+	if rate == nil {
+		return nil, nil, fmt.Errorf("%w: %s/%s at %s", ErrNoFxRate, from, to, at.Format(time.RFC3339))
+	}
+
+	r, ok := new(big.Rat).SetString(rate.Rate)
+	if !ok || r.Sign() <= 0 {
+		return nil, nil, fmt.Errorf("invalid fx rate %d: %q", rate.ID, rate.Rate)
+	}
+	if inverted {
+		// 記録したレートだけで換算を再現できるよう、丸めた逆数で換算する
+		r.Inv(r)
+		r.SetString(r.FloatString(fxRateScale))
+	}
+	return &AppliedFxRate{
+		RateID:        rate.ID,
+		Rate:          r.FloatString(fxRateScale),
+		EffectiveFrom: rate.EffectiveFrom,
+		Inverted:      inverted,
+	}, r, nil
+}
+
+// currencySystemAccount 通貨ごとのシステム口座（JPYはprefixそのまま、それ以外はprefix-USDなど）
+func currencySystemAccount(tx *gorm.DB, prefix, ownerName string, currency Currency) (*Account, error) {
+	if currency == CurrencyJPY {
+		return systemAccount(tx, prefix, ownerName)
+	}
+	return systemAccountIn(tx, prefix+"-"+string(currency), ownerName+" ("+string(currency)+")", currency)
+}
+
+// postFxTransfer 通貨の異なる口座間の振込を記帳する
+//
+// 送金元の通貨で出金し、為替の持ち高口座（SYS-FX-通貨）を経由して送金先の通貨で入金する。
+// 1つの仕訳の中で通貨ごとに貸借が一致する。
+func postFxTransfer(tx *gorm.DB, fromAccount, toAccount *Account, amount int64, at time.Time) (*Transaction, error) {
+	from, to := accountCurrency(fromAccount), accountCurrency(toAccount)
+	applied, rate, err := findFxRate(tx, from, to, at)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := convertMoney(Money{Amount: amount, Currency: from}, to, rate)
+	if err != nil {
+		return nil, err
+	}
+	if converted.Amount <= 0 {
+		return nil, fmt.Errorf("%w: %s", ErrAmountTooSmall, Money{Amount: amount, Currency: from})
+	}
+
+	fromPosition, err := currencySystemAccount(tx, SystemAccountFX, "為替持ち高", from)
+	if err != nil {
+		return nil, err
+	}
+	toPosition, err := currencySystemAccount(tx, SystemAccountFX, "為替持ち高", to)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction := Transaction{
+		FromAccountID:   fromAccount.ID,
+		ToAccountID:     toAccount.ID,
+		Amount:          amount,
+		Currency:        string(from),
+		ToAmount:        converted.Amount,
+		ToCurrency:      string(to),
+		FxRate:          &applied.Rate,
+		FxRateID:        &applied.RateID,
+		Status:          TransactionStatusCompleted,
+		TransactionType: TransactionTypeTransfer,
+	}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return nil, fmt.Errorf("failed to create transaction: %w", err)
+	}
+	if err := enqueueTransferEvents(tx, &transaction); err != nil {
+		return nil, err
+	}
+
+	balances, err := postJournalEntry(tx, &transaction.ID, TransactionTypeTransfer, []Posting{
+		{AccountID: fromAccount.ID, Amount: -amount},
+		{AccountID: fromPosition.ID, Amount: amount},
+		{AccountID: toPosition.ID, Amount: -converted.Amount},
+		{AccountID: toAccount.ID, Amount: converted.Amount},
+	})
+	if err != nil {
+		return nil, err
+	}
+	fromAccount.Balance = balances[fromAccount.ID]
+	toAccount.Balance = balances[toAccount.ID]
+	return &transaction, nil
+}
+
+// isFxRejection 為替レートが無いなど、外貨の振込を受け付けられない場合
+func isFxRejection(err error) bool {
+	return errors.Is(err, ErrNoFxRate) || errors.Is(err, ErrAmountTooSmall) || errors.Is(err, ErrCurrencyMismatch)
+}
+
+// FxRateRequest 為替レートの登録リクエスト
+type FxRateRequest struct {
+	BaseCurrency  string     `json:"base_currency"`
+	QuoteCurrency string     `json:"quote_currency"`
+	Rate          string     `json:"rate"`                     // 例: "151.2345"
+	EffectiveFrom *time.Time `json:"effective_from,omitempty"` // 省略時は現在時刻
+}
+
+// FxRatesHandler 為替レートの一覧（GET ?base=&quote=）と登録（POST、同じ通貨ペア・時刻は上書き）
+func (h *Handler) FxRatesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := h.DB.WithContext(r.Context()).Order("base_currency, quote_currency, effective_from DESC")
+		if base := r.URL.Query().Get("base"); base != "" {
+			query = query.Where("base_currency = ?", base)
+		}
+		if quote := r.URL.Query().Get("quote"); quote != "" {
+			query = query.Where("quote_currency = ?", quote)
+		}
+		rates := []FxRate{}
+		if err := query.Find(&rates).Error; err != nil {
+			http.Error(w, fmt.Sprintf("Failed to fetch fx rates: %v", err), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, rates)
+
+	case http.MethodPost:
+		var req FxRateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		base, err := ParseCurrency(req.BaseCurrency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		quote, err := ParseCurrency(req.QuoteCurrency)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if base == quote {
+			http.Error(w, "base_currency and quote_currency must differ", http.StatusBadRequest)
+			return
+		}
+		rate, ok := new(big.Rat).SetString(req.Rate)
+		if !ok || rate.Sign() <= 0 {
+			http.Error(w, "Invalid rate (positive decimal string)", http.StatusBadRequest)
+			return
+		}
+		effectiveFrom := time.Now()
+		if req.EffectiveFrom != nil {
+			effectiveFrom = *req.EffectiveFrom
+		}
+
+		fx := FxRate{
+			BaseCurrency:  string(base),
+			QuoteCurrency: string(quote),
+			Rate:          rate.FloatString(fxRateScale),
+			EffectiveFrom: effectiveFrom,
+		}
+		if err := h.DB.WithContext(r.Context()).Exec(
+			`INSERT INTO fx_rates (base_currency, quote_currency, rate, effective_from, created_at)
+			VALUES (?, ?, ?, ?, NOW())
+			ON CONFLICT (base_currency, quote_currency, effective_from) DO UPDATE SET rate = EXCLUDED.rate`,
+			fx.BaseCurrency, fx.QuoteCurrency, fx.Rate, fx.EffectiveFrom,
+		).Error; err != nil {
+			http.Error(w, fmt.Sprintf("Failed to save fx rate: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := h.DB.WithContext(r.Context()).
+			Where("base_currency = ? AND quote_currency = ? AND effective_from = ?", fx.BaseCurrency, fx.QuoteCurrency, fx.EffectiveFrom).
+			First(&fx).Error; err != nil {
+			http.Error(w, fmt.Sprintf("Failed to fetch fx rate: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		jsonResponse(w, fx)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// FxQuoteHandler 換算結果の試算（from, to, amount=最小単位, at=RFC3339）
+func (h *Handler) FxQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := ParseCurrency(q.Get("from"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := ParseCurrency(q.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	amount, err := strconv.ParseInt(q.Get("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		http.Error(w, "Invalid amount (positive integer in minor units)", http.StatusBadRequest)
+		return
+	}
+	at := time.Now()
+	if raw := q.Get("at"); raw != "" {
+		if at, err = time.Parse(time.RFC3339, raw); err != nil {
+			http.Error(w, "Invalid at (RFC3339)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	source := Money{Amount: amount, Currency: from}
+	if from == to {
+		jsonResponse(w, map[string]interface{}{"from": source, "to": source})
+		return
+	}
+	applied, rate, err := findFxRate(h.DB.WithContext(r.Context()), from, to, at)
+	if errors.Is(err, ErrNoFxRate) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	converted, err := convertMoney(source, to, rate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{
+		"from":         source,
+		"to":           converted,
+		"from_display": source.String(),
+		"to_display":   converted.String(),
+		"fx_rate":      applied,
+	})
+}
+
+// OpenAccountRequest 口座開設リクエスト
+type OpenAccountRequest struct {
+	AccountNo   string `json:"account_no"`
+	OwnerName   string `json:"owner_name"`
+	AccountType string `json:"account_type"` // 省略時はchecking
+	Currency    string `json:"currency"`     // 省略時はJPY
+	Balance     int64  `json:"balance"`      // 初期残高（最小単位）
+}
+
+// OpenAccountHandler 通貨を指定して口座を開設する
+func (h *Handler) OpenAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req OpenAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	currency, err := ParseCurrency(req.Currency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.AccountType == "" {
+		req.AccountType = AccountTypeChecking
+	}
+	if _, ok := accountRules[req.AccountType]; !ok {
+		http.Error(w, fmt.Sprintf("Invalid account_type %q", req.AccountType), http.StatusBadRequest)
+		return
+	}
+	if req.AccountNo == "" || req.OwnerName == "" || req.Balance < 0 {
+		http.Error(w, "account_no and owner_name are required, balance must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	account := Account{
+		AccountNo:   req.AccountNo,
+		OwnerName:   req.OwnerName,
+		AccountType: req.AccountType,
+		Currency:    string(currency),
+		Balance:     req.Balance,
+	}
+	if err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		return openAccount(tx, &account)
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open account: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonResponse(w, account)
+}
@@ -0,0 +1,123 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func createCurrencyAccount(t *testing.T, db *gorm.DB, currency Currency, balance int64) *Account {
+	t.Helper()
+	account := &Account{
+		AccountNo: fmt.Sprintf("F%d", time.Now().UnixNano()%1e15),
+		Balance:   balance,
+		OwnerName: t.Name(),
+		Currency:  string(currency),
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error { return openAccount(tx, account) }); err != nil {
+		t.Fatalf("failed to open account: %v", err)
+	}
+	return account
+}
+
+func TestPostFxTransfer(t *testing.T) {
+	db := openTestDB(t)
+
+	jan := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	jun := time.Date(2001, 6, 1, 0, 0, 0, 0, time.UTC)
+	db.Where("base_currency IN ? AND quote_currency IN ?", []string{"EUR", "KWD"}, []string{"EUR", "KWD"}).Delete(&FxRate{})
+	db.Create(&FxRate{BaseCurrency: "EUR", QuoteCurrency: "KWD", Rate: "0.3", EffectiveFrom: jan})
+	db.Create(&FxRate{BaseCurrency: "EUR", QuoteCurrency: "KWD", Rate: "0.4", EffectiveFrom: jun})
+
+	eur := createCurrencyAccount(t, db, CurrencyEUR, 100000) // 1000.00 EUR
+	kwd := createCurrencyAccount(t, db, CurrencyKWD, 100000) // 100.000 KWD
+
+	transfer := func(from, to *Account, amount int64, at time.Time) (*Transaction, error) {
+		var transaction *Transaction
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			transaction, err = postFxTransfer(tx, from, to, amount, at)
+			return err
+		})
+		return transaction, err
+	}
+
+	// 3月時点では1月のレートを使う: 12.35 EUR * 0.3 = 3.705 KWD
+	transaction, err := transfer(eur, kwd, 1235, time.Date(2001, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("postFxTransfer() error = %v", err)
+	}
+	if transaction.ToAmount != 3705 || *transaction.FxRate != "0.3000000000" {
+		t.Errorf("to_amount = %d, fx_rate = %s; expected 3705 at 0.3", transaction.ToAmount, *transaction.FxRate)
+	}
+
+	// 7月時点のKWD→EURは6月のEUR/KWDの逆数: 1.001 KWD * 2.5 = 2.5025 EUR -> 2.50
+	transaction, err = transfer(kwd, eur, 1001, time.Date(2001, 7, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("postFxTransfer() inverse error = %v", err)
+	}
+	if transaction.ToAmount != 250 || *transaction.FxRate != "2.5000000000" {
+		t.Errorf("to_amount = %d, fx_rate = %s; expected 250 at 2.5", transaction.ToAmount, *transaction.FxRate)
+	}
+
+	assertLedgerBalance(t, db, eur.ID, 100000-1235+250)
+	assertLedgerBalance(t, db, kwd.ID, 100000+3705-1001)
+
+	// レートが有効になる前の振込は拒否される
+	if _, err := transfer(eur, kwd, 100, time.Date(2000, 12, 31, 0, 0, 0, 0, time.UTC)); !errors.Is(err, ErrNoFxRate) {
+		t.Errorf("transfer before any rate error = %v; expected ErrNoFxRate", err)
+	}
+	assertLedgerBalance(t, db, eur.ID, 100000-1235+250)
+
+	result, err := checkLedger(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.UnbalancedEntries) != 0 {
+		t.Errorf("unbalanced entries: %+v", result.UnbalancedEntries)
+	}
+}
+
+// 逆向きの外貨の振込を同時に実行しても、為替の持ち高口座のロックの順序でデッドロックしないこと
+func TestPostFxTransfer_OppositeDirectionsConcurrently(t *testing.T) {
+	db := openTestDB(t)
+	jan := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Where("base_currency IN ? AND quote_currency IN ?", []string{"EUR", "KWD"}, []string{"EUR", "KWD"}).Delete(&FxRate{})
+	db.Create(&FxRate{BaseCurrency: "EUR", QuoteCurrency: "KWD", Rate: "0.3", EffectiveFrom: jan})
+
+	// 送金元・送金先の組を分けて、口座の行ロックでは直列化されないようにする
+	eurFrom := createCurrencyAccount(t, db, CurrencyEUR, 1000000)
+	kwdTo := createCurrencyAccount(t, db, CurrencyKWD, 0)
+	kwdFrom := createCurrencyAccount(t, db, CurrencyKWD, 1000000)
+	eurTo := createCurrencyAccount(t, db, CurrencyEUR, 0)
+
+	const n = 20
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for _, pair := range [][2]*Account{{eurFrom, kwdTo}, {kwdFrom, eurTo}} {
+		wg.Add(1)
+		go func(from, to *Account) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if _, _, _, err := executeTransferWithLockOrder(ctx, db, from.ID, to.ID, 100); err != nil {
+					errs <- err
+				}
+			}
+		}(pair[0], pair[1])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("transfer failed: %v", err)
+	}
+
+	// 1.00 EUR * 0.3 = 0.300 KWD、0.100 KWD / 0.3 = 0.33 EUR
+	assertLedgerBalance(t, db, kwdTo.ID, n*300)
+	assertLedgerBalance(t, db, eurTo.ID, n*33)
+}
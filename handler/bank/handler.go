@@ -2,52 +2,94 @@ package bank
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/keito-isurugi/go-demo/bulkload"
+	"github.com/keito-isurugi/go-demo/outbox"
 	"gorm.io/gorm"
 )
 
 // Handler は銀行振込APIのハンドラー
 type Handler struct {
 	DB *gorm.DB
+	// Jobs 大量口座の投入ジョブ
+	Jobs *bulkload.Manager
+	// Outbox 口座のイベントの配信（nilの場合はログに出力するだけ）
+	Outbox *outbox.Relay
+	// AuditKey 監査ログのチェックポイントの署名鍵（nilの場合はチェックポイントを作らない）
+	AuditKey ed25519.PrivateKey
+	// AuditTrustedKeys AuditKeyのほかに検証で信頼する公開鍵（入れ替える前の鍵など）
+	AuditTrustedKeys []ed25519.PublicKey
+	// EnableBackendSignals 他のバックエンドのキャンセル・終了（/api/bank/locks/signal）を許可する（既定は無効）
+	EnableBackendSignals bool
+
+	serializableStats retryStats
 }
 
 // InitAccountsHandler テスト用の口座を初期化
 func (h *Handler) InitAccountsHandler(w http.ResponseWriter, r *http.Request) {
-	if err := h.DB.AutoMigrate(&Account{}, &Transaction{}); err != nil {
+	if err := autoMigrate(h.DB); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to migrate: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	h.DB.Exec("TRUNCATE TABLE accounts CASCADE")
-	h.DB.Exec("TRUNCATE TABLE transactions CASCADE")
+	accounts, err := resetAccounts(h.DB)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create account: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"success":  true,
+		"message":  "Accounts initialized successfully",
+		"accounts": accounts,
+	})
+}
+
+// resetAccounts 口座・取引履歴・元帳を空にして、デモ用の3口座を作り直す
+//
+// 監査ログは消さず、取引を消す前にリセットを記録する。
+func resetAccounts(db *gorm.DB) ([]Account, error) {
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := recordAuditReset(tx, "reset demo accounts"); err != nil {
+			return err
+		}
+		// 残高の履歴も追記のみだが、口座を作り直すので消す（トリガーで禁止しているのはUPDATE・DELETEだけ）
+		return tx.Exec(`TRUNCATE TABLE accounts, transactions, idempotency_keys, journal_entries, postings,
+			transfer_reviews, account_balance_history CASCADE`).Error
+	}); err != nil {
+		return nil, fmt.Errorf("failed to reset accounts: %w", err)
+	}
 
 	accounts := []Account{
 		{AccountNo: "1001", Balance: 100000, OwnerName: "山田太郎"},
 		{AccountNo: "1002", Balance: 50000, OwnerName: "佐藤花子"},
-		{AccountNo: "1003", Balance: 200000, OwnerName: "鈴木一郎"},
+		{AccountNo: "1003", Balance: 200000, OwnerName: "鈴木一郎", AccountType: AccountTypeSavings},
 	}
 
-	for _, account := range accounts {
-		if err := h.DB.Create(&account).Error; err != nil {
-			http.Error(w, fmt.Sprintf("Failed to create account: %v", err), http.StatusInternalServerError)
-			return
+	// 初期残高は開始残高として元帳に記帳する
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range accounts {
+			if err := openAccount(tx, &accounts[i]); err != nil {
+				return err
+			}
 		}
-	}
-
-	jsonResponse(w, map[string]interface{}{
-		"success":  true,
-		"message":  "Accounts initialized successfully",
-		"accounts": accounts,
+		return nil
 	})
+	return accounts, err
 }
 
 // NormalTransferHandler 通常の振込処理
+//
+// Idempotency-Keyヘッダーが付いている場合、同じキーの再送には保存済みのレスポンスを返し、
+// 振込を二重に実行しない。同じキーを異なるリクエストで使った場合は422を返す。
 func (h *Handler) NormalTransferHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -59,6 +101,11 @@ func (h *Handler) NormalTransferHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
 		return
 	}
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if err := validateIdempotencyKey(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	tx := h.DB.Begin()
 	defer func() {
@@ -67,57 +114,102 @@ func (h *Handler) NormalTransferHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}()
 
-	var fromAccount Account
-	if err := tx.First(&fromAccount, req.FromAccountID).Error; err != nil {
+	if key != "" {
+		hash, err := requestHash(r, req)
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		stored, err := claimIdempotencyKey(tx, key, hash)
+		if errors.Is(err, ErrIdempotencyKeyReused) {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if errors.Is(err, ErrIdempotencyKeyTooLong) {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if stored != nil {
+			tx.Rollback()
+			replayIdempotentResponse(w, stored)
+			return
+		}
+	}
+
+	// finish レスポンスをキーと一緒に保存してコミット（結果が確定したものだけ保存し、404や500は再送できるようにする）
+	finish := func(status int, resp TransferResponse) {
+		if key != "" {
+			if err := saveIdempotentResponse(tx, key, status, resp); err != nil {
+				tx.Rollback()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit().Error; err != nil {
+			http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+		}
+		jsonResponse(w, resp)
+	}
+
+	// 同じ口座からの同時振込が残高や限度額の判定をすり抜けないよう、判定の前に両方の口座をID順にロックする
+	fromAccount, toAccount, err := lockTransferAccounts(tx, req.FromAccountID, req.ToAccountID)
+	if errors.Is(err, ErrAccountNotFound) {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		tx.Rollback()
-		http.Error(w, fmt.Sprintf("From account not found: %v", err), http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if fromAccount.Balance < req.Amount {
-		tx.Rollback()
-		jsonResponse(w, TransferResponse{
+		finish(http.StatusOK, TransferResponse{
 			Success: false,
 			Message: "Insufficient balance",
 		})
 		return
 	}
 
-	var toAccount Account
-	if err := tx.First(&toAccount, req.ToAccountID).Error; err != nil {
-		tx.Rollback()
-		http.Error(w, fmt.Sprintf("To account not found: %v", err), http.StatusNotFound)
-		return
-	}
-
-	fromAccount.Balance -= req.Amount
-	toAccount.Balance += req.Amount
-
-	if err := tx.Save(&fromAccount).Error; err != nil {
+	// 振込ルール（拒否・審査待ちも確定した結果としてキーに保存する）
+	if err := enforceTransferRules(tx, fromAccount, toAccount, req.Amount); err != nil {
+		if status, resp, ok := transferRuleResponse(err); ok {
+			finish(status, resp)
+			return
+		}
 		tx.Rollback()
-		http.Error(w, fmt.Sprintf("Failed to update from account: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := tx.Save(&toAccount).Error; err != nil {
+	// 取引履歴と仕訳を記帳し、残高を更新
+	transaction, err := postTransfer(tx, fromAccount, toAccount, req.Amount)
+	if isFxRejection(err) {
 		tx.Rollback()
-		http.Error(w, fmt.Sprintf("Failed to update to account: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
-
-	transaction, err := createTransaction(tx, req.FromAccountID, req.ToAccountID, req.Amount)
 	if err != nil {
 		tx.Rollback()
-		http.Error(w, fmt.Sprintf("Failed to create transaction: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		http.Error(w, fmt.Sprintf("Failed to commit transaction: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to post transfer: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	jsonResponse(w, TransferResponse{
+	finish(http.StatusOK, TransferResponse{
 		Success:       true,
 		Message:       "Transfer completed successfully",
 		TransactionID: transaction.ID,
@@ -152,7 +244,7 @@ func (h *Handler) GetAccountHandler(w http.ResponseWriter, r *http.Request) {
 // ListAccountsHandler 全口座一覧を取得
 func (h *Handler) ListAccountsHandler(w http.ResponseWriter, r *http.Request) {
 	var accounts []Account
-	if err := h.DB.Find(&accounts).Error; err != nil {
+	if err := h.DB.Where("is_system = ?", false).Find(&accounts).Error; err != nil {
 		http.Error(w, fmt.Sprintf("Failed to fetch accounts: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -178,6 +270,13 @@ func (h *Handler) DeadlockAvoidanceHandler(w http.ResponseWriter, r *http.Reques
 		ctx, h.DB, req.FromAccountID, req.ToAccountID, req.Amount,
 	)
 
+	if writeTransferRuleError(w, err) {
+		return
+	}
+	if isFxRejection(err) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -212,8 +311,10 @@ func (h *Handler) DeadlockTimeoutHandler(w http.ResponseWriter, r *http.Request)
 	var fromAccount, toAccount *Account
 	var transaction *Transaction
 	var lastErr error
+	var attempts int
 
 	err := retryWithBackoff(maxRetries, initialDelay, func(attempt int) error {
+		attempts = attempt + 1
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
@@ -222,6 +323,12 @@ func (h *Handler) DeadlockTimeoutHandler(w http.ResponseWriter, r *http.Request)
 			ctx, h.DB, req.FromAccountID, req.ToAccountID, req.Amount,
 		)
 
+		// 振込ルールの判定はリトライしても変わらない（審査待ちを二重に作らない）
+		var ruleErr *TransferRuleError
+		if errors.As(err, &ruleErr) {
+			lastErr = err
+			return nil
+		}
 		if err != nil {
 			if ctx.Err() == context.DeadlineExceeded {
 				log.Printf("Transaction timeout, retrying... (attempt %d/%d)", attempt+1, maxRetries)
@@ -235,8 +342,11 @@ func (h *Handler) DeadlockTimeoutHandler(w http.ResponseWriter, r *http.Request)
 		return nil
 	})
 
+	if writeTransferRuleError(w, lastErr) {
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Transaction failed: %v", lastErr), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Transaction failed after %d attempts: %v", attempts, lastErr), http.StatusInternalServerError)
 		return
 	}
 
@@ -246,5 +356,6 @@ func (h *Handler) DeadlockTimeoutHandler(w http.ResponseWriter, r *http.Request)
 		TransactionID: transaction.ID,
 		FromBalance:   fromAccount.Balance,
 		ToBalance:     toAccount.Balance,
+		Attempts:      attempts,
 	})
 }
@@ -3,6 +3,7 @@ package bank
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -38,12 +39,18 @@ func transferFunds(fromAccount, toAccount *Account, amount int64) error {
 	return nil
 }
 
-// createTransaction 取引履歴を作成
-func createTransaction(tx *gorm.DB, fromAccountID, toAccountID uint, amount int64) (*Transaction, error) {
+// createTransaction 同じ通貨の口座間の取引履歴を作成
+func createTransaction(tx *gorm.DB, fromAccount, toAccount *Account, amount int64) (*Transaction, error) {
+	if accountCurrency(fromAccount) != accountCurrency(toAccount) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrCurrencyMismatch, accountCurrency(fromAccount), accountCurrency(toAccount))
+	}
 	transaction := Transaction{
-		FromAccountID:   fromAccountID,
-		ToAccountID:     toAccountID,
+		FromAccountID:   fromAccount.ID,
+		ToAccountID:     toAccount.ID,
 		Amount:          amount,
+		Currency:        string(accountCurrency(fromAccount)),
+		ToAmount:        amount,
+		ToCurrency:      string(accountCurrency(toAccount)),
 		Status:          TransactionStatusCompleted,
 		TransactionType: TransactionTypeTransfer,
 	}
@@ -51,6 +58,10 @@ func createTransaction(tx *gorm.DB, fromAccountID, toAccountID uint, amount int6
 	if err := tx.Create(&transaction).Error; err != nil {
 		return nil, fmt.Errorf("failed to create transaction: %w", err)
 	}
+	// コミットされた振込だけが下流に配信されるよう、同じトランザクションでoutboxに書き込む
+	if err := enqueueTransferEvents(tx, &transaction); err != nil {
+		return nil, err
+	}
 
 	return &transaction, nil
 }
@@ -62,9 +73,6 @@ func executeTransferWithLockOrder(
 	fromAccountID, toAccountID uint,
 	amount int64,
 ) (*Account, *Account, *Transaction, error) {
-	// ロック順序の統一
-	firstID, secondID := getAccountIDsInOrder(fromAccountID, toAccountID)
-
 	tx := db.WithContext(ctx).Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -72,60 +80,79 @@ func executeTransferWithLockOrder(
 		}
 	}()
 
-	// 1つ目の口座をロック
-	firstAccount, err := lockAccount(tx, firstID)
+	fromAccount, toAccount, transaction, err := transferWithLockOrder(tx, fromAccountID, toAccountID, amount)
+	if errors.Is(err, ErrTransferHeld) {
+		// 審査待ちの振込を残すためにコミットする
+		if cerr := tx.Commit().Error; cerr != nil {
+			return nil, nil, nil, fmt.Errorf("failed to commit transaction: %w", cerr)
+		}
+		return nil, nil, nil, err
+	}
 	if err != nil {
 		tx.Rollback()
 		return nil, nil, nil, err
 	}
 
-	// 2つ目の口座をロック
-	secondAccount, err := lockAccount(tx, secondID)
+	// コミット
+	if err := tx.Commit().Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return fromAccount, toAccount, transaction, nil
+}
+
+// transferWithLockOrder 呼び出し元のトランザクション内で、ロック順序を統一して振り込む
+//
+// 振込ルールで拒否・審査待ちになった場合は*TransferRuleErrorを返す。
+func transferWithLockOrder(tx *gorm.DB, fromAccountID, toAccountID uint, amount int64) (*Account, *Account, *Transaction, error) {
+	fromAccount, toAccount, err := lockTransferAccounts(tx, fromAccountID, toAccountID)
 	if err != nil {
-		tx.Rollback()
 		return nil, nil, nil, err
 	}
 
-	// 送金元・送金先を特定
-	var fromAccount, toAccount *Account
-	if fromAccountID == firstID {
-		fromAccount = firstAccount
-		toAccount = secondAccount
-	} else {
-		fromAccount = secondAccount
-		toAccount = firstAccount
+	// 振込ルール（限度額・不正検知）
+	if err := enforceTransferRules(tx, fromAccount, toAccount, amount); err != nil {
+		return nil, nil, nil, err
 	}
 
 	// 残高チェックと更新
 	if err := transferFunds(fromAccount, toAccount, amount); err != nil {
-		tx.Rollback()
 		return nil, nil, nil, err
 	}
 
-	// 残高を保存
-	if err := tx.Save(fromAccount).Error; err != nil {
-		tx.Rollback()
-		return nil, nil, nil, fmt.Errorf("failed to update from account: %w", err)
+	// 取引履歴と仕訳を記帳し、残高を更新
+	transaction, err := postTransfer(tx, fromAccount, toAccount, amount)
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	return fromAccount, toAccount, transaction, nil
+}
 
-	if err := tx.Save(toAccount).Error; err != nil {
-		tx.Rollback()
-		return nil, nil, nil, fmt.Errorf("failed to update to account: %w", err)
-	}
+// lockTransferAccounts 送金元・送金先の口座をID順にロックする
+func lockTransferAccounts(tx *gorm.DB, fromAccountID, toAccountID uint) (*Account, *Account, error) {
+	// ロック順序の統一
+	firstID, secondID := getAccountIDsInOrder(fromAccountID, toAccountID)
 
-	// 取引履歴を作成
-	transaction, err := createTransaction(tx, fromAccountID, toAccountID, amount)
+	// 1つ目の口座をロック
+	firstAccount, err := lockAccount(tx, firstID)
 	if err != nil {
-		tx.Rollback()
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
-	// コミット
-	if err := tx.Commit().Error; err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	// 2つ目の口座をロック
+	secondAccount, err := lockAccount(tx, secondID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if firstAccount.ID == 0 || secondAccount.ID == 0 {
+		return nil, nil, ErrAccountNotFound
 	}
 
-	return fromAccount, toAccount, transaction, nil
+	// 送金元・送金先を特定
+	if fromAccountID == firstID {
+		return firstAccount, secondAccount, nil
+	}
+	return secondAccount, firstAccount, nil
 }
 
 // retryWithBackoff 指数バックオフでリトライ
@@ -0,0 +1,150 @@
+package bank
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdempotencyKeyHeader クライアントが再送時に同じ値を付けるヘッダー
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// IdempotencyKeyRetention キーを保持する期間（過ぎたキーは新しいリクエストとして扱う）
+	IdempotencyKeyRetention = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyKey 処理済みリクエストの結果
+//
+// 振込と同じトランザクションで保存するため、「振込は成功したがキーは保存されていない」状態にならない。
+type IdempotencyKey struct {
+	Key          string    `gorm:"primaryKey;size:255"`
+	RequestHash  string    `gorm:"size:64;not null"` // メソッド・パス・正規化したボディのSHA-256
+	StatusCode   int       `gorm:"not null;default:0"`
+	ResponseBody string    `gorm:"type:text"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	ExpiresAt    time.Time `gorm:"index;not null"`
+}
+
+var (
+	// ErrIdempotencyKeyReused 同じキーが異なるリクエストで使われた
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+	// ErrIdempotencyKeyTooLong キーが長すぎる（クライアントの誤り）
+	ErrIdempotencyKeyTooLong = fmt.Errorf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)
+)
+
+// validateIdempotencyKey トランザクションを始める前にキーの形式を確認する
+func validateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return ErrIdempotencyKeyTooLong
+	}
+	return nil
+}
+
+// requestHash リクエストを識別するハッシュ（ボディはデコード後に再エンコードして空白などの差を無視する）
+func requestHash(r *http.Request, body interface{}) (string, error) {
+	canonical, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(canonical)))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// claimIdempotencyKey トランザクション内でキーを確保する
+//
+// 処理済みのキーであれば保存済みの結果を返す。同じキーのリクエストが処理中の場合、
+// INSERTが一意制約の待ちになるため、先のトランザクションが終わるまで待ってから判定される。
+func claimIdempotencyKey(tx *gorm.DB, key, hash string) (*IdempotencyKey, error) {
+	if err := validateIdempotencyKey(key); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	// 保持期間を過ぎたキーは再利用できる
+	if err := tx.Exec("DELETE FROM idempotency_keys WHERE key = ? AND expires_at < ?", key, now).Error; err != nil {
+		return nil, fmt.Errorf("failed to expire idempotency key: %w", err)
+	}
+
+	result := tx.Exec(
+		"INSERT INTO idempotency_keys (key, request_hash, created_at, expires_at) VALUES (?, ?, ?, ?) ON CONFLICT (key) DO NOTHING",
+		key, hash, now, now.Add(IdempotencyKeyRetention),
+	)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	var stored IdempotencyKey
+	if err := tx.Where("key = ?", key).First(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+	if stored.RequestHash != hash {
+		return &stored, ErrIdempotencyKeyReused
+	}
+	return &stored, nil
+}
+
+// saveIdempotentResponse 確保したキーにレスポンスを保存（コミット前に呼ぶ）
+func saveIdempotentResponse(tx *gorm.DB, key string, statusCode int, response interface{}) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&IdempotencyKey{}).Where("key = ?", key).Updates(map[string]interface{}{
+		"status_code":   statusCode,
+		"response_body": string(body),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// replayIdempotentResponse 保存済みのレスポンスをそのまま返す
+func replayIdempotentResponse(w http.ResponseWriter, stored *IdempotencyKey) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.StatusCode)
+	if _, err := w.Write([]byte(stored.ResponseBody)); err != nil {
+		return
+	}
+}
+
+// PurgeExpiredIdempotencyKeys 保持期間を過ぎたキーを削除し、削除件数を返す
+func PurgeExpiredIdempotencyKeys(ctx context.Context, db *gorm.DB) (int64, error) {
+	result := db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
+
+// RunIdempotencyKeyCleanup intervalごとに期限切れのキーを削除する（ctxが終了するまで）
+func (h *Handler) RunIdempotencyKeyCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := PurgeExpiredIdempotencyKeys(ctx, h.DB)
+			if err != nil {
+				// テーブル作成前（/api/bank/init前）は失敗するので次回に回す
+				log.Printf("idempotency key cleanup failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("purged %d expired idempotency keys", n)
+			}
+		}
+	}
+}
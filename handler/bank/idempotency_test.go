@@ -0,0 +1,108 @@
+package bank
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 長すぎるキーはクライアントの誤りとして400を返し、トランザクションを始めないこと
+func TestNormalTransferHandler_IdempotencyKeyTooLong(t *testing.T) {
+	if err := validateIdempotencyKey(strings.Repeat("k", maxIdempotencyKeyLength)); err != nil {
+		t.Fatalf("key of max length: %v", err)
+	}
+	key := strings.Repeat("k", maxIdempotencyKeyLength+1)
+	if _, err := claimIdempotencyKey(nil, key, ""); !errors.Is(err, ErrIdempotencyKeyTooLong) {
+		t.Fatalf("err = %v; expected ErrIdempotencyKeyTooLong", err)
+	}
+
+	// DBを設定していないので、トランザクションを始めるとpanicする
+	h := &Handler{}
+	req := httptest.NewRequest(http.MethodPost, "/api/bank/transfer", strings.NewReader(`{"from_account_id":1,"to_account_id":2,"amount":100}`))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	h.NormalTransferHandler(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d; expected 400", w.Code)
+	}
+}
+
+// idempotentTransfer Idempotency-Key付きで /api/bank/transfer を呼ぶ
+func idempotentTransfer(h *Handler, key string, from, to uint, amount int64) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"from_account_id":%d,"to_account_id":%d,"amount":%d}`, from, to, amount)
+	req := httptest.NewRequest(http.MethodPost, "/api/bank/transfer", strings.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	w := httptest.NewRecorder()
+	h.NormalTransferHandler(w, req)
+	return w
+}
+
+// 同じキー・同じ内容の再送は保存済みのレスポンスを返し、振込を二重に実行しないこと
+func TestNormalTransferHandler_IdempotentReplay(t *testing.T) {
+	db := openTestDB(t)
+	from := createTestAccount(t, db, AccountTypeChecking, 10000)
+	to := createTestAccount(t, db, AccountTypeChecking, 0)
+	h := &Handler{DB: db}
+	key := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+
+	first := idempotentTransfer(h, key, from.ID, to.ID, 3000)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first status = %d: %s", first.Code, first.Body.String())
+	}
+	second := idempotentTransfer(h, key, from.ID, to.ID, 3000)
+	if second.Code != http.StatusOK {
+		t.Fatalf("second status = %d: %s", second.Code, second.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header on the retried request")
+	}
+	if strings.TrimSpace(second.Body.String()) != strings.TrimSpace(first.Body.String()) {
+		t.Errorf("replayed body = %s; expected %s", second.Body.String(), first.Body.String())
+	}
+
+	var transfers int64
+	if err := db.Model(&Transaction{}).Where("from_account_id = ?", from.ID).Count(&transfers).Error; err != nil {
+		t.Fatal(err)
+	}
+	if transfers != 1 {
+		t.Errorf("transactions = %d; expected 1", transfers)
+	}
+	assertLedgerBalance(t, db, from.ID, 7000)
+	assertLedgerBalance(t, db, to.ID, 3000)
+}
+
+// 同じキーを異なる内容で使った場合は422を返し、2件目の振込を実行しないこと
+func TestNormalTransferHandler_IdempotencyKeyReused(t *testing.T) {
+	db := openTestDB(t)
+	from := createTestAccount(t, db, AccountTypeChecking, 10000)
+	to := createTestAccount(t, db, AccountTypeChecking, 0)
+	h := &Handler{DB: db}
+	key := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+
+	if w := idempotentTransfer(h, key, from.ID, to.ID, 3000); w.Code != http.StatusOK {
+		t.Fatalf("first status = %d: %s", w.Code, w.Body.String())
+	}
+	if w := idempotentTransfer(h, key, from.ID, to.ID, 5000); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d; expected 422: %s", w.Code, w.Body.String())
+	}
+
+	// claimIdempotencyKeyも保存済みのキーを返しつつErrIdempotencyKeyReusedを返す
+	err := db.Transaction(func(tx *gorm.DB) error {
+		stored, err := claimIdempotencyKey(tx, key, "other")
+		if stored == nil || stored.StatusCode != http.StatusOK {
+			t.Errorf("stored = %+v; expected the saved response", stored)
+		}
+		return err
+	})
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("err = %v; expected ErrIdempotencyKeyReused", err)
+	}
+	assertLedgerBalance(t, db, from.ID, 7000)
+	assertLedgerBalance(t, db, to.ID, 3000)
+}
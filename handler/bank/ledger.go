@@ -0,0 +1,359 @@
+package bank
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 複式簿記の元帳
+//
+// 残高の変更はすべて仕訳（JournalEntry）と、合計が0になる記帳（Posting）で記録する。
+// Posting.Amountは口座残高の増減（入金側が正、出金側が負）で、口座の残高は
+// その口座のPostingの合計と一致する。Account.Balanceは参照を速くするための
+// 実体化した残高で、Postingと同じトランザクションで更新する。
+
+// JournalEntry 仕訳（1回の取引）
+type JournalEntry struct {
+	ID            uint       `gorm:"primaryKey"`
+	TransactionID *uint      `gorm:"index"`                   // 対応する取引履歴（開始残高などはnil）
+	Description   string     `gorm:"size:200;not null"`       // 摘要
+	ValidAt       *time.Time `gorm:"index"`                   // 残高に反映する有効日時（過去に遡る訂正仕訳のみ。nilなら記帳日時）
+	CreatedAt     time.Time  `gorm:"autoCreateTime;not null"` // 記帳日時
+}
+
+// Posting 仕訳の明細（1口座の増減）
+type Posting struct {
+	ID             uint      `gorm:"primaryKey"`
+	JournalEntryID uint      `gorm:"index;not null"`
+	AccountID      uint      `gorm:"index;not null"`
+	Amount         int64     `gorm:"not null"` // 残高の増減（単位: 円）
+	CreatedAt      time.Time `gorm:"autoCreateTime;not null"`
+}
+
+// システム口座（顧客の口座の相手勘定）
+const (
+	SystemAccountOpening    = "SYS-OPENING"    // 開始残高
+	SystemAccountCash       = "SYS-CASH"       // 現金（入金・出金の相手勘定）
+	SystemAccountCorrection = "SYS-CORRECTION" // 訂正仕訳の相手勘定
+)
+
+var (
+	// ErrUnbalancedEntry 仕訳の合計が0になっていない
+	ErrUnbalancedEntry = errors.New("journal entry is not balanced")
+)
+
+// recordJournalEntry 仕訳とPostingだけを作成する（残高の更新は呼び出し側で行う）
+func recordJournalEntry(tx *gorm.DB, transactionID *uint, description string, postings []Posting) error {
+	return recordEntry(tx, &JournalEntry{TransactionID: transactionID, Description: description}, postings)
+}
+
+// recordEntry 作成する仕訳を指定してrecordJournalEntryと同じことを行う
+func recordEntry(tx *gorm.DB, entry *JournalEntry, postings []Posting) error {
+	if len(postings) < 2 {
+		return fmt.Errorf("%w: at least 2 postings are required", ErrUnbalancedEntry)
+	}
+	var sum int64
+	for _, p := range postings {
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("%w: sum is %d", ErrUnbalancedEntry, sum)
+	}
+
+	if err := tx.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to create journal entry: %w", err)
+	}
+	for i := range postings {
+		postings[i].JournalEntryID = entry.ID
+	}
+	if err := tx.Create(&postings).Error; err != nil {
+		return fmt.Errorf("failed to create postings: %w", err)
+	}
+	return nil
+}
+
+// postJournalEntry 仕訳を記帳し、口座の実体化した残高も同じトランザクションで更新する
+//
+// 戻り値は記帳後の各口座の残高。口座の行ロックは呼び出し側の責任（増分で更新するため、
+// ロックしていなくても更新が失われることはない）。楽観ロック用にversionも進める。
+// 呼び出し側がロックしていない口座（為替の持ち高口座など）は、UPDATEで口座IDの小さい順にロックする。
+func postJournalEntry(tx *gorm.DB, transactionID *uint, description string, postings []Posting) (map[uint]int64, error) {
+	return postEntry(tx, &JournalEntry{TransactionID: transactionID, Description: description}, postings)
+}
+
+// postEntry 作成する仕訳を指定してpostJournalEntryと同じことを行う
+func postEntry(tx *gorm.DB, entry *JournalEntry, postings []Posting) (map[uint]int64, error) {
+	if err := recordEntry(tx, entry, postings); err != nil {
+		return nil, err
+	}
+
+	// 逆向きの振込（JPY→USDとUSD→JPYなど）がシステム口座を逆の順にロックしてデッドロックしないよう、
+	// Postingの順ではなく口座IDの順に更新する
+	ordered := slices.Clone(postings)
+	slices.SortStableFunc(ordered, func(a, b Posting) int { return cmp.Compare(a.AccountID, b.AccountID) })
+
+	balances := make(map[uint]int64, len(postings))
+	for _, p := range ordered {
+		var balance int64
+		if err := tx.Raw(
+			"UPDATE accounts SET balance = balance + ?, version = version + 1, updated_at = ? WHERE id = ? RETURNING balance",
+			p.Amount, time.Now(), p.AccountID,
+		).Scan(&balance).Error; err != nil {
+			return nil, fmt.Errorf("failed to update balance of account %d: %w", p.AccountID, err)
+		}
+		balances[p.AccountID] = balance
+	}
+	return balances, nil
+}
+
+// postTransfer 振込の取引履歴と仕訳を作成し、口座の残高を記帳後の値に更新する
+//
+// 通貨の異なる口座間では、振込時点で有効な為替レートで換算する（postFxTransfer）。
+func postTransfer(tx *gorm.DB, fromAccount, toAccount *Account, amount int64) (*Transaction, error) {
+	if accountCurrency(fromAccount) != accountCurrency(toAccount) {
+		return postFxTransfer(tx, fromAccount, toAccount, amount, time.Now())
+	}
+	transaction, err := createTransaction(tx, fromAccount, toAccount, amount)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := postJournalEntry(tx, &transaction.ID, TransactionTypeTransfer, []Posting{
+		{AccountID: fromAccount.ID, Amount: -amount},
+		{AccountID: toAccount.ID, Amount: amount},
+	})
+	if err != nil {
+		return nil, err
+	}
+	fromAccount.Balance = balances[fromAccount.ID]
+	toAccount.Balance = balances[toAccount.ID]
+	return transaction, nil
+}
+
+// systemAccount JPYのシステム口座を取得（無ければ作成。同時に呼ばれても1つだけ作られる）
+func systemAccount(tx *gorm.DB, accountNo, ownerName string) (*Account, error) {
+	return systemAccountIn(tx, accountNo, ownerName, CurrencyJPY)
+}
+
+// systemAccountIn 通貨を指定してシステム口座を取得（無ければ作成）
+func systemAccountIn(tx *gorm.DB, accountNo, ownerName string, currency Currency) (*Account, error) {
+	now := time.Now()
+	if err := tx.Exec(
+		`INSERT INTO accounts (account_no, balance, owner_name, account_type, currency, is_system, created_at, updated_at)
+		VALUES (?, 0, ?, ?, ?, true, ?, ?) ON CONFLICT (account_no) DO NOTHING`,
+		accountNo, ownerName, AccountTypeSystem, currency, now, now,
+	).Error; err != nil {
+		return nil, fmt.Errorf("failed to create system account %s: %w", accountNo, err)
+	}
+	var account Account
+	if err := tx.Where("account_no = ?", accountNo).First(&account).Error; err != nil {
+		return nil, fmt.Errorf("failed to get system account %s: %w", accountNo, err)
+	}
+	return &account, nil
+}
+
+// openAccount 口座を作成し、初期残高を開始残高として記帳する
+func openAccount(tx *gorm.DB, account *Account) error {
+	initial := account.Balance
+	account.Balance = 0
+	if err := tx.Create(account).Error; err != nil {
+		return fmt.Errorf("failed to create account: %w", err)
+	}
+	if initial == 0 {
+		return nil
+	}
+
+	opening, err := currencySystemAccount(tx, SystemAccountOpening, "開始残高", accountCurrency(account))
+	if err != nil {
+		return err
+	}
+	balances, err := postJournalEntry(tx, nil, "opening balance", []Posting{
+		{AccountID: account.ID, Amount: initial},
+		{AccountID: opening.ID, Amount: -initial},
+	})
+	if err != nil {
+		return err
+	}
+	account.Balance = balances[account.ID]
+	return enqueueOpeningBalanceEvents(tx, account, opening, initial)
+}
+
+// migrateOpeningBalances 記帳のない口座の残高を開始残高として1つの仕訳にまとめて記帳する
+//
+// 元帳の導入前から存在する口座や、COPYで投入した口座を元帳に載せるための移行処理。
+// 記帳済みの口座は対象外なので何度実行してもよい。記帳した口座数を返す。
+// 外貨口座は開設時に必ず記帳されるため、対象は円口座のみ。
+// 対象の口座の残高は変わらないので、イベントは残高が変わる開始残高の口座の分だけ書き込む。
+func migrateOpeningBalances(tx *gorm.DB) (int64, error) {
+	opening, err := systemAccount(tx, SystemAccountOpening, "開始残高")
+	if err != nil {
+		return 0, err
+	}
+	// 移行中に残高が変わらないよう、対象の口座をロック
+	var targets []struct {
+		ID      uint
+		Balance int64
+	}
+	if err := tx.Raw(`SELECT id, balance FROM accounts a
+		WHERE NOT a.is_system AND a.balance <> 0 AND a.currency = ?
+		AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.account_id = a.id)
+		FOR UPDATE`, CurrencyJPY).Scan(&targets).Error; err != nil {
+		return 0, fmt.Errorf("failed to lock accounts: %w", err)
+	}
+	if len(targets) == 0 {
+		return 0, nil
+	}
+
+	var total int64
+	for _, t := range targets {
+		total += t.Balance
+	}
+
+	entry := JournalEntry{Description: "opening balances (migration)"}
+	if err := tx.Create(&entry).Error; err != nil {
+		return 0, fmt.Errorf("failed to create journal entry: %w", err)
+	}
+	// 口座数が多くても1文で記帳する（残高はすでに実体化されているので更新しない）
+	if err := tx.Exec(`INSERT INTO postings (journal_entry_id, account_id, amount, created_at)
+		SELECT ?, a.id, a.balance, ? FROM accounts a
+		WHERE NOT a.is_system AND a.balance <> 0 AND a.currency = ?
+		AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.account_id = a.id)`,
+		entry.ID, entry.CreatedAt, CurrencyJPY).Error; err != nil {
+		return 0, fmt.Errorf("failed to create postings: %w", err)
+	}
+	if err := tx.Create(&Posting{JournalEntryID: entry.ID, AccountID: opening.ID, Amount: -total}).Error; err != nil {
+		return 0, fmt.Errorf("failed to create posting: %w", err)
+	}
+	if err := tx.Exec("UPDATE accounts SET balance = balance - ?, version = version + 1, updated_at = ? WHERE id = ?", total, time.Now(), opening.ID).Error; err != nil {
+		return 0, fmt.Errorf("failed to update opening account: %w", err)
+	}
+	if err := enqueueAccountEvent(tx, EventAccountDebited, AccountEvent{
+		AccountID:       opening.ID,
+		Amount:          total,
+		Currency:        string(CurrencyJPY),
+		TransactionType: TransactionTypeOpening,
+	}); err != nil {
+		return 0, err
+	}
+	return int64(len(targets)), nil
+}
+
+// LedgerCheckResult 元帳の整合性チェックの結果
+type LedgerCheckResult struct {
+	OK                 bool              `json:"ok"`
+	PostingsSum        int64             `json:"postings_sum"`        // 全Postingの合計（0であるべき）
+	JournalEntries     int64             `json:"journal_entries"`     // 仕訳数
+	UnbalancedEntries  []UnbalancedEntry `json:"unbalanced_entries"`  // 通貨ごとの合計が0でない仕訳
+	MismatchedAccounts []BalanceMismatch `json:"mismatched_accounts"` // 実体化した残高とPostingの合計が異なる口座
+}
+
+// UnbalancedEntry 合計が0でない仕訳
+type UnbalancedEntry struct {
+	JournalEntryID uint   `json:"journal_entry_id"`
+	Currency       string `json:"currency"`
+	Sum            int64  `json:"sum"`
+}
+
+// BalanceMismatch 残高の不一致
+type BalanceMismatch struct {
+	AccountID       uint   `json:"account_id"`
+	AccountNo       string `json:"account_no"`
+	Balance         int64  `json:"balance"`          // accounts.balance
+	PostingsBalance int64  `json:"postings_balance"` // Postingから導出した残高
+}
+
+// mismatchLimit 不一致として返す最大件数
+const mismatchLimit = 100
+
+// checkLedger 元帳の不変条件を検証する
+//
+//   - 全Postingの合計が0
+//   - 仕訳ごとのPostingの合計が0
+//   - 口座ごとのPostingの合計がaccounts.balanceと一致
+func checkLedger(tx *gorm.DB) (*LedgerCheckResult, error) {
+	result := &LedgerCheckResult{
+		UnbalancedEntries:  []UnbalancedEntry{},
+		MismatchedAccounts: []BalanceMismatch{},
+	}
+
+	if err := tx.Raw("SELECT COALESCE(SUM(amount), 0) FROM postings").Scan(&result.PostingsSum).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&JournalEntry{}).Count(&result.JournalEntries).Error; err != nil {
+		return nil, err
+	}
+	// 外貨の振込は1つの仕訳に複数の通貨を含むので、通貨ごとに貸借が一致することを確認する
+	if err := tx.Raw(`SELECT p.journal_entry_id, a.currency, SUM(p.amount) AS sum
+		FROM postings p JOIN accounts a ON a.id = p.account_id
+		GROUP BY p.journal_entry_id, a.currency HAVING SUM(p.amount) <> 0
+		ORDER BY p.journal_entry_id LIMIT ?`, mismatchLimit).Scan(&result.UnbalancedEntries).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Raw(`SELECT a.id AS account_id, a.account_no, a.balance, COALESCE(p.sum, 0) AS postings_balance
+		FROM accounts a
+		LEFT JOIN (SELECT account_id, SUM(amount) AS sum FROM postings GROUP BY account_id) p ON p.account_id = a.id
+		WHERE a.balance <> COALESCE(p.sum, 0)
+		ORDER BY a.id LIMIT ?`, mismatchLimit).Scan(&result.MismatchedAccounts).Error; err != nil {
+		return nil, err
+	}
+
+	result.OK = result.PostingsSum == 0 && len(result.UnbalancedEntries) == 0 && len(result.MismatchedAccounts) == 0
+	return result, nil
+}
+
+// LedgerCheckHandler 元帳の整合性をチェック（不整合があれば500を返す）
+func (h *Handler) LedgerCheckHandler(w http.ResponseWriter, r *http.Request) {
+	var result *LedgerCheckResult
+	// 集計中に記帳が進んでも矛盾しないよう、1つのスナップショットで読む
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY").Error; err != nil {
+			return err
+		}
+		var err error
+		result, err = checkLedger(tx)
+		return err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to check ledger: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !result.OK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	jsonResponse(w, result)
+}
+
+// LedgerMigrateHandler 記帳のない既存口座の残高を開始残高として元帳に載せる
+func (h *Handler) LedgerMigrateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := autoMigrate(h.DB); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to migrate: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var migrated int64
+	err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var err error
+		migrated, err = migrateOpeningBalances(tx)
+		return err
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to migrate ledger: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"success":           true,
+		"migrated_accounts": migrated,
+	})
+}
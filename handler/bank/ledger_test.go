@@ -0,0 +1,24 @@
+package bank
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPostJournalEntry_RejectsUnbalanced(t *testing.T) {
+	tests := []struct {
+		name     string
+		postings []Posting
+	}{
+		{"single posting", []Posting{{AccountID: 1, Amount: 100}}},
+		{"sum is not zero", []Posting{{AccountID: 1, Amount: -100}, {AccountID: 2, Amount: 90}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 検証はDBに触れる前に行われる
+			if _, err := postJournalEntry(nil, nil, "test", tt.postings); !errors.Is(err, ErrUnbalancedEntry) {
+				t.Errorf("postJournalEntry() error = %v; expected ErrUnbalancedEntry", err)
+			}
+		})
+	}
+}
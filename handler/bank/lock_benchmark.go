@@ -0,0 +1,170 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/benchmark"
+	"gorm.io/gorm"
+)
+
+const (
+	maxLockBenchmarkConcurrency = 50 // PostgreSQLのmax_connectionsを超えないように
+	maxLockBenchmarkDuration    = 60 * time.Second
+	maxLockBenchmarkAccounts    = 1000
+	// benchmarkAccountBalance ベンチマーク用口座の初期残高（残高不足にならない額）
+	benchmarkAccountBalance = 1_000_000_000
+)
+
+// LockStrategyReport ロック戦略ごとの計測結果
+type LockStrategyReport struct {
+	benchmark.Report
+	Accounts      int     `json:"accounts"`              // 振込に使う口座数（少ないほど競合する）
+	Retries       int64   `json:"retries"`               // 競合によるやり直しの回数
+	RetriesPerReq float64 `json:"retries_per_request"`   // 1振込あたりのやり直し回数
+	GaveUp        int64   `json:"gave_up_after_retries"` // リトライ上限に達した振込
+}
+
+// ensureBenchmarkAccounts ベンチマーク用の口座（BENCH-0001〜）をn個用意してIDを返す
+func ensureBenchmarkAccounts(ctx context.Context, db *gorm.DB, n int) ([]uint, error) {
+	ids := make([]uint, 0, n)
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := 1; i <= n; i++ {
+			accountNo := fmt.Sprintf("BENCH-%04d", i)
+			var account Account
+			err := tx.Where("account_no = ?", accountNo).First(&account).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				account = Account{AccountNo: accountNo, Balance: benchmarkAccountBalance, OwnerName: "ベンチマーク"}
+				err = openAccount(tx, &account)
+			}
+			if err != nil {
+				return err
+			}
+			ids = append(ids, account.ID)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// RunLockStrategyBenchmark 悲観ロック（SELECT ... FOR UPDATE）と楽観ロック（version）で同じ負荷をかけて比較する
+//
+// accountsの口座の中からランダムに2つ選んで1円ずつ振り込む。口座数を減らすほど同じ行への更新が重なる。
+func (h *Handler) RunLockStrategyBenchmark(ctx context.Context, config benchmark.Config, accounts int) ([]LockStrategyReport, error) {
+	ids, err := ensureBenchmarkAccounts(ctx, h.DB, accounts)
+	if err != nil {
+		return nil, err
+	}
+	pick := func() (uint, uint) {
+		i := rand.Intn(len(ids))
+		j := rand.Intn(len(ids) - 1)
+		if j >= i {
+			j++
+		}
+		return ids[i], ids[j]
+	}
+
+	strategies := []struct {
+		name     string
+		transfer func(ctx context.Context, from, to uint) (attempts int, err error)
+	}{
+		{"pessimistic", func(ctx context.Context, from, to uint) (int, error) {
+			_, _, _, err := executeTransferWithLockOrder(ctx, h.DB, from, to, 1)
+			return 1, err
+		}},
+		{"optimistic", func(ctx context.Context, from, to uint) (int, error) {
+			_, _, _, attempts, err := transferOptimisticWithRetry(ctx, h.DB, from, to, 1)
+			return attempts, err
+		}},
+	}
+
+	reports := make([]LockStrategyReport, 0, len(strategies))
+	for _, s := range strategies {
+		var retries, gaveUp atomic.Int64
+		report := benchmark.Run(ctx, s.name, config, func(ctx context.Context) error {
+			from, to := pick()
+			attempts, err := s.transfer(ctx, from, to)
+			retries.Add(int64(attempts - 1))
+			if errors.Is(err, ErrVersionConflict) {
+				gaveUp.Add(1)
+			}
+			return err
+		})
+
+		r := LockStrategyReport{Report: report, Accounts: accounts, Retries: retries.Load(), GaveUp: gaveUp.Load()}
+		if report.Requests > 0 {
+			r.RetriesPerReq = float64(r.Retries) / float64(report.Requests)
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// LockBenchmarkHandler 悲観ロックと楽観ロックのスループット・リトライ回数・レイテンシを比較
+//
+// concurrency, duration, accounts（競合の度合い。2が最も激しい）, format=json|html
+func (h *Handler) LockBenchmarkHandler(w http.ResponseWriter, r *http.Request) {
+	config := benchmark.Config{Concurrency: 10, Duration: 5 * time.Second}
+	accounts := 2
+
+	if raw := r.URL.Query().Get("concurrency"); raw != "" {
+		c, err := strconv.Atoi(raw)
+		if err != nil || c <= 0 || c > maxLockBenchmarkConcurrency {
+			http.Error(w, fmt.Sprintf("Invalid concurrency (1-%d)", maxLockBenchmarkConcurrency), http.StatusBadRequest)
+			return
+		}
+		config.Concurrency = c
+	}
+	if raw := r.URL.Query().Get("duration"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > maxLockBenchmarkDuration {
+			http.Error(w, fmt.Sprintf("Invalid duration (e.g. 5s, max %s)", maxLockBenchmarkDuration), http.StatusBadRequest)
+			return
+		}
+		config.Duration = d
+	}
+	if raw := r.URL.Query().Get("accounts"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 2 || n > maxLockBenchmarkAccounts {
+			http.Error(w, fmt.Sprintf("Invalid accounts (2-%d)", maxLockBenchmarkAccounts), http.StatusBadRequest)
+			return
+		}
+		accounts = n
+	}
+	if err := autoMigrate(h.DB); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to migrate: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	reports, err := h.RunLockStrategyBenchmark(r.Context(), config, accounts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Benchmark failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "html" {
+		plain := make([]benchmark.Report, 0, len(reports))
+		for _, r := range reports {
+			plain = append(plain, r.Report)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := benchmark.WriteHTML(w, fmt.Sprintf("Pessimistic vs optimistic locking (%d accounts)", accounts), plain); err != nil {
+			return
+		}
+		return
+	}
+	jsonResponse(w, map[string]interface{}{
+		"config": map[string]interface{}{
+			"concurrency": config.Concurrency,
+			"duration":    config.Duration.String(),
+			"accounts":    accounts,
+		},
+		"reports": reports,
+	})
+}
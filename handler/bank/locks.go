@@ -0,0 +1,405 @@
+package bank
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ロックの診断（pg_locks + pg_stat_activity）
+//
+// /api/bank/deadlock のデモや止まった振込の最中に、どのバックエンドがどのロックを持ち、誰を待たせているかを見る。
+// 待ちの関係は pg_blocking_pids で求め、「待っている側 → 待たせている側」の有向グラフ（waits-for graph）にする。
+// グラフの閉路はデッドロックで、deadlock_timeout（既定1秒）が過ぎるとPostgreSQLがどれかをエラーにして解消する。
+
+const (
+	lockStreamDefaultInterval = 200 * time.Millisecond
+	lockStreamMinInterval     = 20 * time.Millisecond
+	lockStreamDefaultDuration = 10 * time.Second
+	lockStreamMaxDuration     = 5 * time.Minute
+)
+
+// HeldLock バックエンドが持っている・待っているロック
+type HeldLock struct {
+	LockType string `json:"locktype"`         // relation, tuple, transactionid など
+	Mode     string `json:"mode"`             // RowExclusiveLock, ShareLock など
+	Granted  bool   `json:"granted"`          // falseなら待っている
+	Target   string `json:"target,omitempty"` // テーブル名・トランザクションIDなど
+}
+
+// BackendLocks バックエンド1つ分の状態
+type BackendLocks struct {
+	PID           int        `json:"pid"`
+	State         string     `json:"state"`
+	Query         string     `json:"query"`
+	WaitEventType string     `json:"wait_event_type,omitempty"`
+	WaitEvent     string     `json:"wait_event,omitempty"`
+	XactStart     *time.Time `json:"xact_start,omitempty"`
+	WaitingMs     int64      `json:"waiting_ms,omitempty"` // 現在のクエリを待っている時間（ロック待ちの場合）
+	BlockedBy     []int      `json:"blocked_by,omitempty"`
+	Locks         []HeldLock `json:"locks"`
+}
+
+// WaitEdge 待ちの関係（WaiterがBlockerのロックの解放を待っている）
+type WaitEdge struct {
+	Waiter  int `json:"waiter"`
+	Blocker int `json:"blocker"`
+}
+
+// LockSnapshot ある時点のロックの状態
+type LockSnapshot struct {
+	At       time.Time      `json:"at"`
+	Backends []BackendLocks `json:"backends"`
+	Edges    []WaitEdge     `json:"edges"`
+	// Chains 待たされている末端から、誰も待っていない根の待たせている側までの経路
+	Chains [][]int `json:"chains"`
+	// Cycles 閉路（デッドロック）。各閉路は最小のPIDから始める
+	Cycles [][]int `json:"cycles"`
+	// RootBlockers 他を待たせているが、自分は何も待っていないバックエンド（キャンセル・終了の候補）
+	RootBlockers []int `json:"root_blockers"`
+}
+
+// lockRow pg_locks と pg_stat_activity を結合した1行
+type lockRow struct {
+	PID           int `gorm:"column:pid"`
+	State         string
+	Query         string
+	WaitEventType string
+	WaitEvent     string
+	XactStart     *time.Time
+	QueryStart    *time.Time
+	BlockedBy     string // pg_blocking_pidsの配列をカンマ区切りにしたもの
+	LockType      *string
+	Mode          *string
+	Granted       *bool
+	Target        *string
+}
+
+// snapshotLocks 現在のDBのバックエンド（自分以外）のロックを取得する
+func snapshotLocks(ctx context.Context, db *gorm.DB) (*LockSnapshot, error) {
+	var rows []lockRow
+	if err := db.WithContext(ctx).Raw(`SELECT
+			a.pid,
+			COALESCE(a.state, '') AS state,
+			COALESCE(a.query, '') AS query,
+			COALESCE(a.wait_event_type, '') AS wait_event_type,
+			COALESCE(a.wait_event, '') AS wait_event,
+			a.xact_start,
+			a.query_start,
+			array_to_string(pg_blocking_pids(a.pid), ',') AS blocked_by,
+			l.locktype AS lock_type,
+			l.mode,
+			l.granted,
+			COALESCE(l.relation::regclass::text, l.transactionid::text, l.virtualxid,
+				CASE WHEN l.page IS NOT NULL THEN l.page || ':' || l.tuple END) AS target
+		FROM pg_stat_activity a
+		LEFT JOIN pg_locks l ON l.pid = a.pid
+		WHERE a.datname = current_database() AND a.pid <> pg_backend_pid() AND a.backend_type = 'client backend'
+		ORDER BY a.pid, l.granted, l.locktype`).Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read pg_locks: %w", err)
+	}
+	return buildLockSnapshot(rows, time.Now()), nil
+}
+
+// buildLockSnapshot 行をバックエンドごとにまとめ、待ちのグラフを作る
+func buildLockSnapshot(rows []lockRow, now time.Time) *LockSnapshot {
+	snapshot := &LockSnapshot{At: now, Backends: []BackendLocks{}, Edges: []WaitEdge{}}
+	index := map[int]int{}
+	for _, row := range rows {
+		i, ok := index[row.PID]
+		if !ok {
+			b := BackendLocks{
+				PID:           row.PID,
+				State:         row.State,
+				Query:         truncate(row.Query, 500),
+				WaitEventType: row.WaitEventType,
+				WaitEvent:     row.WaitEvent,
+				XactStart:     row.XactStart,
+				BlockedBy:     parsePIDs(row.BlockedBy),
+				Locks:         []HeldLock{},
+			}
+			if row.WaitEventType == "Lock" && row.QueryStart != nil {
+				b.WaitingMs = now.Sub(*row.QueryStart).Milliseconds()
+			}
+			index[row.PID] = len(snapshot.Backends)
+			snapshot.Backends = append(snapshot.Backends, b)
+			i = index[row.PID]
+		}
+		if row.LockType != nil {
+			lock := HeldLock{LockType: *row.LockType}
+			if row.Mode != nil {
+				lock.Mode = *row.Mode
+			}
+			if row.Granted != nil {
+				lock.Granted = *row.Granted
+			}
+			if row.Target != nil {
+				lock.Target = *row.Target
+			}
+			snapshot.Backends[i].Locks = append(snapshot.Backends[i].Locks, lock)
+		}
+	}
+
+	graph := map[int][]int{}
+	for _, b := range snapshot.Backends {
+		for _, blocker := range b.BlockedBy {
+			snapshot.Edges = append(snapshot.Edges, WaitEdge{Waiter: b.PID, Blocker: blocker})
+			graph[b.PID] = append(graph[b.PID], blocker)
+		}
+	}
+	snapshot.Chains, snapshot.Cycles, snapshot.RootBlockers = analyzeWaitsFor(graph)
+	return snapshot
+}
+
+// parsePIDs "12,34" を []int にする
+func parsePIDs(s string) []int {
+	if s == "" {
+		return nil
+	}
+	var pids []int
+	for _, part := range strings.Split(s, ",") {
+		if pid, err := strconv.Atoi(part); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// analyzeWaitsFor 待ちのグラフ（waiter → blockers）から、待ちの連鎖・閉路・根の待たせている側を求める
+func analyzeWaitsFor(graph map[int][]int) (chains, cycles [][]int, roots []int) {
+	waitedOn := map[int]bool{}
+	nodes := map[int]bool{}
+	for waiter, blockers := range graph {
+		nodes[waiter] = true
+		for _, blocker := range blockers {
+			nodes[blocker] = true
+			waitedOn[blocker] = true
+		}
+	}
+	sorted := make([]int, 0, len(nodes))
+	for pid := range nodes {
+		sorted = append(sorted, pid)
+	}
+	sort.Ints(sorted)
+	for pid := range graph {
+		sort.Ints(graph[pid])
+	}
+
+	for _, pid := range sorted {
+		if waitedOn[pid] && len(graph[pid]) == 0 {
+			roots = append(roots, pid)
+		}
+	}
+
+	// 閉路：最小のPIDから始まるものだけを数えて重複を除く
+	seen := map[string]bool{}
+	var visit func(start, pid int, path []int, onPath map[int]bool)
+	visit = func(start, pid int, path []int, onPath map[int]bool) {
+		for _, next := range graph[pid] {
+			if next == start {
+				cycle := append([]int(nil), path...)
+				key := fmt.Sprint(cycle)
+				if !seen[key] {
+					seen[key] = true
+					cycles = append(cycles, cycle)
+				}
+				continue
+			}
+			if next < start || onPath[next] {
+				continue
+			}
+			onPath[next] = true
+			visit(start, next, append(path, next), onPath)
+			delete(onPath, next)
+		}
+	}
+	for _, pid := range sorted {
+		visit(pid, pid, []int{pid}, map[int]bool{pid: true})
+	}
+
+	// 連鎖：誰にも待たれていない末端の待っている側から、根まで（閉路に入ったら打ち切る）
+	var walk func(pid int, path []int, onPath map[int]bool)
+	walk = func(pid int, path []int, onPath map[int]bool) {
+		if len(graph[pid]) == 0 {
+			chains = append(chains, append([]int(nil), path...))
+			return
+		}
+		for _, next := range graph[pid] {
+			if onPath[next] {
+				chains = append(chains, append(append([]int(nil), path...), next))
+				continue
+			}
+			onPath[next] = true
+			walk(next, append(path, next), onPath)
+			delete(onPath, next)
+		}
+	}
+	for _, pid := range sorted {
+		if len(graph[pid]) > 0 && !waitedOn[pid] {
+			walk(pid, []int{pid}, map[int]bool{pid: true})
+		}
+	}
+	if chains == nil {
+		chains = [][]int{}
+	}
+	if cycles == nil {
+		cycles = [][]int{}
+	}
+	if roots == nil {
+		roots = []int{}
+	}
+	return chains, cycles, roots
+}
+
+// LockDiagnosticsHandler ロックの状態を返す
+//
+// GET: スナップショットを1回返す。?stream=1 の場合は interval_ms ごとに duration_ms の間、
+// Server-Sent Eventsの"snapshot"イベントで送り続け、最後に"done"イベントを送る。
+func (h *Handler) LockDiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("stream") == "" {
+		snapshot, err := snapshotLocks(r.Context(), h.DB)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, snapshot)
+		return
+	}
+
+	interval, err := durationParam(query.Get("interval_ms"), lockStreamDefaultInterval)
+	if err != nil || interval < lockStreamMinInterval {
+		http.Error(w, fmt.Sprintf("Invalid interval_ms (>= %d)", lockStreamMinInterval.Milliseconds()), http.StatusBadRequest)
+		return
+	}
+	duration, err := durationParam(query.Get("duration_ms"), lockStreamDefaultDuration)
+	if err != nil || duration <= 0 || duration > lockStreamMaxDuration {
+		http.Error(w, fmt.Sprintf("Invalid duration_ms (1-%d)", lockStreamMaxDuration.Milliseconds()), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func(event string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), duration)
+	defer cancel()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	samples := 0
+	for ctx.Err() == nil {
+		snapshot, err := snapshotLocks(ctx, h.DB)
+		if err != nil {
+			if ctx.Err() == nil {
+				_ = send("error", map[string]string{"error": err.Error()})
+			}
+			break
+		}
+		samples++
+		if err := send("snapshot", snapshot); err != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+	if r.Context().Err() == nil {
+		_ = send("done", map[string]int{"samples": samples})
+	}
+}
+
+// durationParam ミリ秒のクエリパラメータ（空ならdef）
+func durationParam(raw string, def time.Duration) (time.Duration, error) {
+	if raw == "" {
+		return def, nil
+	}
+	ms, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// SignalBackendRequest バックエンドのキャンセル・終了
+type SignalBackendRequest struct {
+	PID    int    `json:"pid"`
+	Action string `json:"action"` // cancel（実行中のクエリだけ止める）, terminate（接続を切る）
+}
+
+// SignalBackendHandler 待たせているバックエンドのクエリをキャンセル、または接続を終了する（POST）
+//
+// 対象は同じDBのクライアントのバックエンドに限る（自分自身やPostgreSQLの内部プロセスは対象外）。
+// 他のリクエストの処理を止められるので、EnableBackendSignalsを設定しない限り403を返す。
+func (h *Handler) SignalBackendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.EnableBackendSignals {
+		http.Error(w, "Backend signals are disabled", http.StatusForbidden)
+		return
+	}
+	var req SignalBackendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var fn string
+	switch req.Action {
+	case "cancel":
+		fn = "pg_cancel_backend"
+	case "terminate":
+		fn = "pg_terminate_backend"
+	default:
+		http.Error(w, "Invalid action (cancel, terminate)", http.StatusBadRequest)
+		return
+	}
+
+	var signaled *bool
+	if err := h.DB.WithContext(r.Context()).Raw(`SELECT `+fn+`(pid) FROM pg_stat_activity
+		WHERE pid = ? AND pid <> pg_backend_pid() AND datname = current_database() AND backend_type = 'client backend'`,
+		req.PID).Row().Scan(&signaled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Backend not found in this database", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{
+		"success": signaled != nil && *signaled,
+		"pid":     req.PID,
+		"action":  req.Action,
+	})
+}
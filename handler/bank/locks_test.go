@@ -0,0 +1,115 @@
+package bank
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeWaitsFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		graph  map[int][]int
+		chains string
+		cycles string
+		roots  string
+	}{
+		{"no waits", map[int][]int{}, "[]", "[]", "[]"},
+		{
+			"chain",
+			map[int][]int{30: {20}, 20: {10}, 40: {10}},
+			"[[30 20 10] [40 10]]", "[]", "[10]",
+		},
+		{
+			"deadlock",
+			map[int][]int{10: {20}, 20: {10}},
+			"[]", "[[10 20]]", "[]",
+		},
+		{
+			"waiting on a deadlock",
+			map[int][]int{10: {20}, 20: {30}, 30: {10}, 40: {30}},
+			"[[40 30 10 20 30]]", "[[10 20 30]]", "[]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chains, cycles, roots := analyzeWaitsFor(tt.graph)
+			if got := fmt.Sprint(chains); got != tt.chains {
+				t.Errorf("chains = %s; expected %s", got, tt.chains)
+			}
+			if got := fmt.Sprint(cycles); got != tt.cycles {
+				t.Errorf("cycles = %s; expected %s", got, tt.cycles)
+			}
+			if got := fmt.Sprint(roots); got != tt.roots {
+				t.Errorf("roots = %s; expected %s", got, tt.roots)
+			}
+		})
+	}
+}
+
+// 行ロックを待っているバックエンドが、ロックを持っているバックエンドへの辺として現れること
+func TestSnapshotLocks_RowLockWait(t *testing.T) {
+	db := openTestDB(t)
+	account := createTestAccount(t, db, AccountTypeChecking, 1000)
+	ctx := context.Background()
+
+	holder := db.Begin()
+	defer holder.Rollback()
+	if _, err := lockAccount(holder, account.ID); err != nil {
+		t.Fatal(err)
+	}
+	var holderPID int
+	holder.Raw("SELECT pg_backend_pid()").Scan(&holderPID)
+
+	waiter := db.Begin()
+	defer waiter.Rollback()
+	var waiterPID int
+	waiter.Raw("SELECT pg_backend_pid()").Scan(&waiterPID)
+	done := make(chan error, 1)
+	go func() {
+		_, err := lockAccount(waiter, account.ID)
+		done <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot, err := snapshotLocks(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, e := range snapshot.Edges {
+			if e.Waiter == waiterPID && e.Blocker == holderPID {
+				found = true
+			}
+		}
+		if found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("edge %d -> %d not found: %+v", waiterPID, holderPID, snapshot.Edges)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	holder.Rollback()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// EnableBackendSignalsを設定していなければ、DBに触れずに403を返すこと
+func TestSignalBackendHandler_DisabledByDefault(t *testing.T) {
+	// DBを設定していないので、シグナルを送ろうとするとpanicする
+	h := &Handler{}
+	req := httptest.NewRequest(http.MethodPost, "/api/bank/locks/signal", strings.NewReader(`{"pid":1,"action":"terminate"}`))
+	w := httptest.NewRecorder()
+	h.SignalBackendHandler(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d; expected 403", w.Code)
+	}
+}
@@ -1,15 +1,33 @@
 package bank
 
-import "time"
+import (
+	"time"
+
+	"github.com/keito-isurugi/go-demo/outbox"
+	"gorm.io/gorm"
+)
 
 // Account 口座モデル
 type Account struct {
-	ID        uint      `gorm:"primaryKey"`
-	AccountNo string    `gorm:"uniqueIndex;size:20;not null"` // 口座番号
-	Balance   int64     `gorm:"not null;default:0"`           // 残高（単位: 円）
-	OwnerName string    `gorm:"size:100;not null"`            // 口座名義人
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	ID             uint      `gorm:"primaryKey"`
+	AccountNo      string    `gorm:"uniqueIndex;size:20;not null"`        // 口座番号
+	Balance        int64     `gorm:"not null;default:0"`                  // 残高（口座の通貨の最小単位。JPYなら円、USDならセント）
+	OwnerName      string    `gorm:"size:100;not null"`                   // 口座名義人
+	AccountType    string    `gorm:"size:20;not null;default:'checking'"` // checking, savings, system
+	Currency       string    `gorm:"size:3;not null;default:'JPY'"`       // 口座の通貨（ISO 4217）
+	IsSystem       bool      `gorm:"not null;default:false"`              // 元帳の相手勘定となるシステム口座
+	Version        int64     `gorm:"not null;default:0"`                  // 楽観ロック用のバージョン（残高を変えるたびに+1）
+	JointAccountID *uint     `gorm:"index"`                               // 共同名義口座のグループ
+	CreatedAt      time.Time `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime"`
+}
+
+// JointAccount 共同名義口座のグループ（メンバーの残高合計に下限がある）
+type JointAccount struct {
+	ID                 uint      `gorm:"primaryKey"`
+	Name               string    `gorm:"size:100;not null"`
+	MinCombinedBalance int64     `gorm:"not null;default:0"` // メンバーの残高合計の下限
+	CreatedAt          time.Time `gorm:"autoCreateTime"`
 }
 
 // Transaction 取引履歴モデル
@@ -17,13 +35,59 @@ type Transaction struct {
 	ID              uint      `gorm:"primaryKey"`
 	FromAccountID   uint      `gorm:"index;not null"`                     // 送金元口座ID
 	ToAccountID     uint      `gorm:"index;not null"`                     // 送金先口座ID
-	Amount          int64     `gorm:"not null"`                           // 送金額
+	Amount          int64     `gorm:"not null"`                           // 送金額（送金元の通貨の最小単位）
+	Currency        string    `gorm:"size:3;not null;default:'JPY'"`      // 送金元の通貨
+	ToAmount        int64     `gorm:"not null;default:0"`                 // 入金額（送金先の通貨の最小単位。同じ通貨ならAmountと同じ）
+	ToCurrency      string    `gorm:"size:3;not null;default:'JPY'"`      // 送金先の通貨
+	FxRate          *string   `gorm:"type:numeric(20,10)"`                // 適用した為替レート（1 Currency = FxRate ToCurrency）
+	FxRateID        *uint     `gorm:"index"`                              // 適用したfx_ratesの行
 	Status          string    `gorm:"size:20;not null;default:'pending'"` // pending, completed, failed
 	TransactionType string    `gorm:"size:20;not null"`                   // transfer, deposit, withdrawal
+	FailureReason   string    `gorm:"size:200"`                           // failedになった理由
 	CreatedAt       time.Time `gorm:"autoCreateTime"`
 	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
 }
 
+// migrateLockKey 複数のインスタンスが同時に起動したときに、トリガーの作成を1つずつにするアドバイザリロックのキー
+const migrateLockKey = 4901
+
+// Migrate 銀行デモのテーブルとトリガーを作成・更新する（起動時に1回だけ呼ぶ）
+//
+// トリガーの作成と残高の履歴の補完は1つのトランザクションで行う。
+func Migrate(db *gorm.DB) error {
+	if err := autoMigrate(db); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrateLockKey).Error; err != nil {
+			return err
+		}
+		if err := ensureAuditLogAppendOnly(tx); err != nil {
+			return err
+		}
+		return ensureBalanceHistory(tx)
+	})
+}
+
+// autoMigrate 銀行デモで使う全テーブルを作成・更新（トリガーはMigrateで作成済み）
+func autoMigrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(
+		&Account{}, &Transaction{}, &IdempotencyKey{}, &JournalEntry{}, &Posting{}, &JointAccount{},
+		&IsolationDemoAccount{}, &FxRate{}, &ScheduledTransfer{}, &ScheduledTransferRun{}, &Notification{},
+		&TransferRuleConfig{}, &TransferReview{}, &outbox.Message{}, &AuditLogEntry{}, &AuditCheckpoint{},
+		&AccountBalanceVersion{},
+	); err != nil {
+		return err
+	}
+	// チェックポイントに公開鍵を保存していた頃の列（key_idが空の古い行は信頼されない）
+	if db.Migrator().HasColumn(&AuditCheckpoint{}, "public_key") {
+		if err := db.Migrator().DropColumn(&AuditCheckpoint{}, "public_key"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // TransferRequest 振込リクエスト
 type TransferRequest struct {
 	FromAccountID uint  `json:"from_account_id"`
@@ -38,6 +102,8 @@ type TransferResponse struct {
 	TransactionID uint   `json:"transaction_id,omitempty"`
 	FromBalance   int64  `json:"from_balance,omitempty"`
 	ToBalance     int64  `json:"to_balance,omitempty"`
+	Attempts      int    `json:"attempts,omitempty"`  // リトライを含めた試行回数
+	ReviewID      uint   `json:"review_id,omitempty"` // 振込ルールで審査待ちになった場合の審査ID
 }
 
 // 定数定義
@@ -49,4 +115,10 @@ const (
 	TransactionTypeTransfer   = "transfer"
 	TransactionTypeDeposit    = "deposit"
 	TransactionTypeWithdrawal = "withdrawal"
+	TransactionTypeCorrection = "correction" // 過去に遡る訂正
+	TransactionTypeOpening    = "opening"    // 開始残高（取引履歴は作らず、口座のイベントにだけ使う）
+
+	AccountTypeChecking = "checking" // 普通預金（当座貸越あり）
+	AccountTypeSavings  = "savings"  // 貯蓄預金（最低残高あり）
+	AccountTypeSystem   = "system"   // システム口座
 )
@@ -0,0 +1,166 @@
+package bank
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrVersionConflict 読み込んでから更新するまでに他のトランザクションが口座を更新した
+var ErrVersionConflict = errors.New("account was modified concurrently")
+
+const (
+	optimisticMaxRetries   = 10
+	optimisticInitialDelay = 5 * time.Millisecond
+)
+
+// updateBalanceIfVersion versionが読み込んだ時のままの場合だけ残高を更新する
+func updateBalanceIfVersion(tx *gorm.DB, account *Account, delta int64) error {
+	result := tx.Exec(
+		"UPDATE accounts SET balance = balance + ?, version = version + 1, updated_at = ? WHERE id = ? AND version = ?",
+		delta, time.Now(), account.ID, account.Version,
+	)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update account %d: %w", account.ID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: account %d (version %d)", ErrVersionConflict, account.ID, account.Version)
+	}
+	account.Balance += delta
+	account.Version++
+	return nil
+}
+
+// executeOptimisticTransfer 行ロックを取らずに読み込み、versionが変わっていなければ更新する
+//
+// SELECT ... FOR UPDATEで待たない代わりに、競合した場合はErrVersionConflictを返す。
+// UPDATEの順序はロック順序の統一と同じくID順にして、更新同士のデッドロックを避ける。
+// 振込ルールで審査待ちになった場合は、残高を変えずに審査の記録だけをコミットする。
+func executeOptimisticTransfer(ctx context.Context, db *gorm.DB, fromAccountID, toAccountID uint, amount int64) (*Account, *Account, *Transaction, error) {
+	var fromAccount, toAccount Account
+	var transaction *Transaction
+	var held error
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&fromAccount, fromAccountID).Error; err != nil {
+			return fmt.Errorf("from account not found: %w", err)
+		}
+		if err := tx.First(&toAccount, toAccountID).Error; err != nil {
+			return fmt.Errorf("to account not found: %w", err)
+		}
+		if fromAccount.Balance < amount {
+			return fmt.Errorf("insufficient balance: %d < %d", fromAccount.Balance, amount)
+		}
+		if err := enforceTransferRules(tx, &fromAccount, &toAccount, amount); err != nil {
+			if errors.Is(err, ErrTransferHeld) {
+				// 同時の振込と同じ判定結果で審査待ちを重ねないよう、送金元のversionだけ進める
+				if err := updateBalanceIfVersion(tx, &fromAccount, 0); err != nil {
+					return err
+				}
+				held = err
+				return nil
+			}
+			return err
+		}
+
+		first, second := &fromAccount, &toAccount
+		firstDelta, secondDelta := -amount, amount
+		if toAccountID < fromAccountID {
+			first, second = second, first
+			firstDelta, secondDelta = secondDelta, firstDelta
+		}
+		if err := updateBalanceIfVersion(tx, first, firstDelta); err != nil {
+			return err
+		}
+		if err := updateBalanceIfVersion(tx, second, secondDelta); err != nil {
+			return err
+		}
+
+		var err error
+		transaction, err = createTransaction(tx, &fromAccount, &toAccount, amount)
+		if err != nil {
+			return err
+		}
+		// 残高は更新済みなので仕訳だけ記帳する
+		return recordJournalEntry(tx, &transaction.ID, TransactionTypeTransfer, []Posting{
+			{AccountID: fromAccountID, Amount: -amount},
+			{AccountID: toAccountID, Amount: amount},
+		})
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if held != nil {
+		return nil, nil, nil, held
+	}
+	return &fromAccount, &toAccount, transaction, nil
+}
+
+// transferOptimisticWithRetry 競合した場合だけretryWithBackoffでやり直す（残高不足などはリトライしない）
+func transferOptimisticWithRetry(ctx context.Context, db *gorm.DB, fromAccountID, toAccountID uint, amount int64) (*Account, *Account, *Transaction, int, error) {
+	var fromAccount, toAccount *Account
+	var transaction *Transaction
+	var finalErr error
+	attempts := 0
+
+	err := retryWithBackoff(optimisticMaxRetries, optimisticInitialDelay, func(attempt int) error {
+		attempts = attempt + 1
+		var err error
+		fromAccount, toAccount, transaction, err = executeOptimisticTransfer(ctx, db, fromAccountID, toAccountID, amount)
+		if errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+		finalErr = err
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, attempts, fmt.Errorf("gave up after %d attempts: %w", attempts, err)
+	}
+	return fromAccount, toAccount, transaction, attempts, finalErr
+}
+
+// OptimisticTransferHandler 楽観ロック（versionカラム）による振込
+func (h *Handler) OptimisticTransferHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	fromAccount, toAccount, transaction, attempts, err := transferOptimisticWithRetry(r.Context(), h.DB, req.FromAccountID, req.ToAccountID, req.Amount)
+	if errors.Is(err, ErrVersionConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if writeTransferRuleError(w, err) {
+		return
+	}
+	// 楽観ロックの振込は同じ通貨の口座間のみ対応
+	if isFxRejection(err) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Transaction failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, TransferResponse{
+		Success:       true,
+		Message:       "Transfer completed successfully (optimistic locking)",
+		TransactionID: transaction.ID,
+		FromBalance:   fromAccount.Balance,
+		ToBalance:     toAccount.Balance,
+		Attempts:      attempts,
+	})
+}
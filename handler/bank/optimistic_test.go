@@ -0,0 +1,40 @@
+package bank
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 同じ2口座に同時に振り込んでも、versionの競合をリトライして全件成功し残高が保存されること
+func TestTransferOptimisticWithRetry_Concurrent(t *testing.T) {
+	db := openTestDB(t)
+	a := createTestAccount(t, db, AccountTypeChecking, 100000)
+	b := createTestAccount(t, db, AccountTypeChecking, 100000)
+
+	const workers = 10
+	var wg sync.WaitGroup
+	var retries atomic.Int64
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			from, to := a.ID, b.ID
+			if i%2 == 1 {
+				from, to = to, from
+			}
+			_, _, _, attempts, err := transferOptimisticWithRetry(context.Background(), db, from, to, 100*int64(i+1))
+			if err != nil {
+				t.Errorf("transferOptimisticWithRetry() error = %v", err)
+			}
+			retries.Add(int64(attempts - 1))
+		}(i)
+	}
+	wg.Wait()
+	t.Logf("retries: %d", retries.Load())
+
+	// a→b: 100+300+500+700+900 = 2500, b→a: 200+400+600+800+1000 = 3000
+	assertLedgerBalance(t, db, a.ID, 100000-2500+3000)
+	assertLedgerBalance(t, db, b.ID, 100000+2500-3000)
+}
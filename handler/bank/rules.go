@@ -0,0 +1,620 @@
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 振込ルール（不正検知・限度額）
+//
+// books/goodbaddev の ExcellentCustomerRule と同じPolicyパターンで、1つのルールが1つの条件だけを判定し、
+// TransferPolicy がすべてのルールの判定をまとめる。ルールは transfer_rule_configs テーブルから振込のたびに読み込むため、
+// /api/bank/transfer-rules で変更すると再デプロイせずに次の振込から反映される。
+//
+// ルールを判定する振込と、同じ口座からの同時振込で日次限度額などをすり抜けられない理由:
+//
+//   - /api/bank/transfer・/api/bank/transfer-safe・/api/bank/transfer-timeout・予約振込:
+//     送金元・送金先の口座をID順にロックしてから判定する
+//   - /api/bank/transfer-optimistic: ロックは取らず、送金元のversionが判定後に変わっていれば
+//     最初からやり直す（やり直すときに判定もし直す）
+//   - /api/bank/transfer-serializable: ロックは取らず、SERIALIZABLEで同時の振込と衝突すれば
+//     最初からやり直す
+//
+// 審査待ちの振込を承認したときは判定しない（判定は審査待ちにした時点で済んでいる）。
+
+// RuleDecision ルールの判定結果
+type RuleDecision string
+
+const (
+	DecisionAllow  RuleDecision = "allow"
+	DecisionReview RuleDecision = "review" // 担当者が承認するまで保留する
+	DecisionDeny   RuleDecision = "deny"
+)
+
+// severity 複数のルールに該当した場合は重い判定を採用する
+func (d RuleDecision) severity() int {
+	switch d {
+	case DecisionDeny:
+		return 2
+	case DecisionReview:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ルールの種類
+const (
+	RuleKindDailyLimit   = "daily_limit"   // 1日（日本時間）の振込合計の上限
+	RuleKindMonthlyLimit = "monthly_limit" // 1か月（日本時間）の振込合計の上限
+	RuleKindVelocity     = "velocity"      // window_minutes分間の振込回数の上限
+	RuleKindNewPayee     = "new_payee"     // 初めての送金先には、window_minutes分間はamountを超える振込をさせない
+	RuleKindLargeAmount  = "large_amount"  // amount以上の振込
+)
+
+// TransferFacts ルールの判定材料（PurchaseHistoryに相当）
+//
+// 金額はすべて送金元の通貨の最小単位。合計・回数には審査待ちの振込も含める（分割してすり抜けられないように）。
+type TransferFacts struct {
+	From   *Account
+	To     *Account
+	Amount int64
+	Now    time.Time
+
+	SentToday     int64       // 今日の振込合計（今回の振込を除く）
+	SentThisMonth int64       // 今月の振込合計（今回の振込を除く）
+	Recent        []time.Time // 直近の振込日時（velocityルールの最大のwindow分）
+	FirstToPayee  *time.Time  // この送金先への最初の振込（無ければnil）
+}
+
+// TransferRule 振込ルール
+type TransferRule interface {
+	// check 該当すればその判定と理由を、該当しなければDecisionAllowを返す
+	check(f *TransferFacts) (RuleDecision, string)
+}
+
+// DailyLimitRule 1日の振込合計の上限
+type DailyLimitRule struct {
+	Limit    int64
+	Decision RuleDecision
+}
+
+func (r DailyLimitRule) check(f *TransferFacts) (RuleDecision, string) {
+	if f.SentToday+f.Amount > r.Limit {
+		return r.Decision, fmt.Sprintf("daily limit %d exceeded (%d already sent today)", r.Limit, f.SentToday)
+	}
+	return DecisionAllow, ""
+}
+
+// MonthlyLimitRule 1か月の振込合計の上限
+type MonthlyLimitRule struct {
+	Limit    int64
+	Decision RuleDecision
+}
+
+func (r MonthlyLimitRule) check(f *TransferFacts) (RuleDecision, string) {
+	if f.SentThisMonth+f.Amount > r.Limit {
+		return r.Decision, fmt.Sprintf("monthly limit %d exceeded (%d already sent this month)", r.Limit, f.SentThisMonth)
+	}
+	return DecisionAllow, ""
+}
+
+// VelocityRule Window内の振込回数の上限（今回の振込を含めてMaxCount回まで）
+type VelocityRule struct {
+	MaxCount int
+	Window   time.Duration
+	Decision RuleDecision
+}
+
+func (r VelocityRule) check(f *TransferFacts) (RuleDecision, string) {
+	since := f.Now.Add(-r.Window)
+	count := 1
+	for _, at := range f.Recent {
+		if at.After(since) {
+			count++
+		}
+	}
+	if count > r.MaxCount {
+		return r.Decision, fmt.Sprintf("%d transfers within %s (max %d)", count, r.Window, r.MaxCount)
+	}
+	return DecisionAllow, ""
+}
+
+// NewPayeeRule 初めて振り込む相手には、CoolingPeriodの間はLimitを超える振込をさせない
+type NewPayeeRule struct {
+	CoolingPeriod time.Duration
+	Limit         int64
+	Decision      RuleDecision
+}
+
+func (r NewPayeeRule) check(f *TransferFacts) (RuleDecision, string) {
+	if f.FirstToPayee != nil && !f.FirstToPayee.After(f.Now.Add(-r.CoolingPeriod)) {
+		return DecisionAllow, ""
+	}
+	if f.Amount > r.Limit {
+		return r.Decision, fmt.Sprintf("new payee %d is in its %s cooling period (max %d)", f.To.ID, r.CoolingPeriod, r.Limit)
+	}
+	return DecisionAllow, ""
+}
+
+// LargeAmountRule Threshold以上の振込
+type LargeAmountRule struct {
+	Threshold int64
+	Decision  RuleDecision
+}
+
+func (r LargeAmountRule) check(f *TransferFacts) (RuleDecision, string) {
+	if f.Amount >= r.Threshold {
+		return r.Decision, fmt.Sprintf("amount %d is at or above %d", f.Amount, r.Threshold)
+	}
+	return DecisionAllow, ""
+}
+
+// RuleHit 該当したルール
+type RuleHit struct {
+	Rule     string       `json:"rule"`
+	Decision RuleDecision `json:"decision"`
+	Reason   string       `json:"reason"`
+}
+
+// TransferPolicy ルールの集まり（ExcellentCustomerPolicyに相当）
+type TransferPolicy struct {
+	names []string
+	rules []TransferRule
+}
+
+// NewTransferPolicy ルールが空のポリシー（すべて許可する）
+func NewTransferPolicy() *TransferPolicy {
+	return &TransferPolicy{}
+}
+
+// Add ルールを追加
+func (p *TransferPolicy) Add(name string, rule TransferRule) {
+	p.names = append(p.names, name)
+	p.rules = append(p.rules, rule)
+}
+
+// Evaluate すべてのルールを判定し、最も重い判定と該当したルールを返す
+func (p *TransferPolicy) Evaluate(f *TransferFacts) (RuleDecision, []RuleHit) {
+	decision := DecisionAllow
+	var hits []RuleHit
+	for i, rule := range p.rules {
+		d, reason := rule.check(f)
+		if d == DecisionAllow {
+			continue
+		}
+		hits = append(hits, RuleHit{Rule: p.names[i], Decision: d, Reason: reason})
+		if d.severity() > decision.severity() {
+			decision = d
+		}
+	}
+	return decision, hits
+}
+
+// TransferRuleConfig 振込ルールの設定
+type TransferRuleConfig struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"uniqueIndex;size:100;not null" json:"name"`
+	Kind          string    `gorm:"size:20;not null" json:"kind"`               // daily_limit, monthly_limit, velocity, new_payee, large_amount
+	Decision      string    `gorm:"size:10;not null" json:"decision"`           // review, deny
+	Amount        int64     `gorm:"not null;default:0" json:"amount"`           // 上限額・しきい値（送金元の通貨の最小単位）
+	Count         int       `gorm:"not null;default:0" json:"count"`            // velocity: 回数の上限
+	WindowMinutes int       `gorm:"not null;default:0" json:"window_minutes"`   // velocity: 期間、new_payee: 冷却期間
+	Currency      string    `gorm:"size:3;not null;default:''" json:"currency"` // 対象の通貨（空ならすべて）
+	Enabled       bool      `gorm:"not null" json:"enabled"`
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// rule 設定からルールを作る
+func (c *TransferRuleConfig) rule() (TransferRule, error) {
+	decision := RuleDecision(c.Decision)
+	if decision != DecisionReview && decision != DecisionDeny {
+		return nil, fmt.Errorf("decision must be %s or %s", DecisionReview, DecisionDeny)
+	}
+	window := time.Duration(c.WindowMinutes) * time.Minute
+	switch c.Kind {
+	case RuleKindDailyLimit, RuleKindMonthlyLimit, RuleKindLargeAmount:
+		if c.Amount <= 0 {
+			return nil, fmt.Errorf("%s requires a positive amount", c.Kind)
+		}
+		switch c.Kind {
+		case RuleKindDailyLimit:
+			return DailyLimitRule{Limit: c.Amount, Decision: decision}, nil
+		case RuleKindMonthlyLimit:
+			return MonthlyLimitRule{Limit: c.Amount, Decision: decision}, nil
+		default:
+			return LargeAmountRule{Threshold: c.Amount, Decision: decision}, nil
+		}
+	case RuleKindVelocity:
+		if c.Count <= 0 || c.WindowMinutes <= 0 {
+			return nil, fmt.Errorf("%s requires a positive count and window_minutes", c.Kind)
+		}
+		return VelocityRule{MaxCount: c.Count, Window: window, Decision: decision}, nil
+	case RuleKindNewPayee:
+		if c.Amount < 0 || c.WindowMinutes <= 0 {
+			return nil, fmt.Errorf("%s requires a non-negative amount and positive window_minutes", c.Kind)
+		}
+		return NewPayeeRule{CoolingPeriod: window, Limit: c.Amount, Decision: decision}, nil
+	default:
+		return nil, fmt.Errorf("unknown rule kind: %q", c.Kind)
+	}
+}
+
+// loadTransferPolicy 有効なルールを読み込む（velocityルールの最大のwindowも返す）
+func loadTransferPolicy(tx *gorm.DB, currency Currency) (*TransferPolicy, time.Duration, error) {
+	var configs []TransferRuleConfig
+	if err := tx.Where("enabled = ? AND (currency = '' OR currency = ?)", true, string(currency)).
+		Order("id").Find(&configs).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to load transfer rules: %w", err)
+	}
+	policy := NewTransferPolicy()
+	var window time.Duration
+	for i := range configs {
+		rule, err := configs[i].rule()
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid transfer rule %q: %w", configs[i].Name, err)
+		}
+		if v, ok := rule.(VelocityRule); ok && v.Window > window {
+			window = v.Window
+		}
+		policy.Add(configs[i].Name, rule)
+	}
+	return policy, window, nil
+}
+
+// 審査の状態
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+)
+
+// TransferReview 審査待ちの振込（承認すると振込を実行する）
+type TransferReview struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	FromAccountID uint       `gorm:"index;not null" json:"from_account_id"`
+	ToAccountID   uint       `gorm:"not null" json:"to_account_id"`
+	Amount        int64      `gorm:"not null" json:"amount"`
+	Currency      string     `gorm:"size:3;not null;default:'JPY'" json:"currency"`
+	Status        string     `gorm:"size:20;not null;index" json:"status"` // pending, approved, rejected
+	Reasons       string     `gorm:"type:text" json:"reasons"`             // 該当したルール（RuleHitのJSON配列）
+	TransactionID *uint      `json:"transaction_id,omitempty"`             // 承認して実行した振込
+	Note          string     `gorm:"size:200" json:"note,omitempty"`       // 審査担当者のメモ
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+}
+
+var (
+	// ErrTransferDenied ルールにより振込が拒否された
+	ErrTransferDenied = errors.New("transfer denied by rule")
+	// ErrTransferHeld ルールにより振込が審査待ちになった
+	ErrTransferHeld = errors.New("transfer held for review")
+)
+
+// TransferRuleError ルールで振込が実行されなかった理由
+//
+// 審査待ちの場合はTransferReviewを作成済みなので、呼び出し元はロールバックせずにコミットする。
+type TransferRuleError struct {
+	Decision RuleDecision
+	Hits     []RuleHit
+	ReviewID uint
+}
+
+func (e *TransferRuleError) Error() string {
+	reasons := make([]string, len(e.Hits))
+	for i, hit := range e.Hits {
+		reasons[i] = hit.Rule + ": " + hit.Reason
+	}
+	return fmt.Sprintf("%v: %s", e.Unwrap(), strings.Join(reasons, "; "))
+}
+
+func (e *TransferRuleError) Unwrap() error {
+	if e.Decision == DecisionDeny {
+		return ErrTransferDenied
+	}
+	return ErrTransferHeld
+}
+
+// startOfDay 日本時間の今日の0時
+func startOfDay(t time.Time) time.Time {
+	t = t.In(scheduleLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, scheduleLocation)
+}
+
+// collectTransferFacts 送金元の振込履歴を集める（審査待ちの振込も含める）
+func collectTransferFacts(tx *gorm.DB, from, to *Account, amount int64, now time.Time, velocityWindow time.Duration) (*TransferFacts, error) {
+	facts := &TransferFacts{From: from, To: to, Amount: amount, Now: now}
+
+	today := startOfDay(now)
+	month := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, scheduleLocation)
+	const outgoing = `SELECT amount, to_account_id, created_at FROM transactions
+			WHERE from_account_id = @from AND transaction_type = @transfer AND status = @completed AND created_at >= @since
+		UNION ALL
+		SELECT amount, to_account_id, created_at FROM transfer_reviews
+			WHERE from_account_id = @from AND status = @pending AND created_at >= @since`
+	args := map[string]interface{}{
+		"from": from.ID, "transfer": TransactionTypeTransfer, "completed": TransactionStatusCompleted, "pending": ReviewStatusPending,
+		"since": month, "today": today,
+	}
+	if err := tx.Raw(`SELECT
+			COALESCE(SUM(amount), 0),
+			COALESCE(SUM(amount) FILTER (WHERE created_at >= @today), 0)
+		FROM (`+outgoing+`) t`, args).
+		Row().Scan(&facts.SentThisMonth, &facts.SentToday); err != nil {
+		return nil, fmt.Errorf("failed to sum transfers: %w", err)
+	}
+
+	if velocityWindow > 0 {
+		args["since"] = now.Add(-velocityWindow)
+		rows, err := tx.Raw(`SELECT created_at FROM (`+outgoing+`) t ORDER BY created_at`, args).Rows()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load recent transfers: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var at time.Time
+			if err := rows.Scan(&at); err != nil {
+				return nil, err
+			}
+			facts.Recent = append(facts.Recent, at)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var first *time.Time
+	if err := tx.Raw(`SELECT MIN(created_at) FROM transactions
+		WHERE from_account_id = ? AND to_account_id = ? AND transaction_type = ? AND status = ?`,
+		from.ID, to.ID, TransactionTypeTransfer, TransactionStatusCompleted).Row().Scan(&first); err != nil {
+		return nil, fmt.Errorf("failed to find first transfer to payee: %w", err)
+	}
+	facts.FirstToPayee = first
+	return facts, nil
+}
+
+// enforceTransferRules 振込ルールを判定し、拒否・審査待ちなら*TransferRuleErrorを返す
+//
+// 送金元の口座をロックした後に呼ぶ。審査待ちの場合はtxにTransferReviewを作成する。
+func enforceTransferRules(tx *gorm.DB, from, to *Account, amount int64) error {
+	if from.IsSystem {
+		return nil
+	}
+	policy, window, err := loadTransferPolicy(tx, accountCurrency(from))
+	if err != nil {
+		return err
+	}
+	if len(policy.rules) == 0 {
+		return nil
+	}
+	facts, err := collectTransferFacts(tx, from, to, amount, time.Now(), window)
+	if err != nil {
+		return err
+	}
+
+	decision, hits := policy.Evaluate(facts)
+	if decision == DecisionAllow {
+		return nil
+	}
+	ruleErr := &TransferRuleError{Decision: decision, Hits: hits}
+	if decision == DecisionReview {
+		reasons, err := json.Marshal(hits)
+		if err != nil {
+			return err
+		}
+		review := TransferReview{
+			FromAccountID: from.ID,
+			ToAccountID:   to.ID,
+			Amount:        amount,
+			Currency:      string(accountCurrency(from)),
+			Status:        ReviewStatusPending,
+			Reasons:       string(reasons),
+		}
+		if err := tx.Create(&review).Error; err != nil {
+			return fmt.Errorf("failed to queue transfer for review: %w", err)
+		}
+		ruleErr.ReviewID = review.ID
+	}
+	return ruleErr
+}
+
+// transferRuleResponse 拒否・審査待ちのレスポンス（ルールによるものでなければfalse）
+func transferRuleResponse(err error) (int, TransferResponse, bool) {
+	var ruleErr *TransferRuleError
+	if !errors.As(err, &ruleErr) {
+		return 0, TransferResponse{}, false
+	}
+	if ruleErr.Decision == DecisionDeny {
+		return http.StatusForbidden, TransferResponse{Success: false, Message: ruleErr.Error()}, true
+	}
+	return http.StatusAccepted, TransferResponse{Success: false, Message: ruleErr.Error(), ReviewID: ruleErr.ReviewID}, true
+}
+
+// writeTransferRuleError 拒否は403、審査待ちは202で返す（ルールによるエラーでなければfalse）
+func writeTransferRuleError(w http.ResponseWriter, err error) bool {
+	status, resp, ok := transferRuleResponse(err)
+	if !ok {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	jsonResponse(w, resp)
+	return true
+}
+
+// TransferRulesHandler 振込ルールの一覧（GET）・作成または更新（POST、nameで照合）
+func (h *Handler) TransferRulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var configs []TransferRuleConfig
+		if err := h.DB.WithContext(r.Context()).Order("id").Find(&configs).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, configs)
+
+	case http.MethodPost:
+		// enabledを省略した場合は有効にする
+		req := TransferRuleConfig{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if req.Currency != "" {
+			if _, err := ParseCurrency(req.Currency); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if _, err := req.rule(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var saved TransferRuleConfig
+		err := h.DB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+			err := tx.Where("name = ?", req.Name).First(&saved).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				saved = req
+				saved.ID = 0
+				return tx.Create(&saved).Error
+			}
+			if err != nil {
+				return err
+			}
+			// boolやゼロ値も上書きするためmapで更新する
+			return tx.Model(&saved).Updates(map[string]interface{}{
+				"kind":           req.Kind,
+				"decision":       req.Decision,
+				"amount":         req.Amount,
+				"count":          req.Count,
+				"window_minutes": req.WindowMinutes,
+				"currency":       req.Currency,
+				"enabled":        req.Enabled,
+			}).Error
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := h.DB.WithContext(r.Context()).First(&saved, saved.ID).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, saved)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// TransferReviewsHandler 審査キュー（GET ?status=、既定はpending）
+func (h *Handler) TransferReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = ReviewStatusPending
+	}
+	if !contains([]string{ReviewStatusPending, ReviewStatusApproved, ReviewStatusRejected}, status) {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+	var reviews []TransferReview
+	if err := h.DB.WithContext(r.Context()).Where("status = ?", status).Order("created_at, id").Limit(500).Find(&reviews).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, reviews)
+}
+
+// ReviewDecisionRequest 審査結果
+type ReviewDecisionRequest struct {
+	Approve bool   `json:"approve"`
+	Note    string `json:"note"`
+}
+
+// DecideTransferReviewHandler 審査待ちの振込を承認（振込を実行）または却下する（POST ?id=）
+func (h *Handler) DecideTransferReviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var req ReviewDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review, err := decideTransferReview(h.DB.WithContext(r.Context()), uint(id), req.Approve, truncate(req.Note, 200))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Pending review not found", http.StatusNotFound)
+	case isFxRejection(err):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case err != nil:
+		// 残高不足などで承認できなかった場合は審査待ちのまま残る
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		jsonResponse(w, review)
+	}
+}
+
+// decideTransferReview 審査待ちの振込を確定する（承認した場合はルールを再判定せずに振り込む）
+func decideTransferReview(db *gorm.DB, id uint, approve bool, note string) (*TransferReview, error) {
+	var review TransferReview
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 同じ審査を二重に承認しないようロックする
+		if err := tx.Raw("SELECT * FROM transfer_reviews WHERE id = ? AND status = ? FOR UPDATE", id, ReviewStatusPending).
+			Scan(&review).Error; err != nil {
+			return err
+		}
+		if review.ID == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		now := time.Now()
+		review.ReviewedAt = &now
+		review.Note = note
+		review.Status = ReviewStatusRejected
+		if approve {
+			from, to, err := lockTransferAccounts(tx, review.FromAccountID, review.ToAccountID)
+			if err != nil {
+				return err
+			}
+			if err := transferFunds(from, to, review.Amount); err != nil {
+				return err
+			}
+			transaction, err := postTransfer(tx, from, to, review.Amount)
+			if err != nil {
+				return err
+			}
+			review.Status = ReviewStatusApproved
+			review.TransactionID = &transaction.ID
+		}
+		return tx.Save(&review).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
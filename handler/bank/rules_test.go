@@ -0,0 +1,232 @@
+package bank
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestTransferPolicy_Evaluate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, scheduleLocation)
+	policy := NewTransferPolicy()
+	policy.Add("daily", DailyLimitRule{Limit: 100000, Decision: DecisionDeny})
+	policy.Add("monthly", MonthlyLimitRule{Limit: 500000, Decision: DecisionDeny})
+	policy.Add("velocity", VelocityRule{MaxCount: 3, Window: 10 * time.Minute, Decision: DecisionReview})
+	policy.Add("new-payee", NewPayeeRule{CoolingPeriod: 24 * time.Hour, Limit: 10000, Decision: DecisionReview})
+	policy.Add("large", LargeAmountRule{Threshold: 50000, Decision: DecisionReview})
+
+	known := now.Add(-48 * time.Hour)
+	recent := now.Add(-time.Hour)
+	tests := []struct {
+		name  string
+		facts TransferFacts
+		want  RuleDecision
+		rules []string
+	}{
+		{"allowed", TransferFacts{Amount: 1000, FirstToPayee: &known}, DecisionAllow, nil},
+		{"daily limit", TransferFacts{Amount: 1000, SentToday: 99500, SentThisMonth: 99500, FirstToPayee: &known}, DecisionDeny, []string{"daily"}},
+		{"monthly limit", TransferFacts{Amount: 1000, SentThisMonth: 499500, FirstToPayee: &known}, DecisionDeny, []string{"monthly"}},
+		{
+			"velocity counts only the window",
+			TransferFacts{Amount: 1000, FirstToPayee: &known, Recent: []time.Time{now.Add(-20 * time.Minute), now.Add(-5 * time.Minute), now.Add(-time.Minute)}},
+			DecisionAllow, nil,
+		},
+		{
+			"velocity",
+			TransferFacts{Amount: 1000, FirstToPayee: &known, Recent: []time.Time{now.Add(-9 * time.Minute), now.Add(-5 * time.Minute), now.Add(-time.Minute)}},
+			DecisionReview, []string{"velocity"},
+		},
+		{"new payee within limit", TransferFacts{Amount: 10000}, DecisionAllow, nil},
+		{"new payee", TransferFacts{Amount: 10001}, DecisionReview, []string{"new-payee"}},
+		{"payee still cooling", TransferFacts{Amount: 20000, FirstToPayee: &recent}, DecisionReview, []string{"new-payee"}},
+		{"deny wins over review", TransferFacts{Amount: 60000, SentToday: 50000, FirstToPayee: &known}, DecisionDeny, []string{"daily", "large"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			facts := tt.facts
+			facts.To = &Account{ID: 2}
+			facts.Now = now
+			got, hits := policy.Evaluate(&facts)
+			if got != tt.want {
+				t.Errorf("decision = %s; expected %s (hits %+v)", got, tt.want, hits)
+			}
+			var rules []string
+			for _, hit := range hits {
+				rules = append(rules, hit.Rule)
+			}
+			if len(rules) != len(tt.rules) {
+				t.Fatalf("hits = %v; expected %v", rules, tt.rules)
+			}
+			for i := range rules {
+				if rules[i] != tt.rules[i] {
+					t.Errorf("hits = %v; expected %v", rules, tt.rules)
+				}
+			}
+		})
+	}
+}
+
+func TestTransferRuleConfig_Rule(t *testing.T) {
+	valid := []TransferRuleConfig{
+		{Kind: RuleKindDailyLimit, Decision: "deny", Amount: 1},
+		{Kind: RuleKindVelocity, Decision: "review", Count: 5, WindowMinutes: 10},
+		{Kind: RuleKindNewPayee, Decision: "review", Amount: 0, WindowMinutes: 60},
+	}
+	for _, c := range valid {
+		if _, err := c.rule(); err != nil {
+			t.Errorf("%+v: %v", c, err)
+		}
+	}
+	invalid := []TransferRuleConfig{
+		{Kind: RuleKindDailyLimit, Decision: "allow", Amount: 1},
+		{Kind: RuleKindMonthlyLimit, Decision: "deny"},
+		{Kind: RuleKindVelocity, Decision: "deny", Count: 5},
+		{Kind: "unknown", Decision: "deny", Amount: 1},
+	}
+	for _, c := range invalid {
+		if _, err := c.rule(); err == nil {
+			t.Errorf("%+v: expected an error", c)
+		}
+	}
+}
+
+// 審査待ちの振込は残高を動かさず、承認すると振り込まれること。日次限度額を超える振込は拒否されること
+func TestEnforceTransferRules(t *testing.T) {
+	db := openTestDB(t)
+	// 他のテストに影響しないよう、KWDの口座だけを対象にする
+	rules := []TransferRuleConfig{
+		{Name: t.Name() + "-large", Kind: RuleKindLargeAmount, Decision: "review", Amount: 50000, Currency: "KWD", Enabled: true},
+		{Name: t.Name() + "-daily", Kind: RuleKindDailyLimit, Decision: "deny", Amount: 80000, Currency: "KWD", Enabled: true},
+	}
+	for i := range rules {
+		db.Where("name = ?", rules[i].Name).Delete(&TransferRuleConfig{})
+		if err := db.Create(&rules[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Where("currency = ? AND name LIKE ?", "KWD", t.Name()+"-%").Delete(&TransferRuleConfig{})
+	})
+
+	from := createCurrencyAccount(t, db, CurrencyKWD, 1000000)
+	to := createCurrencyAccount(t, db, CurrencyKWD, 0)
+	ctx := context.Background()
+
+	_, _, _, err := executeTransferWithLockOrder(ctx, db, from.ID, to.ID, 60000)
+	var ruleErr *TransferRuleError
+	if !errors.Is(err, ErrTransferHeld) || !errors.As(err, &ruleErr) || ruleErr.ReviewID == 0 {
+		t.Fatalf("err = %v; expected a held transfer", err)
+	}
+	assertLedgerBalance(t, db, to.ID, 0)
+
+	// 審査待ちの60000も日次の合計に含まれる
+	if _, _, _, err := executeTransferWithLockOrder(ctx, db, from.ID, to.ID, 30000); !errors.Is(err, ErrTransferDenied) {
+		t.Fatalf("err = %v; expected denied", err)
+	}
+	if _, _, _, err := executeTransferWithLockOrder(ctx, db, from.ID, to.ID, 20000); err != nil {
+		t.Fatalf("transfer within limits: %v", err)
+	}
+
+	review, err := decideTransferReview(db, ruleErr.ReviewID, true, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.Status != ReviewStatusApproved || review.TransactionID == nil {
+		t.Fatalf("review = %+v", review)
+	}
+	assertLedgerBalance(t, db, to.ID, 80000)
+
+	if _, err := decideTransferReview(db, ruleErr.ReviewID, true, ""); err == nil {
+		t.Error("approving twice should fail")
+	}
+}
+
+// 楽観ロックとSERIALIZABLEの振込でも振込ルールが適用されること
+func TestEnforceTransferRules_OtherPaths(t *testing.T) {
+	db := openTestDB(t)
+	rule := TransferRuleConfig{Name: t.Name() + "-daily", Kind: RuleKindDailyLimit, Decision: "deny", Amount: 1000, Currency: "KWD", Enabled: true}
+	db.Where("name = ?", rule.Name).Delete(&TransferRuleConfig{})
+	if err := db.Create(&rule).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("name = ?", rule.Name).Delete(&TransferRuleConfig{})
+	})
+	ctx := context.Background()
+
+	t.Run("optimistic", func(t *testing.T) {
+		from := createCurrencyAccount(t, db, CurrencyKWD, 100000)
+		to := createCurrencyAccount(t, db, CurrencyKWD, 0)
+		if _, _, _, _, err := transferOptimisticWithRetry(ctx, db, from.ID, to.ID, 5000); !errors.Is(err, ErrTransferDenied) {
+			t.Fatalf("err = %v; expected denied", err)
+		}
+		assertLedgerBalance(t, db, from.ID, 100000)
+		assertLedgerBalance(t, db, to.ID, 0)
+	})
+
+	t.Run("serializable", func(t *testing.T) {
+		from := createCurrencyAccount(t, db, CurrencyKWD, 100000)
+		to := createCurrencyAccount(t, db, CurrencyKWD, 0)
+		var stats retryStats
+		_, err := runWithRetry(ctx, db, sql.LevelSerializable, &stats, func(tx *gorm.DB) error {
+			_, _, _, err := serializableTransfer(tx, from.ID, to.ID, 5000)
+			return err
+		})
+		if !errors.Is(err, ErrTransferDenied) {
+			t.Fatalf("err = %v; expected denied", err)
+		}
+		assertLedgerBalance(t, db, from.ID, 100000)
+		assertLedgerBalance(t, db, to.ID, 0)
+	})
+}
+
+// /api/bank/transfer への同じ口座からの同時振込が、日次限度額をすり抜けないこと
+func TestNormalTransferHandler_RulesUnderConcurrency(t *testing.T) {
+	db := openTestDB(t)
+	rule := TransferRuleConfig{Name: t.Name() + "-daily", Kind: RuleKindDailyLimit, Decision: "deny", Amount: 1000, Currency: "KWD", Enabled: true}
+	db.Where("name = ?", rule.Name).Delete(&TransferRuleConfig{})
+	if err := db.Create(&rule).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Where("name = ?", rule.Name).Delete(&TransferRuleConfig{})
+	})
+	from := createCurrencyAccount(t, db, CurrencyKWD, 100000)
+	to := createCurrencyAccount(t, db, CurrencyKWD, 0)
+	h := &Handler{DB: db}
+
+	const n = 5
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	body := fmt.Sprintf(`{"from_account_id":%d,"to_account_id":%d,"amount":600}`, from.ID, to.ID)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			h.NormalTransferHandler(w, httptest.NewRequest(http.MethodPost, "/api/bank/transfer", strings.NewReader(body)))
+			var resp TransferResponse
+			if w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &resp) == nil && resp.Success {
+				succeeded.Add(1)
+			} else if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d: %s", w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	if got := succeeded.Load(); got != 1 {
+		t.Fatalf("succeeded = %d; expected only 1 transfer within the daily limit", got)
+	}
+	assertLedgerBalance(t, db, to.ID, 600)
+}
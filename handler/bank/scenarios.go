@@ -0,0 +1,169 @@
+package bank
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/keito-isurugi/go-demo/scenario"
+)
+
+// bankScenarios 1リクエストで実行できるシナリオ（名前 -> 分離レベルを受け取って組み立てる関数）
+//
+// dirty-read・phantom-read・deadlockは、2本のcurlをsleepの間に打つ従来のデモ（demo.go）を
+// ステップ実行で決定的に再現したもの。
+var bankScenarios = map[string]func(isolation sql.IsolationLevel) scenario.Scenario{
+	"lost-update":         lostUpdateScenario,
+	"non-repeatable-read": nonRepeatableReadScenario,
+	"read-skew":           readSkewScenario,
+	"write-skew":          writeSkewScenario,
+	"dirty-read":          dirtyReadScenario,
+	"phantom-read":        phantomReadScenario,
+	"deadlock":            deadlockScenario,
+}
+
+func dirtyReadScenario(isolation sql.IsolationLevel) scenario.Scenario {
+	setup, teardown := demoAccounts(demoPrefix("dirty-read"), map[string]int64{"X": 10000})
+	return scenario.Scenario{
+		Name:        "dirty-read",
+		Description: "T1が残高を更新したままコミットせず、その間にT2が読む。T1は最後にロールバックする",
+		Isolation:   isolation,
+		Setup:       setup,
+		Teardown:    teardown,
+		Steps: []scenario.Step{
+			scenario.Exec("T1", "X += 10000 (uncommitted)", "UPDATE isolation_demo_accounts SET balance = balance + 10000 WHERE id = $1", scenario.Var("X")),
+			scenario.Query("T2", "read X", "T2.X", selectDemoBalance, scenario.Var("X")),
+			scenario.Rollback("T1"),
+			scenario.Commit("T2"),
+		},
+		Check: func(ctx context.Context, db *sql.DB, vars *scenario.Vars, result *scenario.Result) (bool, string, error) {
+			if seen := vars.Get("T2.X"); seen != 10000 {
+				return true, fmt.Sprintf("T2 read X = %d, a value that was rolled back", seen), nil
+			}
+			// PostgreSQLのREAD UNCOMMITTEDはREAD COMMITTEDとして動くため、Dirty Readは起きない
+			return false, "T2 read the committed value X = 10000", nil
+		},
+	}
+}
+
+func phantomReadScenario(isolation sql.IsolationLevel) scenario.Scenario {
+	prefix := demoPrefix("phantom-read")
+	setup, teardown := demoAccounts(prefix, map[string]int64{"X": 60000, "Y": 30000})
+	const countSQL = "SELECT COUNT(*) FROM isolation_demo_accounts WHERE name LIKE $1 AND balance >= 50000"
+	return scenario.Scenario{
+		Name:        "phantom-read",
+		Description: "T1が残高50000以上の口座を2回数える間に、T2が条件に合う口座を追加してコミットする",
+		Isolation:   isolation,
+		Setup:       setup,
+		Teardown:    teardown,
+		Steps: []scenario.Step{
+			scenario.Query("T1", "count balance >= 50000", "T1.count1", countSQL, prefix+":%"),
+			scenario.Exec("T2", "insert account with 150000",
+				"INSERT INTO isolation_demo_accounts (name, balance, created_at) VALUES ($1, 150000, NOW())", prefix+":phantom"),
+			scenario.Commit("T2"),
+			scenario.Query("T1", "count balance >= 50000 again", "T1.count2", countSQL, prefix+":%"),
+			scenario.Commit("T1"),
+		},
+		Check: func(ctx context.Context, db *sql.DB, vars *scenario.Vars, result *scenario.Result) (bool, string, error) {
+			first, second := vars.Get("T1.count1"), vars.Get("T1.count2")
+			if second > first {
+				return true, fmt.Sprintf("T1 counted %d, then %d rows for the same condition", first, second), nil
+			}
+			return false, fmt.Sprintf("T1 counted %d rows both times", first), nil
+		},
+	}
+}
+
+func deadlockScenario(isolation sql.IsolationLevel) scenario.Scenario {
+	setup, teardown := demoAccounts(demoPrefix("deadlock"), map[string]int64{"X": 10000, "Y": 10000})
+	const lockSQL = selectDemoBalance + " FOR UPDATE"
+	return scenario.Scenario{
+		Name:        "deadlock",
+		Description: "T1はX→Y、T2はY→Xの順に行ロックを取る",
+		Isolation:   isolation,
+		Setup:       setup,
+		Teardown:    teardown,
+		Steps: []scenario.Step{
+			scenario.Query("T1", "lock X", "T1.X", lockSQL, scenario.Var("X")),
+			scenario.Query("T2", "lock Y", "T2.Y", lockSQL, scenario.Var("Y")),
+			scenario.Query("T1", "lock Y", "T1.Y", lockSQL, scenario.Var("Y")).Blocking(),
+			// ここで待ちが循環し、deadlock_timeout後にどちらかが40P01で中断される
+			scenario.Query("T2", "lock X", "T2.X", lockSQL, scenario.Var("X")),
+			scenario.Commit("T1"),
+			scenario.Commit("T2"),
+		},
+		Check: func(ctx context.Context, db *sql.DB, vars *scenario.Vars, result *scenario.Result) (bool, string, error) {
+			for _, tx := range []string{"T1", "T2"} {
+				if e := result.Failure(tx); e != nil && e.SQLState == sqlStateDeadlockDetected {
+					return true, fmt.Sprintf("%s was chosen as the deadlock victim at step %d", tx, e.Step), nil
+				}
+			}
+			return false, "no deadlock was detected", nil
+		},
+	}
+}
+
+// scenarioRunner GORMのコネクションプールでシナリオを実行するRunner
+func (h *Handler) scenarioRunner() (*scenario.Runner, error) {
+	sqlDB, err := h.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	if err := autoMigrate(h.DB); err != nil {
+		return nil, err
+	}
+	return &scenario.Runner{DB: sqlDB}, nil
+}
+
+// ScenarioInfo シナリオの一覧に表示する情報
+type ScenarioInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Steps       int    `json:"steps"`
+}
+
+// ScenarioHandler シナリオをステップ実行し、トレースを返す
+//
+// name省略時はシナリオの一覧。isolationの既定はread-committed。
+func (h *Handler) ScenarioHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		infos := make([]ScenarioInfo, 0, len(bankScenarios))
+		for n, build := range bankScenarios {
+			s := build(sql.LevelReadCommitted)
+			infos = append(infos, ScenarioInfo{Name: n, Description: s.Description, Steps: len(s.Steps)})
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+		jsonResponse(w, infos)
+		return
+	}
+
+	build, ok := bankScenarios[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown scenario %q", name), http.StatusBadRequest)
+		return
+	}
+	isolation := sql.LevelReadCommitted
+	if raw := r.URL.Query().Get("isolation"); raw != "" {
+		level, err := scenario.ParseIsolation(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		isolation = level
+	}
+
+	runner, err := h.scenarioRunner()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to prepare: %v", err), http.StatusInternalServerError)
+		return
+	}
+	result, err := runner.Run(r.Context(), build(isolation))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Scenario %s failed: %v", name, err), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, result)
+}
@@ -0,0 +1,481 @@
+package bank
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	demotime "github.com/keito-isurugi/go-demo/demo/time"
+	"gorm.io/gorm"
+)
+
+// 予約振込・定期振込（スタンディングオーダー）
+//
+// ワーカーは実行日時を過ぎた予約を SELECT ... FOR UPDATE SKIP LOCKED で1件ずつ確保し、
+// 振込・実行結果の記録・次回日時の更新を同じトランザクションで行う。
+// 複数のワーカー（複数のプロセス）が同時に動いても、同じ予約を二重に実行しない。
+
+// 実行頻度
+const (
+	FrequencyOnce    = "once"
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+// 予約の状態
+const (
+	ScheduleStatusActive    = "active"
+	ScheduleStatusCompleted = "completed" // 1回限りの予約を実行した、または終了日を過ぎた
+	ScheduleStatusFailed    = "failed"    // 1回限りの予約がリトライしても失敗した
+	ScheduleStatusCanceled  = "canceled"
+)
+
+// 1回の実行結果
+const (
+	RunStatusCompleted = "completed"
+	RunStatusRetrying  = "retrying" // 失敗したが、あとでリトライする
+	RunStatusFailed    = "failed"   // リトライ上限に達した（通知済み）
+	RunStatusHeld      = "held"     // 振込ルールで審査待ちになった（承認されると振り込まれる）
+)
+
+const (
+	defaultScheduleMaxAttempts = 3
+	scheduleRetryBaseDelay     = time.Minute
+	scheduleRetryMaxDelay      = time.Hour
+)
+
+// scheduleLocation 日付の繰り上げ・月末の判定に使うタイムゾーン
+var scheduleLocation = func() *time.Location {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return time.FixedZone("JST", 9*60*60)
+	}
+	return loc
+}()
+
+// ScheduledTransfer 予約振込
+type ScheduledTransfer struct {
+	ID                uint       `gorm:"primaryKey"`
+	FromAccountID     uint       `gorm:"index;not null"`
+	ToAccountID       uint       `gorm:"not null"`
+	Amount            int64      `gorm:"not null"`
+	Frequency         string     `gorm:"size:10;not null"`   // once, daily, weekly, monthly
+	Interval          int        `gorm:"not null;default:1"` // 何日・何週・何か月ごとか
+	StartAt           time.Time  `gorm:"not null"`           // 初回の実行日時（繰り返しの基準日）
+	EndAt             *time.Time // この日時より後は実行しない
+	NextRunAt         *time.Time `gorm:"index"`                  // 次の実行日時（リトライ中はリトライの日時）
+	Occurrence        int        `gorm:"not null;default:0"`     // 次に実行するのが何回目か（0始まり）
+	Status            string     `gorm:"size:20;not null;index"` // active, completed, failed, canceled
+	Attempts          int        `gorm:"not null;default:0"`     // 今回の実行の失敗回数
+	MaxAttempts       int        `gorm:"not null;default:3"`     // 1回の実行あたりの試行回数の上限
+	LastError         string     `gorm:"size:200"`
+	LastTransactionID *uint
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time `gorm:"autoUpdateTime"`
+}
+
+// ScheduledTransferRun 予約の実行結果（試行ごとに1行）
+type ScheduledTransferRun struct {
+	ID                  uint      `gorm:"primaryKey"`
+	ScheduledTransferID uint      `gorm:"index;not null"`
+	Occurrence          int       `gorm:"not null"`
+	ScheduledFor        time.Time `gorm:"not null"` // 本来の実行日時
+	Attempt             int       `gorm:"not null"`
+	Status              string    `gorm:"size:20;not null"` // completed, retrying, failed, held
+	TransactionID       *uint
+	Error               string    `gorm:"size:200"`
+	ExecutedAt          time.Time `gorm:"not null"`
+}
+
+// Notification 口座名義人への通知（予約振込が最終的に失敗した場合など）
+type Notification struct {
+	ID        uint      `gorm:"primaryKey"`
+	AccountID uint      `gorm:"index;not null"`
+	Kind      string    `gorm:"size:50;not null"`
+	Message   string    `gorm:"size:500;not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// NotificationKindScheduledTransferFailed 予約振込の失敗通知
+const NotificationKindScheduledTransferFailed = "scheduled_transfer_failed"
+
+// occurrenceAt n回目（0始まり）の本来の実行日時
+//
+// 毎回StartAtから計算するため、1月31日開始の毎月振込は 2月29日 → 3月31日 のように月末に戻る。
+func occurrenceAt(s *ScheduledTransfer, n int) time.Time {
+	start := s.StartAt.In(scheduleLocation)
+	interval := s.Interval
+	if interval <= 0 {
+		interval = 1
+	}
+	switch s.Frequency {
+	case FrequencyDaily:
+		return start.AddDate(0, 0, n*interval)
+	case FrequencyWeekly:
+		return start.AddDate(0, 0, 7*n*interval)
+	case FrequencyMonthly:
+		return demotime.AddMonthsPreservingEndOfMonth(start, n*interval)
+	default:
+		return start
+	}
+}
+
+// scheduleRetryDelay attempt回目の失敗の後、次に試すまでの時間
+func scheduleRetryDelay(attempt int) time.Duration {
+	delay := scheduleRetryBaseDelay
+	for i := 1; i < attempt && delay < scheduleRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, scheduleRetryMaxDelay)
+}
+
+// permanentScheduleError リトライしても結果が変わらないエラー
+func permanentScheduleError(err error) bool {
+	return errors.Is(err, ErrAccountNotFound) || errors.Is(err, ErrTransferDenied) || isFxRejection(err)
+}
+
+// advanceSchedule 次の実行に進める（もう実行しない場合は完了にする）
+func advanceSchedule(s *ScheduledTransfer) {
+	s.Occurrence++
+	s.Attempts = 0
+	if s.Frequency == FrequencyOnce {
+		s.NextRunAt = nil
+		s.Status = ScheduleStatusCompleted
+		return
+	}
+	next := occurrenceAt(s, s.Occurrence)
+	if s.EndAt != nil && next.After(*s.EndAt) {
+		s.NextRunAt = nil
+		s.Status = ScheduleStatusCompleted
+		return
+	}
+	s.NextRunAt = &next
+}
+
+// processNextScheduledTransfer 実行日時を過ぎた予約を1件確保して実行する（無ければnilを返す）
+func processNextScheduledTransfer(ctx context.Context, db *gorm.DB, now time.Time) (*ScheduledTransferRun, error) {
+	var run *ScheduledTransferRun
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 他のワーカーが確保中の予約は飛ばす
+		var s ScheduledTransfer
+		result := tx.Raw(`SELECT * FROM scheduled_transfers
+			WHERE status = ? AND next_run_at <= ?
+			ORDER BY next_run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED`, ScheduleStatusActive, now).Scan(&s)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		run = &ScheduledTransferRun{
+			ScheduledTransferID: s.ID,
+			Occurrence:          s.Occurrence,
+			ScheduledFor:        occurrenceAt(&s, s.Occurrence),
+			Attempt:             s.Attempts + 1,
+			ExecutedAt:          now,
+		}
+
+		// 振込が失敗しても予約の更新はコミットしたいので、振込はセーブポイントの中で行う
+		// （審査待ちになった場合は作成した審査を残すため、セーブポイントをロールバックしない）
+		var transaction *Transaction
+		var held *TransferRuleError
+		transferErr := tx.Transaction(func(sp *gorm.DB) error {
+			var err error
+			_, _, transaction, err = transferWithLockOrder(sp, s.FromAccountID, s.ToAccountID, s.Amount)
+			if errors.Is(err, ErrTransferHeld) && errors.As(err, &held) {
+				return nil
+			}
+			return err
+		})
+
+		switch {
+		case held != nil:
+			// 今回分は審査に回したので次回に進む
+			run.Status = RunStatusHeld
+			run.Error = truncate(fmt.Sprintf("review #%d: %v", held.ReviewID, held), 200)
+			s.LastError = ""
+			advanceSchedule(&s)
+
+		case transferErr == nil:
+			run.Status = RunStatusCompleted
+			run.TransactionID = &transaction.ID
+			s.LastTransactionID = &transaction.ID
+			s.LastError = ""
+			advanceSchedule(&s)
+
+		case run.Attempt < s.MaxAttempts && !permanentScheduleError(transferErr):
+			run.Status = RunStatusRetrying
+			run.Error = truncate(transferErr.Error(), 200)
+			s.Attempts = run.Attempt
+			s.LastError = run.Error
+			retryAt := now.Add(scheduleRetryDelay(run.Attempt))
+			s.NextRunAt = &retryAt
+
+		default:
+			run.Status = RunStatusFailed
+			run.Error = truncate(transferErr.Error(), 200)
+			s.LastError = run.Error
+			if err := tx.Create(&Notification{
+				AccountID: s.FromAccountID,
+				Kind:      NotificationKindScheduledTransferFailed,
+				Message: truncate(fmt.Sprintf("予約振込 #%d（%s 実行予定、金額 %d）が%d回失敗しました: %s",
+					s.ID, run.ScheduledFor.Format("2006-01-02 15:04"), s.Amount, run.Attempt, run.Error), 500),
+			}).Error; err != nil {
+				return fmt.Errorf("failed to create notification: %w", err)
+			}
+			log.Printf("scheduled transfer %d failed permanently (occurrence %d): %v", s.ID, s.Occurrence, transferErr)
+			// 定期振込は今回分を諦めて次回に進み、1回限りの予約は失敗で終える
+			advanceSchedule(&s)
+			if s.Frequency == FrequencyOnce {
+				s.Status = ScheduleStatusFailed
+			}
+		}
+
+		if err := tx.Create(run).Error; err != nil {
+			return fmt.Errorf("failed to record run: %w", err)
+		}
+		return tx.Save(&s).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// ProcessDueScheduledTransfers 実行日時を過ぎた予約を最大limit件実行する
+func ProcessDueScheduledTransfers(ctx context.Context, db *gorm.DB, now time.Time, limit int) ([]ScheduledTransferRun, error) {
+	runs := []ScheduledTransferRun{}
+	for len(runs) < limit {
+		run, err := processNextScheduledTransfer(ctx, db, now)
+		if err != nil {
+			return runs, err
+		}
+		if run == nil {
+			break
+		}
+		runs = append(runs, *run)
+	}
+	return runs, nil
+}
+
+// RunScheduledTransferWorker intervalごとに実行日時を過ぎた予約を実行する
+func (h *Handler) RunScheduledTransferWorker(ctx context.Context, interval time.Duration, batch int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			runs, err := ProcessDueScheduledTransfers(ctx, h.DB, time.Now(), batch)
+			if err != nil {
+				// テーブル作成前（/api/bank/init前）は失敗するので次回に回す
+				log.Printf("scheduled transfer worker failed: %v", err)
+				continue
+			}
+			if len(runs) > 0 {
+				log.Printf("processed %d scheduled transfers", len(runs))
+			}
+		}
+	}
+}
+
+// ScheduledTransferRequest 予約振込の登録リクエスト
+type ScheduledTransferRequest struct {
+	FromAccountID uint       `json:"from_account_id"`
+	ToAccountID   uint       `json:"to_account_id"`
+	Amount        int64      `json:"amount"`
+	Frequency     string     `json:"frequency"` // once（既定）, daily, weekly, monthly
+	Interval      int        `json:"interval"`  // 既定1
+	StartAt       *time.Time `json:"start_at"`  // 省略時は現在時刻
+	EndAt         *time.Time `json:"end_at"`
+	MaxAttempts   int        `json:"max_attempts"` // 既定3
+}
+
+func (req *ScheduledTransferRequest) validate() error {
+	if req.Frequency == "" {
+		req.Frequency = FrequencyOnce
+	}
+	switch req.Frequency {
+	case FrequencyOnce, FrequencyDaily, FrequencyWeekly, FrequencyMonthly:
+	default:
+		return fmt.Errorf("invalid frequency %q (once, daily, weekly, monthly)", req.Frequency)
+	}
+	if req.Interval == 0 {
+		req.Interval = 1
+	}
+	if req.Interval < 1 || req.Interval > 365 {
+		return errors.New("interval must be 1-365")
+	}
+	if req.MaxAttempts == 0 {
+		req.MaxAttempts = defaultScheduleMaxAttempts
+	}
+	if req.MaxAttempts < 1 || req.MaxAttempts > 10 {
+		return errors.New("max_attempts must be 1-10")
+	}
+	if req.Amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if req.FromAccountID == 0 || req.ToAccountID == 0 || req.FromAccountID == req.ToAccountID {
+		return errors.New("from_account_id and to_account_id must be different accounts")
+	}
+	if req.StartAt == nil {
+		now := time.Now()
+		req.StartAt = &now
+	}
+	if req.EndAt != nil && req.EndAt.Before(*req.StartAt) {
+		return errors.New("end_at must not be before start_at")
+	}
+	return nil
+}
+
+// ScheduledTransfersHandler 予約振込の一覧（GET ?account_id=, ?id=で実行履歴も返す）と登録（POST）
+func (h *Handler) ScheduledTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	db := h.DB.WithContext(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		if raw := r.URL.Query().Get("id"); raw != "" {
+			id, err := strconv.ParseUint(raw, 10, 32)
+			if err != nil {
+				http.Error(w, "Invalid id", http.StatusBadRequest)
+				return
+			}
+			var s ScheduledTransfer
+			if err := db.First(&s, id).Error; err != nil {
+				http.Error(w, fmt.Sprintf("Scheduled transfer not found: %v", err), http.StatusNotFound)
+				return
+			}
+			runs := []ScheduledTransferRun{}
+			if err := db.Where("scheduled_transfer_id = ?", s.ID).Order("id DESC").Limit(100).Find(&runs).Error; err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jsonResponse(w, map[string]interface{}{"scheduled_transfer": s, "runs": runs})
+			return
+		}
+
+		query := db.Order("id")
+		if raw := r.URL.Query().Get("account_id"); raw != "" {
+			query = query.Where("from_account_id = ? OR to_account_id = ?", raw, raw)
+		}
+		if status := r.URL.Query().Get("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		schedules := []ScheduledTransfer{}
+		if err := query.Find(&schedules).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, schedules)
+
+	case http.MethodPost:
+		var req ScheduledTransferRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := autoMigrate(h.DB); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to migrate: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		s := ScheduledTransfer{
+			FromAccountID: req.FromAccountID,
+			ToAccountID:   req.ToAccountID,
+			Amount:        req.Amount,
+			Frequency:     req.Frequency,
+			Interval:      req.Interval,
+			StartAt:       *req.StartAt,
+			EndAt:         req.EndAt,
+			NextRunAt:     req.StartAt,
+			Status:        ScheduleStatusActive,
+			MaxAttempts:   req.MaxAttempts,
+		}
+		if err := db.Create(&s).Error; err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create scheduled transfer: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		jsonResponse(w, s)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// CancelScheduledTransferHandler 予約振込を取り消す（POST ?id=）
+func (h *Handler) CancelScheduledTransferHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	// 実行中の予約は行ロックが外れるまで待ってから取り消す
+	result := h.DB.WithContext(r.Context()).Model(&ScheduledTransfer{}).
+		Where("id = ? AND status = ?", id, ScheduleStatusActive).
+		Updates(map[string]interface{}{"status": ScheduleStatusCanceled, "next_run_at": nil})
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Active scheduled transfer not found", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{"success": true, "id": id})
+}
+
+// RunDueScheduledTransfersHandler ワーカーを待たずに実行日時を過ぎた予約を実行する（POST ?limit=）
+func (h *Handler) RunDueScheduledTransfersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > 1000 {
+			http.Error(w, "Invalid limit (1-1000)", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	runs, err := ProcessDueScheduledTransfers(r.Context(), h.DB, time.Now(), limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to process scheduled transfers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, runs)
+}
+
+// NotificationsHandler 通知の一覧（?account_id=）
+func (h *Handler) NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	query := h.DB.WithContext(r.Context()).Order("id DESC").Limit(100)
+	if raw := r.URL.Query().Get("account_id"); raw != "" {
+		query = query.Where("account_id = ?", raw)
+	}
+	notifications := []Notification{}
+	if err := query.Find(&notifications).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, notifications)
+}
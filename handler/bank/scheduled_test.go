@@ -0,0 +1,185 @@
+package bank
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestOccurrenceAt(t *testing.T) {
+	jst := scheduleLocation
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 9, 0, 0, 0, jst) }
+	tests := []struct {
+		name     string
+		schedule ScheduledTransfer
+		want     []time.Time
+	}{
+		{
+			"monthly from end of January keeps end of month",
+			ScheduledTransfer{Frequency: FrequencyMonthly, StartAt: date(2024, 1, 31)},
+			[]time.Time{date(2024, 1, 31), date(2024, 2, 29), date(2024, 3, 31), date(2024, 4, 30)},
+		},
+		{
+			"monthly from the 30th returns to the 30th",
+			ScheduledTransfer{Frequency: FrequencyMonthly, StartAt: date(2023, 1, 30)},
+			[]time.Time{date(2023, 1, 30), date(2023, 2, 28), date(2023, 3, 30)},
+		},
+		{
+			"every 2 months",
+			ScheduledTransfer{Frequency: FrequencyMonthly, Interval: 2, StartAt: date(2024, 12, 31)},
+			[]time.Time{date(2024, 12, 31), date(2025, 2, 28), date(2025, 4, 30)},
+		},
+		{
+			"weekly",
+			ScheduledTransfer{Frequency: FrequencyWeekly, StartAt: date(2024, 2, 26)},
+			[]time.Time{date(2024, 2, 26), date(2024, 3, 4)},
+		},
+		{
+			"every 3 days",
+			ScheduledTransfer{Frequency: FrequencyDaily, Interval: 3, StartAt: date(2024, 2, 27)},
+			[]time.Time{date(2024, 2, 27), date(2024, 3, 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for n, want := range tt.want {
+				if got := occurrenceAt(&tt.schedule, n); !got.Equal(want) {
+					t.Errorf("occurrence %d = %v; expected %v", n, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAdvanceSchedule(t *testing.T) {
+	start := time.Date(2024, 1, 31, 9, 0, 0, 0, scheduleLocation)
+	end := time.Date(2024, 3, 1, 0, 0, 0, 0, scheduleLocation)
+	s := ScheduledTransfer{Frequency: FrequencyMonthly, StartAt: start, EndAt: &end, Status: ScheduleStatusActive, Attempts: 2}
+
+	advanceSchedule(&s)
+	if s.Status != ScheduleStatusActive || s.NextRunAt == nil || s.NextRunAt.Day() != 29 || s.Attempts != 0 {
+		t.Fatalf("after 1st run: %+v", s)
+	}
+	advanceSchedule(&s)
+	if s.Status != ScheduleStatusCompleted || s.NextRunAt != nil {
+		t.Fatalf("after end_at: status = %s, next = %v", s.Status, s.NextRunAt)
+	}
+
+	once := ScheduledTransfer{Frequency: FrequencyOnce, StartAt: start, Status: ScheduleStatusActive}
+	advanceSchedule(&once)
+	if once.Status != ScheduleStatusCompleted || once.NextRunAt != nil {
+		t.Fatalf("once: status = %s, next = %v", once.Status, once.NextRunAt)
+	}
+}
+
+func TestScheduleRetryDelay(t *testing.T) {
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, w := range want {
+		if got := scheduleRetryDelay(i + 1); got != w {
+			t.Errorf("scheduleRetryDelay(%d) = %v; expected %v", i+1, got, w)
+		}
+	}
+	if got := scheduleRetryDelay(30); got != scheduleRetryMaxDelay {
+		t.Errorf("scheduleRetryDelay(30) = %v; expected %v", got, scheduleRetryMaxDelay)
+	}
+}
+
+// 複数のワーカーが同時に処理しても、各予約は1回ずつしか実行されないこと
+func TestProcessDueScheduledTransfers_SkipLocked(t *testing.T) {
+	db := openTestDB(t)
+	from := createTestAccount(t, db, AccountTypeChecking, 100000)
+	to := createTestAccount(t, db, AccountTypeChecking, 0)
+
+	past := time.Now().Add(-time.Hour)
+	const schedules = 20
+	for i := 0; i < schedules; i++ {
+		s := ScheduledTransfer{FromAccountID: from.ID, ToAccountID: to.ID, Amount: 100, Frequency: FrequencyOnce,
+			StartAt: past, NextRunAt: &past, Status: ScheduleStatusActive, MaxAttempts: 3}
+		if err := db.Create(&s).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	executed := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runs, err := ProcessDueScheduledTransfers(context.Background(), db, now, schedules)
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, r := range runs {
+				if r.Status == RunStatusCompleted {
+					executed++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if executed < schedules {
+		t.Errorf("executed %d of %d schedules", executed, schedules)
+	}
+	assertLedgerBalance(t, db, to.ID, 100*schedules)
+}
+
+// 残高不足はmax_attemptsまでリトライし、最後に通知して失敗にすること
+func TestProcessDueScheduledTransfers_RetryThenFail(t *testing.T) {
+	db := openTestDB(t)
+	from := createTestAccount(t, db, AccountTypeChecking, 0)
+	to := createTestAccount(t, db, AccountTypeChecking, 0)
+
+	start := time.Now().Add(-time.Hour)
+	s := ScheduledTransfer{FromAccountID: from.ID, ToAccountID: to.ID, Amount: 1_000_000, Frequency: FrequencyOnce,
+		StartAt: start, NextRunAt: &start, Status: ScheduleStatusActive, MaxAttempts: 2}
+	if err := db.Create(&s).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if run := processScheduledTransferFor(t, db, s.ID, now); run.Status != RunStatusRetrying {
+		t.Fatalf("1st attempt = %+v; expected retrying", run)
+	}
+	db.First(&s, s.ID)
+	if s.Status != ScheduleStatusActive || s.NextRunAt == nil || !s.NextRunAt.After(now) {
+		t.Fatalf("after retry: %+v", s)
+	}
+
+	if run := processScheduledTransferFor(t, db, s.ID, s.NextRunAt.Add(time.Second)); run.Status != RunStatusFailed {
+		t.Fatalf("2nd attempt = %+v; expected failed", run)
+	}
+	db.First(&s, s.ID)
+	if s.Status != ScheduleStatusFailed {
+		t.Errorf("status = %s; expected failed", s.Status)
+	}
+	var notifications int64
+	db.Model(&Notification{}).Where("account_id = ? AND kind = ?", from.ID, NotificationKindScheduledTransferFailed).Count(&notifications)
+	if notifications != 1 {
+		t.Errorf("notifications = %d; expected 1", notifications)
+	}
+}
+
+// processScheduledTransferFor 期限の来た予約をすべて処理し、idの予約の実行結果を返す（他のテストの予約が残っていてもよいように）
+func processScheduledTransferFor(t *testing.T, db *gorm.DB, id uint, now time.Time) *ScheduledTransferRun {
+	t.Helper()
+	runs, err := ProcessDueScheduledTransfers(context.Background(), db, now, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range runs {
+		if runs[i].ScheduledTransferID == id {
+			return &runs[i]
+		}
+	}
+	t.Fatalf("scheduled transfer %d was not processed", id)
+	return nil
+}
@@ -0,0 +1,243 @@
+package bank
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// リトライ対象のSQLSTATE
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+const (
+	serializableMaxAttempts = 8
+	serializableBaseDelay   = 10 * time.Millisecond
+	serializableMaxDelay    = 500 * time.Millisecond
+)
+
+// ErrJointMinimumBalance 共同名義口座の残高合計が下限を下回る
+var ErrJointMinimumBalance = errors.New("combined balance of joint accounts would fall below the minimum")
+
+// retryableSQLState トランザクションをやり直せば成功しうるエラーならSQLSTATEを返す
+func retryableSQLState(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return "", false
+	}
+	switch pgErr.Code {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return pgErr.Code, true
+	}
+	return "", false
+}
+
+// retryStats リトライの統計
+type retryStats struct {
+	attempts              atomic.Int64
+	commits               atomic.Int64
+	retries               atomic.Int64
+	serializationFailures atomic.Int64
+	deadlocks             atomic.Int64
+	gaveUp                atomic.Int64
+	otherErrors           atomic.Int64
+}
+
+// RetryStats リトライの統計（APIのレスポンス用）
+type RetryStats struct {
+	Attempts              int64   `json:"attempts"`               // トランザクションの実行回数
+	Commits               int64   `json:"commits"`                // コミットできた回数
+	Retries               int64   `json:"retries"`                // やり直した回数
+	SerializationFailures int64   `json:"serialization_failures"` // SQLSTATE 40001
+	Deadlocks             int64   `json:"deadlocks"`              // SQLSTATE 40P01
+	GaveUp                int64   `json:"gave_up"`                // リトライ上限に達した回数
+	OtherErrors           int64   `json:"other_errors"`           // リトライ対象外のエラー（残高不足など）
+	RetriesPerCommit      float64 `json:"retries_per_commit"`
+}
+
+func (s *retryStats) snapshot() RetryStats {
+	stats := RetryStats{
+		Attempts:              s.attempts.Load(),
+		Commits:               s.commits.Load(),
+		Retries:               s.retries.Load(),
+		SerializationFailures: s.serializationFailures.Load(),
+		Deadlocks:             s.deadlocks.Load(),
+		GaveUp:                s.gaveUp.Load(),
+		OtherErrors:           s.otherErrors.Load(),
+	}
+	if stats.Commits > 0 {
+		stats.RetriesPerCommit = float64(stats.Retries) / float64(stats.Commits)
+	}
+	return stats
+}
+
+// jitteredBackoff attempt回目の待ち時間（Full Jitter: 0〜min(max, base*2^attempt)の一様乱数）
+func jitteredBackoff(attempt int) time.Duration {
+	ceiling := serializableBaseDelay << attempt
+	if ceiling <= 0 || ceiling > serializableMaxDelay {
+		ceiling = serializableMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// runWithRetry 指定した分離レベルでfnを実行し、40001/40P01の場合はトランザクション全体をやり直す
+//
+// やり直しのたびにfnは最初から実行されるので、fnの中で読んだ値は毎回読み直される。
+// 戻り値は実行した回数。
+func runWithRetry(ctx context.Context, db *gorm.DB, isolation sql.IsolationLevel, stats *retryStats, fn func(tx *gorm.DB) error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		stats.attempts.Add(1)
+		err := db.WithContext(ctx).Transaction(fn, &sql.TxOptions{Isolation: isolation})
+		if err == nil {
+			stats.commits.Add(1)
+			return attempt, nil
+		}
+
+		code, ok := retryableSQLState(err)
+		if !ok {
+			stats.otherErrors.Add(1)
+			return attempt, err
+		}
+		if code == sqlStateDeadlockDetected {
+			stats.deadlocks.Add(1)
+		} else {
+			stats.serializationFailures.Add(1)
+		}
+		if attempt >= serializableMaxAttempts {
+			stats.gaveUp.Add(1)
+			return attempt, fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+
+		stats.retries.Add(1)
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(jitteredBackoff(attempt)):
+		}
+	}
+}
+
+// checkJointMinimum 共同名義口座から出金した後も、メンバーの残高合計が下限以上か確認
+//
+// 合計はロックを取らずに読むため、SERIALIZABLE以外では同時に出金されると両方とも通ってしまう（write skew）。
+func checkJointMinimum(tx *gorm.DB, account *Account, amount int64) error {
+	if account.JointAccountID == nil {
+		return nil
+	}
+	var joint JointAccount
+	if err := tx.First(&joint, *account.JointAccountID).Error; err != nil {
+		return fmt.Errorf("failed to get joint account: %w", err)
+	}
+	var combined int64
+	if err := tx.Raw("SELECT COALESCE(SUM(balance), 0) FROM accounts WHERE joint_account_id = ?", joint.ID).Scan(&combined).Error; err != nil {
+		return fmt.Errorf("failed to sum joint balances: %w", err)
+	}
+	if combined-amount < joint.MinCombinedBalance {
+		return fmt.Errorf("%w: %d - %d < %d", ErrJointMinimumBalance, combined, amount, joint.MinCombinedBalance)
+	}
+	return nil
+}
+
+// serializableTransfer SERIALIZABLEのトランザクション内で行う振込（行ロックは取らない）
+//
+// 振込ルールで審査待ちになった場合はErrTransferHeldを返す。審査の記録を残すには、
+// 呼び出し側でトランザクションをコミットする。
+func serializableTransfer(tx *gorm.DB, fromAccountID, toAccountID uint, amount int64) (*Account, *Account, *Transaction, error) {
+	var fromAccount, toAccount Account
+	if err := tx.First(&fromAccount, fromAccountID).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("from account not found: %w", err)
+	}
+	if err := tx.First(&toAccount, toAccountID).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("to account not found: %w", err)
+	}
+	if err := enforceTransferRules(tx, &fromAccount, &toAccount, amount); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := transferFunds(&fromAccount, &toAccount, amount); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkJointMinimum(tx, &fromAccount, amount); err != nil {
+		return nil, nil, nil, err
+	}
+	transaction, err := postTransfer(tx, &fromAccount, &toAccount, amount)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &fromAccount, &toAccount, transaction, nil
+}
+
+// SerializableTransferHandler SERIALIZABLE分離レベルでの振込（直列化できない場合は自動でやり直す）
+func (h *Handler) SerializableTransferHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var fromAccount, toAccount *Account
+	var transaction *Transaction
+	var held error
+	attempts, err := runWithRetry(r.Context(), h.DB, sql.LevelSerializable, &h.serializableStats, func(tx *gorm.DB) error {
+		var err error
+		held = nil
+		fromAccount, toAccount, transaction, err = serializableTransfer(tx, req.FromAccountID, req.ToAccountID, req.Amount)
+		if errors.Is(err, ErrTransferHeld) {
+			// 審査待ちの振込を残すためにコミットする
+			held = err
+			return nil
+		}
+		return err
+	})
+	if err == nil && held != nil {
+		err = held
+	}
+	if writeTransferRuleError(w, err) {
+		return
+	}
+	if errors.Is(err, ErrJointMinimumBalance) {
+		jsonResponse(w, TransferResponse{Success: false, Message: err.Error(), Attempts: attempts})
+		return
+	}
+	if isFxRejection(err) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if _, retryable := retryableSQLState(err); retryable {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Transaction failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse(w, TransferResponse{
+		Success:       true,
+		Message:       "Transfer completed successfully (serializable)",
+		TransactionID: transaction.ID,
+		FromBalance:   fromAccount.Balance,
+		ToBalance:     toAccount.Balance,
+		Attempts:      attempts,
+	})
+}
+
+// SerializableStatsHandler SERIALIZABLE振込のリトライ統計
+func (h *Handler) SerializableStatsHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, h.serializableStats.snapshot())
+}
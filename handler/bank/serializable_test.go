@@ -0,0 +1,68 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRetryableSQLState(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCode  string
+		retryable bool
+	}{
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, "40001", true},
+		{"deadlock", fmt.Errorf("commit: %w", &pgconn.PgError{Code: "40P01"}), "40P01", true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, "", false},
+		{"not a pg error", errors.New("boom"), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, ok := retryableSQLState(tt.err)
+			if code != tt.wantCode || ok != tt.retryable {
+				t.Errorf("retryableSQLState() = %q, %v; expected %q, %v", code, ok, tt.wantCode, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	for attempt := 1; attempt <= 20; attempt++ {
+		for i := 0; i < 100; i++ {
+			if d := jitteredBackoff(attempt); d < 0 || d > serializableMaxDelay {
+				t.Fatalf("jitteredBackoff(%d) = %v; expected 0..%v", attempt, d, serializableMaxDelay)
+			}
+		}
+	}
+}
+
+// write skewはSERIALIZABLEでだけ防がれること
+func TestRunWriteSkew(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+
+	for isolation, wantViolated := range map[string]bool{
+		"read-committed":  true,
+		"repeatable-read": true,
+		"serializable":    false,
+	} {
+		t.Run(isolation, func(t *testing.T) {
+			result, err := h.runWriteSkew(context.Background(), isolation)
+			if err != nil {
+				t.Fatalf("runWriteSkew() error = %v", err)
+			}
+			if result.InvariantViolated != wantViolated {
+				t.Errorf("InvariantViolated = %v; expected %v (final combined %d)\ntimeline: %+v",
+					result.InvariantViolated, wantViolated, result.FinalCombined, result.Timeline)
+			}
+			if isolation == "serializable" && result.RetryStats.SerializationFailures == 0 {
+				t.Errorf("expected a serialization failure, got %+v", result.RetryStats)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package bank
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultStatementLimit = 50
+	maxStatementLimit     = 500
+	// csvFlushInterval CSV出力で何行ごとにクライアントへ送るか
+	csvFlushInterval = 1000
+)
+
+// StatementQuery 明細の取得条件
+type StatementQuery struct {
+	AccountID uint       `json:"account_id"`
+	From      *time.Time `json:"from,omitempty"` // この日時以降（含む）
+	To        *time.Time `json:"to,omitempty"`   // この日時より前（含まない）
+	Limit     int        `json:"limit"`
+	Cursor    string     `json:"cursor,omitempty"`
+}
+
+// StatementLine 明細の1行（入出金どちらの取引も口座から見た向きで表す）
+type StatementLine struct {
+	TransactionID         uint      `json:"transaction_id"`
+	CreatedAt             time.Time `json:"created_at"`
+	TransactionType       string    `json:"transaction_type"`
+	Status                string    `json:"status"`
+	Direction             string    `json:"direction"` // in, out
+	CounterpartyAccountID uint      `json:"counterparty_account_id"`
+	Amount                int64     `json:"amount"`        // 入金は正、出金は負
+	BalanceAfter          *int64    `json:"balance_after"` // 取引後の残高（記帳されていないpending/failedはnull）
+}
+
+// Statement 明細APIのレスポンス
+type Statement struct {
+	AccountID  uint            `json:"account_id"`
+	AccountNo  string          `json:"account_no"`
+	OwnerName  string          `json:"owner_name"`
+	Balance    int64           `json:"balance"`
+	Query      StatementQuery  `json:"query"`
+	Lines      []StatementLine `json:"lines"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// parseStatementQuery クエリパラメータを検証する
+func parseStatementQuery(values url.Values) (StatementQuery, error) {
+	q := StatementQuery{Limit: defaultStatementLimit, Cursor: strings.TrimSpace(values.Get("cursor"))}
+
+	accountID, err := strconv.ParseUint(values.Get("account_id"), 10, 32)
+	if err != nil {
+		return q, errors.New("account_id parameter is required")
+	}
+	q.AccountID = uint(accountID)
+
+	for name, dst := range map[string]**time.Time{"from": &q.From, "to": &q.To} {
+		raw := strings.TrimSpace(values.Get(name))
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s (use RFC3339): %w", name, err)
+		}
+		*dst = &t
+	}
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return q, errors.New("from must be before to")
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxStatementLimit {
+			return q, fmt.Errorf("invalid limit (1-%d)", maxStatementLimit)
+		}
+		q.Limit = limit
+	}
+	if q.Cursor != "" {
+		if _, _, err := decodeStatementCursor(q.Cursor); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// encodeStatementCursor キーセットページネーション用のカーソル（created_at, id）
+func encodeStatementCursor(line StatementLine) string {
+	raw := fmt.Sprintf("%d:%d", line.CreatedAt.UnixNano(), line.TransactionID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeStatementCursor(cursor string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor: %w", err)
+	}
+	tsStr, idStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor: %w", err)
+	}
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return time.Unix(0, ts), uint(id), nil
+}
+
+// statementSQL 口座の取引と、元帳から求めた取引後の残高
+//
+// 残高は口座のPostingを記帳順（id順）に累積したもの。開始残高などの取引に紐付かない
+// 記帳も累積に含めるため、明細の最初の行から正しい残高になる。
+const statementSQL = `WITH running AS (
+	SELECT p.id, je.transaction_id, SUM(p.amount) OVER (ORDER BY p.id) AS balance_after
+	FROM postings p JOIN journal_entries je ON je.id = p.journal_entry_id
+	WHERE p.account_id = @account
+), per_transaction AS (
+	SELECT DISTINCT ON (transaction_id) transaction_id, balance_after
+	FROM running WHERE transaction_id IS NOT NULL
+	ORDER BY transaction_id, id DESC
+)
+SELECT t.id AS transaction_id, t.created_at, t.transaction_type, t.status,
+	CASE WHEN t.to_account_id = @account THEN 'in' ELSE 'out' END AS direction,
+	CASE WHEN t.to_account_id = @account THEN t.from_account_id ELSE t.to_account_id END AS counterparty_account_id,
+	CASE WHEN t.to_account_id = @account THEN t.to_amount ELSE -t.amount END AS amount,
+	pt.balance_after
+FROM transactions t
+LEFT JOIN per_transaction pt ON pt.transaction_id = t.id
+WHERE (t.from_account_id = @account OR t.to_account_id = @account)`
+
+// statementRows 条件に合う明細のクエリを組み立てる（desc=trueで新しい順）
+func statementRows(db *gorm.DB, q StatementQuery, desc bool) (*gorm.DB, error) {
+	sql := statementSQL
+	args := map[string]interface{}{"account": q.AccountID}
+	if q.From != nil {
+		sql += " AND t.created_at >= @from"
+		args["from"] = *q.From
+	}
+	if q.To != nil {
+		sql += " AND t.created_at < @to"
+		args["to"] = *q.To
+	}
+
+	order := " ORDER BY t.created_at, t.id"
+	if desc {
+		order = " ORDER BY t.created_at DESC, t.id DESC"
+		if q.Cursor != "" {
+			ts, id, err := decodeStatementCursor(q.Cursor)
+			if err != nil {
+				return nil, err
+			}
+			sql += " AND (t.created_at, t.id) < (@cursor_ts, @cursor_id)"
+			args["cursor_ts"], args["cursor_id"] = ts, id
+		}
+	}
+	sql += order
+	if q.Limit > 0 {
+		// 次ページの有無を判定するため1件多く取得
+		sql += fmt.Sprintf(" LIMIT %d", q.Limit+1)
+	}
+	return db.Raw(sql, args), nil
+}
+
+// StatementHandler 口座の明細（入出金両方向、取引後の残高付き）
+//
+// account_id, from, to(RFC3339), limit, cursorで絞り込み、新しい順にキーセットページネーションする。
+// format=csvの場合は期間内の全件を古い順にCSVでストリーミングする（limit, cursorは無視）。
+func (h *Handler) StatementHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseStatementQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := h.DB.WithContext(r.Context())
+	var account Account
+	if err := db.Where("id = ? AND NOT is_system", q.AccountID).First(&account).Error; err != nil {
+		http.Error(w, fmt.Sprintf("Account not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		h.streamStatementCSV(w, db, account, q)
+		return
+	}
+
+	query, err := statementRows(db, q, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lines := []StatementLine{}
+	if err := query.Scan(&lines).Error; err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch statement: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	statement := Statement{
+		AccountID: account.ID,
+		AccountNo: account.AccountNo,
+		OwnerName: account.OwnerName,
+		Balance:   account.Balance,
+		Query:     q,
+		Lines:     lines,
+	}
+	if len(lines) > q.Limit {
+		statement.Lines = lines[:q.Limit]
+		statement.NextCursor = encodeStatementCursor(statement.Lines[q.Limit-1])
+	}
+	jsonResponse(w, statement)
+}
+
+// streamStatementCSV 明細を1行ずつ読みながらCSVで書き出す（全件をメモリに載せない）
+func (h *Handler) streamStatementCSV(w http.ResponseWriter, db *gorm.DB, account Account, q StatementQuery) {
+	q.Limit, q.Cursor = 0, ""
+	query, err := statementRows(db, q, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch statement: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%s.csv"`, account.AccountNo))
+	flusher, _ := w.(http.Flusher)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"transaction_id", "created_at", "transaction_type", "status", "direction", "counterparty_account_id", "amount", "balance_after"}); err != nil {
+		return
+	}
+	n := 0
+	for rows.Next() {
+		var line StatementLine
+		if err := db.ScanRows(rows, &line); err != nil {
+			// ヘッダー送信後なのでステータスは変えられない。途中で打ち切ったことが分かる行を残す
+			_ = cw.Write([]string{"error", err.Error()})
+			break
+		}
+		balance := ""
+		if line.BalanceAfter != nil {
+			balance = strconv.FormatInt(*line.BalanceAfter, 10)
+		}
+		if err := cw.Write([]string{
+			strconv.FormatUint(uint64(line.TransactionID), 10),
+			line.CreatedAt.Format(time.RFC3339Nano),
+			line.TransactionType,
+			line.Status,
+			line.Direction,
+			strconv.FormatUint(uint64(line.CounterpartyAccountID), 10),
+			strconv.FormatInt(line.Amount, 10),
+			balance,
+		}); err != nil {
+			return
+		}
+		n++
+		if n%csvFlushInterval == 0 {
+			cw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		_ = cw.Write([]string{"error", err.Error()})
+	}
+	cw.Flush()
+}
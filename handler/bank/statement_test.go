@@ -0,0 +1,135 @@
+package bank
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestParseStatementQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"account only", "account_id=1", false},
+		{"date range", "account_id=1&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z&limit=10", false},
+		{"missing account", "from=2024-01-01T00:00:00Z", true},
+		{"from after to", "account_id=1&from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z", true},
+		{"invalid date", "account_id=1&from=2024-01-01", true},
+		{"limit too large", "account_id=1&limit=1000", true},
+		{"invalid cursor", "account_id=1&cursor=!!", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parseStatementQuery(values); (err != nil) != tt.wantErr {
+				t.Errorf("parseStatementQuery(%q) error = %v; wantErr %v", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStatementCursor_RoundTrip(t *testing.T) {
+	line := StatementLine{TransactionID: 42, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)}
+	ts, id, err := decodeStatementCursor(encodeStatementCursor(line))
+	if err != nil || !ts.Equal(line.CreatedAt) || id != 42 {
+		t.Errorf("decodeStatementCursor() = %v, %d, %v", ts, id, err)
+	}
+}
+
+func TestStatementRows_RunningBalance(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+	account := createTestAccount(t, db, AccountTypeChecking, 10000)
+
+	for _, op := range []struct {
+		transactionType string
+		amount          int64
+	}{
+		{TransactionTypeDeposit, 5000},
+		{TransactionTypeWithdrawal, 3000},
+		{TransactionTypeWithdrawal, 100000}, // 当座貸越の上限を超えるのでfailed
+		{TransactionTypeDeposit, 1000},
+	} {
+		_, _, _ = h.executeCash(context.Background(), op.transactionType, account.ID, op.amount)
+	}
+
+	query, err := statementRows(db, StatementQuery{AccountID: account.ID}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []StatementLine
+	if err := query.Scan(&lines).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		amount  int64
+		balance *int64
+	}{
+		{5000, ptr(int64(15000))},
+		{-3000, ptr(int64(12000))},
+		{-100000, nil},
+		{1000, ptr(int64(13000))},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("len(lines) = %d; expected %d", len(lines), len(expected))
+	}
+	for i, e := range expected {
+		got := lines[i]
+		if got.Amount != e.amount || (got.BalanceAfter == nil) != (e.balance == nil) || (e.balance != nil && *got.BalanceAfter != *e.balance) {
+			t.Errorf("lines[%d] = %+v; expected amount %d balance %v", i, got, e.amount, e.balance)
+		}
+	}
+}
+
+// 外貨の振込は、入金側の明細では入金側の通貨の金額になり、取引後の残高と一致すること
+func TestStatementRows_FxTransfer(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Where("base_currency IN ? AND quote_currency IN ?", []string{"EUR", "KWD"}, []string{"EUR", "KWD"}).Delete(&FxRate{})
+	db.Create(&FxRate{BaseCurrency: "EUR", QuoteCurrency: "KWD", Rate: "0.3", EffectiveFrom: time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)})
+
+	eur := createCurrencyAccount(t, db, CurrencyEUR, 100000)
+	kwd := createCurrencyAccount(t, db, CurrencyKWD, 100000)
+	// 12.35 EUR * 0.3 = 3.705 KWD
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		_, err := postFxTransfer(tx, eur, kwd, 1235, time.Date(2001, 3, 1, 0, 0, 0, 0, time.UTC))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		account *Account
+		amount  int64
+		balance int64
+	}{
+		{eur, -1235, 100000 - 1235},
+		{kwd, 3705, 100000 + 3705},
+	} {
+		query, err := statementRows(db, StatementQuery{AccountID: tt.account.ID}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []StatementLine
+		if err := query.Scan(&lines).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(lines) != 1 {
+			t.Fatalf("%s: len(lines) = %d; expected 1", tt.account.Currency, len(lines))
+		}
+		if lines[0].Amount != tt.amount || lines[0].BalanceAfter == nil || *lines[0].BalanceAfter != tt.balance {
+			t.Errorf("%s: line = %+v; expected amount %d balance %d", tt.account.Currency, lines[0], tt.amount, tt.balance)
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
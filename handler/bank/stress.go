@@ -0,0 +1,391 @@
+package bank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxStressTransfers   = 20000
+	maxStressConcurrency = 50 // PostgreSQLのmax_connectionsを超えないように
+	maxStressErrorSample = 5
+)
+
+// StressConfig ストレステストの設定
+type StressConfig struct {
+	Transfers   int   `json:"transfers"`
+	Concurrency int   `json:"concurrency"`
+	MaxAmount   int64 `json:"max_amount"`
+	Seed        int64 `json:"seed"`
+}
+
+// AccountBalance 口座と残高
+type AccountBalance struct {
+	AccountID uint   `json:"account_id"`
+	AccountNo string `json:"account_no"`
+	Balance   int64  `json:"balance"`
+}
+
+// StressDeltaMismatch 取引履歴から計算した増減と実際の残高の増減が合わない口座
+type StressDeltaMismatch struct {
+	AccountID     uint  `json:"account_id"`
+	ExpectedDelta int64 `json:"expected_delta"` // 完了した取引の入金 - 出金
+	ActualDelta   int64 `json:"actual_delta"`   // 実行後の残高 - 実行前の残高
+}
+
+// StressVerification 実行後の整合性チェック
+type StressVerification struct {
+	OK                    bool                  `json:"ok"`
+	TotalBefore           int64                 `json:"total_before"`
+	TotalAfter            int64                 `json:"total_after"`
+	Conserved             bool                  `json:"conserved"`
+	NegativeBalances      []AccountBalance      `json:"negative_balances"`
+	NegativeObserved      int64                 `json:"negative_observed"` // 振込のレスポンスで残高がマイナスだった回数
+	CompletedTransactions int64                 `json:"completed_transactions"`
+	UnpostedTransactions  int64                 `json:"unposted_transactions"` // 仕訳が金額どおりに無い完了済みの取引
+	DeltaMismatches       []StressDeltaMismatch `json:"delta_mismatches"`
+}
+
+// StressReport 振込処理ごとの結果
+type StressReport struct {
+	Strategy     string             `json:"strategy"`
+	Endpoint     string             `json:"endpoint"`
+	Transfers    int                `json:"transfers"`
+	Completed    int64              `json:"completed"`
+	Rejected     int64              `json:"rejected"`  // 残高不足
+	Deadlocks    int64              `json:"deadlocks"` // 40P01で失敗した振込（リトライで解消したものはretriesに数える）
+	Timeouts     int64              `json:"timeouts"`
+	Errors       int64              `json:"errors"`
+	Retries      int64              `json:"retries"`
+	DurationMs   int64              `json:"duration_ms"`
+	Throughput   float64            `json:"throughput_tps"`
+	ErrorSamples []string           `json:"error_samples,omitempty"`
+	Verification StressVerification `json:"verification"`
+}
+
+type stressStrategy struct {
+	name     string
+	endpoint string
+	handler  http.HandlerFunc
+}
+
+// stressStrategies 検証する振込処理
+func (h *Handler) stressStrategies() []stressStrategy {
+	return []stressStrategy{
+		{"normal", "/api/bank/transfer", h.NormalTransferHandler},
+		{"lock-order", "/api/bank/transfer-safe", h.DeadlockAvoidanceHandler},
+		{"timeout-retry", "/api/bank/transfer-timeout", h.DeadlockTimeoutHandler},
+	}
+}
+
+// stressPlan seedから振込の一覧を作る（どの振込処理にも同じ振込を同じ順で投入する）
+func stressPlan(ids []uint, config StressConfig) []TransferRequest {
+	r := rand.New(rand.NewSource(config.Seed))
+	plan := make([]TransferRequest, config.Transfers)
+	for i := range plan {
+		from := r.Intn(len(ids))
+		to := r.Intn(len(ids) - 1)
+		if to >= from {
+			to++
+		}
+		plan[i] = TransferRequest{FromAccountID: ids[from], ToAccountID: ids[to], Amount: r.Int63n(config.MaxAmount) + 1}
+	}
+	return plan
+}
+
+var attemptsPattern = regexp.MustCompile(`after (\d+) attempts`)
+
+// RunTransferStress InitAccountsHandlerと同じ口座に作り直してから、各振込処理に同時に振込を投入して整合性を検証する
+//
+// 口座・取引履歴・元帳は振込処理ごとに初期化される。
+func (h *Handler) RunTransferStress(ctx context.Context, config StressConfig, strategies []string) ([]StressReport, error) {
+	reports := []StressReport{}
+	for _, s := range h.stressStrategies() {
+		if len(strategies) > 0 && !contains(strategies, s.name) {
+			continue
+		}
+
+		accounts, err := resetAccounts(h.DB)
+		if err != nil {
+			return nil, fmt.Errorf("reset accounts: %w", err)
+		}
+		ids := make([]uint, len(accounts))
+		for i, a := range accounts {
+			ids[i] = a.ID
+		}
+		before, err := accountBalances(h.DB, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		report := runStress(ctx, s, stressPlan(ids, config), config.Concurrency)
+		if report.Verification, err = verifyStress(h.DB, before, report.Verification.NegativeObserved); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runStress 振込をconcurrency個のgoroutineで投入し、結果を分類する
+func runStress(ctx context.Context, s stressStrategy, plan []TransferRequest, concurrency int) StressReport {
+	report := StressReport{Strategy: s.name, Endpoint: s.endpoint, Transfers: len(plan)}
+	var completed, rejected, deadlocks, timeouts, errCount, retries, negative atomic.Int64
+	var next atomic.Int64
+	var mu sync.Mutex
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				n := next.Add(1) - 1
+				if n >= int64(len(plan)) {
+					return
+				}
+				body, _ := json.Marshal(plan[n])
+				req := httptest.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body)).WithContext(ctx)
+				rec := httptest.NewRecorder()
+				s.handler(rec, req)
+
+				text := rec.Body.String()
+				if m := attemptsPattern.FindStringSubmatch(text); m != nil {
+					attempts, _ := strconv.Atoi(m[1])
+					retries.Add(int64(attempts - 1))
+				}
+				if rec.Code == http.StatusOK {
+					var resp TransferResponse
+					if err := json.Unmarshal(rec.Body.Bytes(), &resp); err == nil {
+						if resp.Attempts > 1 {
+							retries.Add(int64(resp.Attempts - 1))
+						}
+						if !resp.Success {
+							rejected.Add(1)
+							continue
+						}
+						if resp.FromBalance < 0 || resp.ToBalance < 0 {
+							negative.Add(1)
+						}
+						completed.Add(1)
+						continue
+					}
+				}
+
+				lower := strings.ToLower(text)
+				switch {
+				case strings.Contains(lower, "insufficient balance"):
+					rejected.Add(1)
+					continue
+				case strings.Contains(lower, sqlStateDeadlockDetected) || strings.Contains(lower, "deadlock detected"):
+					deadlocks.Add(1)
+				case strings.Contains(lower, "deadline exceeded") || strings.Contains(lower, "canceling statement"):
+					timeouts.Add(1)
+				default:
+					errCount.Add(1)
+				}
+				mu.Lock()
+				if len(report.ErrorSamples) < maxStressErrorSample {
+					report.ErrorSamples = append(report.ErrorSamples, strings.TrimSpace(text))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report.Completed = completed.Load()
+	report.Rejected = rejected.Load()
+	report.Deadlocks = deadlocks.Load()
+	report.Timeouts = timeouts.Load()
+	report.Errors = errCount.Load()
+	report.Retries = retries.Load()
+	report.DurationMs = elapsed.Milliseconds()
+	if elapsed > 0 {
+		report.Throughput = float64(report.Completed) / elapsed.Seconds()
+	}
+	report.Verification.NegativeObserved = negative.Load()
+	return report
+}
+
+func accountBalances(db *gorm.DB, ids []uint) ([]AccountBalance, error) {
+	var balances []AccountBalance
+	err := db.Raw("SELECT id AS account_id, account_no, balance FROM accounts WHERE id IN ? ORDER BY id", ids).
+		Scan(&balances).Error
+	return balances, err
+}
+
+// verifyStress 残高の合計・マイナス残高・取引履歴と残高の増減・仕訳の有無を確認する
+func verifyStress(db *gorm.DB, before []AccountBalance, negativeObserved int64) (StressVerification, error) {
+	v := StressVerification{
+		NegativeObserved: negativeObserved,
+		NegativeBalances: []AccountBalance{},
+		DeltaMismatches:  []StressDeltaMismatch{},
+	}
+	ids := make([]uint, len(before))
+	beforeByID := make(map[uint]int64, len(before))
+	for i, b := range before {
+		ids[i] = b.AccountID
+		beforeByID[b.AccountID] = b.Balance
+		v.TotalBefore += b.Balance
+	}
+
+	after, err := accountBalances(db, ids)
+	if err != nil {
+		return v, err
+	}
+	for _, a := range after {
+		v.TotalAfter += a.Balance
+		if a.Balance < 0 {
+			v.NegativeBalances = append(v.NegativeBalances, a)
+		}
+	}
+	v.Conserved = v.TotalBefore == v.TotalAfter
+
+	// 口座は作り直した直後なので、残っている取引はすべて今回の振込
+	var deltas []struct {
+		AccountID uint
+		Delta     int64
+	}
+	if err := db.Raw(`
+		SELECT a.id AS account_id,
+			COALESCE(SUM(CASE WHEN t.to_account_id = a.id THEN t.to_amount ELSE -t.amount END), 0) AS delta
+		FROM accounts a
+		LEFT JOIN transactions t
+			ON (t.from_account_id = a.id OR t.to_account_id = a.id)
+			AND t.status = ? AND t.transaction_type = ?
+		WHERE a.id IN ?
+		GROUP BY a.id
+		ORDER BY a.id`,
+		TransactionStatusCompleted, TransactionTypeTransfer, ids,
+	).Scan(&deltas).Error; err != nil {
+		return v, err
+	}
+	afterByID := make(map[uint]int64, len(after))
+	for _, a := range after {
+		afterByID[a.AccountID] = a.Balance
+	}
+	for _, d := range deltas {
+		if actual := afterByID[d.AccountID] - beforeByID[d.AccountID]; actual != d.Delta {
+			v.DeltaMismatches = append(v.DeltaMismatches, StressDeltaMismatch{AccountID: d.AccountID, ExpectedDelta: d.Delta, ActualDelta: actual})
+		}
+	}
+
+	if err := db.Raw("SELECT COUNT(*) FROM transactions WHERE status = ? AND transaction_type = ?",
+		TransactionStatusCompleted, TransactionTypeTransfer).Scan(&v.CompletedTransactions).Error; err != nil {
+		return v, err
+	}
+	if err := db.Raw(`
+		SELECT COUNT(*) FROM transactions t
+		WHERE t.status = ? AND t.transaction_type = ? AND NOT EXISTS (
+			SELECT 1 FROM journal_entries je
+			JOIN postings pf ON pf.journal_entry_id = je.id AND pf.account_id = t.from_account_id AND pf.amount = -t.amount
+			JOIN postings pt ON pt.journal_entry_id = je.id AND pt.account_id = t.to_account_id AND pt.amount = t.to_amount
+			WHERE je.transaction_id = t.id
+		)`,
+		TransactionStatusCompleted, TransactionTypeTransfer,
+	).Scan(&v.UnpostedTransactions).Error; err != nil {
+		return v, err
+	}
+
+	v.OK = v.Conserved && len(v.NegativeBalances) == 0 && v.NegativeObserved == 0 &&
+		v.UnpostedTransactions == 0 && len(v.DeltaMismatches) == 0
+	return v, nil
+}
+
+// StressTestHandler 振込処理のストレステスト（口座・取引履歴・元帳を初期化するので注意）
+//
+// transfers, concurrency, max_amount, seed, strategy=normal|lock-order|timeout-retry（カンマ区切り、省略時は全て）
+func (h *Handler) StressTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	config := StressConfig{Transfers: 2000, Concurrency: 20, MaxAmount: 5000, Seed: time.Now().UnixNano()}
+	q := r.URL.Query()
+	if raw := q.Get("transfers"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxStressTransfers {
+			http.Error(w, fmt.Sprintf("Invalid transfers (1-%d)", maxStressTransfers), http.StatusBadRequest)
+			return
+		}
+		config.Transfers = n
+	}
+	if raw := q.Get("concurrency"); raw != "" {
+		c, err := strconv.Atoi(raw)
+		if err != nil || c <= 0 || c > maxStressConcurrency {
+			http.Error(w, fmt.Sprintf("Invalid concurrency (1-%d)", maxStressConcurrency), http.StatusBadRequest)
+			return
+		}
+		config.Concurrency = c
+	}
+	if raw := q.Get("max_amount"); raw != "" {
+		a, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || a <= 0 {
+			http.Error(w, "Invalid max_amount", http.StatusBadRequest)
+			return
+		}
+		config.MaxAmount = a
+	}
+	if raw := q.Get("seed"); raw != "" {
+		s, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid seed", http.StatusBadRequest)
+			return
+		}
+		config.Seed = s
+	}
+	var strategies []string
+	if raw := q.Get("strategy"); raw != "" {
+		strategies = strings.Split(raw, ",")
+		for _, name := range strategies {
+			found := false
+			for _, s := range h.stressStrategies() {
+				found = found || s.name == name
+			}
+			if !found {
+				http.Error(w, fmt.Sprintf("Unknown strategy %q", name), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if err := autoMigrate(h.DB); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to migrate: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	reports, err := h.RunTransferStress(r.Context(), config, strategies)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Stress test failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{
+		"config":  config,
+		"reports": reports,
+	})
+}
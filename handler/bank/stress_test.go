@@ -0,0 +1,58 @@
+package bank
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStressPlan(t *testing.T) {
+	ids := []uint{1, 2, 3}
+	config := StressConfig{Transfers: 500, MaxAmount: 100, Seed: 42}
+	plan := stressPlan(ids, config)
+	again := stressPlan(ids, config)
+
+	for i, req := range plan {
+		if req.FromAccountID == req.ToAccountID {
+			t.Fatalf("transfer %d is to the same account: %+v", i, req)
+		}
+		if req.Amount < 1 || req.Amount > config.MaxAmount {
+			t.Fatalf("transfer %d amount = %d; expected 1..%d", i, req.Amount, config.MaxAmount)
+		}
+		if req != again[i] {
+			t.Fatalf("plan is not deterministic at %d: %+v != %+v", i, req, again[i])
+		}
+	}
+}
+
+func TestTransferStress(t *testing.T) {
+	db := openTestDB(t)
+	h := &Handler{DB: db}
+
+	config := StressConfig{Transfers: 300, Concurrency: 10, MaxAmount: 5000, Seed: 1}
+	reports, err := h.RunTransferStress(context.Background(), config, nil)
+	if err != nil {
+		t.Fatalf("RunTransferStress() error = %v", err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports; expected 3", len(reports))
+	}
+	for _, r := range reports {
+		v := r.Verification
+		if !v.Conserved {
+			t.Errorf("%s: total %d -> %d", r.Strategy, v.TotalBefore, v.TotalAfter)
+		}
+		if v.UnpostedTransactions != 0 || len(v.DeltaMismatches) != 0 {
+			t.Errorf("%s: transactions do not match balances: %+v", r.Strategy, v)
+		}
+		if int64(r.Transfers) != r.Completed+r.Rejected+r.Deadlocks+r.Timeouts+r.Errors {
+			t.Errorf("%s: outcomes do not add up: %+v", r.Strategy, r)
+		}
+		if v.CompletedTransactions != r.Completed {
+			t.Errorf("%s: %d completed transactions recorded, %d responses", r.Strategy, v.CompletedTransactions, r.Completed)
+		}
+		// 残高チェックの前に行ロックを取る振込処理ではマイナス残高は起きない
+		if r.Strategy != "normal" && !v.OK {
+			t.Errorf("%s: verification failed: %+v", r.Strategy, v)
+		}
+	}
+}
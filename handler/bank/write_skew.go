@@ -0,0 +1,235 @@
+package bank
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// write skewの再現シナリオ
+//
+// 共同名義の口座A・B（残高合計の下限あり）から、2つのトランザクションが同時に
+// それぞれA・Bの片方だけを出金する。どちらも「合計 - 出金額 >= 下限」を確認してから
+// 自分の口座だけを更新するため、互いの更新行は重ならない。
+// READ COMMITTED / REPEATABLE READでは両方コミットされ下限を割るが、
+// SERIALIZABLEでは片方が40001で失敗し、やり直した時に下限チェックで拒否される。
+
+// writeSkewIsolationLevels 選べる分離レベル
+var writeSkewIsolationLevels = map[string]sql.IsolationLevel{
+	"read-committed":  sql.LevelReadCommitted,
+	"repeatable-read": sql.LevelRepeatableRead,
+	"serializable":    sql.LevelSerializable,
+}
+
+const (
+	writeSkewInitialBalance = 10000
+	writeSkewMinCombined    = 10000
+	writeSkewWithdrawal     = 6000
+	// writeSkewBarrierTimeout 相手が読み終わるのを待つ上限（相手が先に失敗した場合に備える）
+	writeSkewBarrierTimeout = 2 * time.Second
+)
+
+// WriteSkewEvent シナリオの時系列
+type WriteSkewEvent struct {
+	AtMs    int64  `json:"at_ms"`
+	Tx      string `json:"tx"`
+	Attempt int    `json:"attempt"`
+	Event   string `json:"event"`
+}
+
+// WriteSkewWithdrawal 各トランザクションの結果
+type WriteSkewWithdrawal struct {
+	Tx        string `json:"tx"`
+	AccountID uint   `json:"account_id"`
+	Success   bool   `json:"success"`
+	Attempts  int    `json:"attempts"`
+	Error     string `json:"error,omitempty"`
+}
+
+// WriteSkewResult シナリオの結果
+type WriteSkewResult struct {
+	Isolation          string                `json:"isolation"`
+	JointAccountID     uint                  `json:"joint_account_id"`
+	MinCombinedBalance int64                 `json:"min_combined_balance"`
+	InitialCombined    int64                 `json:"initial_combined"`
+	FinalCombined      int64                 `json:"final_combined"`
+	InvariantViolated  bool                  `json:"invariant_violated"`
+	Withdrawals        []WriteSkewWithdrawal `json:"withdrawals"`
+	RetryStats         RetryStats            `json:"retry_stats"`
+	Timeline           []WriteSkewEvent      `json:"timeline"`
+}
+
+// setupWriteSkewAccounts 共同名義口座2つと、出金先の口座2つを作る
+func setupWriteSkewAccounts(ctx context.Context, db *gorm.DB) (*JointAccount, [2]*Account, [2]*Account, error) {
+	joint := &JointAccount{Name: "共同名義（write skewデモ）", MinCombinedBalance: writeSkewMinCombined}
+	var members, payees [2]*Account
+	suffix := time.Now().UnixNano() % 1e12
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(joint).Error; err != nil {
+			return err
+		}
+		for i, name := range []string{"A", "B"} {
+			members[i] = &Account{AccountNo: fmt.Sprintf("WS%d%s", suffix, name), Balance: writeSkewInitialBalance, OwnerName: "共同名義" + name, JointAccountID: &joint.ID}
+			payees[i] = &Account{AccountNo: fmt.Sprintf("WS%dP%s", suffix, name), OwnerName: "出金先" + name}
+			if err := openAccount(tx, members[i]); err != nil {
+				return err
+			}
+			if err := openAccount(tx, payees[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return joint, members, payees, err
+}
+
+// runWriteSkew 指定した分離レベルで、同じ共同名義口座の別々のメンバーから同時に出金する
+func (h *Handler) runWriteSkew(ctx context.Context, isolationName string) (*WriteSkewResult, error) {
+	isolation, ok := writeSkewIsolationLevels[isolationName]
+	if !ok {
+		return nil, fmt.Errorf("invalid isolation %q (read-committed, repeatable-read, serializable)", isolationName)
+	}
+	joint, members, payees, err := setupWriteSkewAccounts(ctx, h.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WriteSkewResult{
+		Isolation:          isolationName,
+		JointAccountID:     joint.ID,
+		MinCombinedBalance: joint.MinCombinedBalance,
+		InitialCombined:    writeSkewInitialBalance * 2,
+	}
+
+	start := time.Now()
+	var mu sync.Mutex
+	record := func(name string, attempt int, format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		result.Timeline = append(result.Timeline, WriteSkewEvent{
+			AtMs:    time.Since(start).Milliseconds(),
+			Tx:      name,
+			Attempt: attempt,
+			Event:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	// 両方のトランザクションが合計を読むまで更新させない（1回目の試行だけ）
+	var readBarrier sync.WaitGroup
+	readBarrier.Add(2)
+	barrierDone := make(chan struct{})
+	go func() {
+		readBarrier.Wait()
+		close(barrierDone)
+	}()
+
+	var stats retryStats
+	withdrawals := make([]WriteSkewWithdrawal, 2)
+	var wg sync.WaitGroup
+	for i := range members {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("T%d", i+1)
+			member, payee := members[i], payees[i]
+			attempt := 0
+			var arrived sync.Once
+
+			attempts, err := runWithRetry(ctx, h.DB, isolation, &stats, func(tx *gorm.DB) error {
+				attempt++
+				record(name, attempt, "BEGIN (%s)", isolationName)
+
+				var account Account
+				if err := tx.First(&account, member.ID).Error; err != nil {
+					return err
+				}
+				checkErr := checkJointMinimum(tx, &account, writeSkewWithdrawal)
+				record(name, attempt, "read combined balance, check %d withdrawal: %v", writeSkewWithdrawal, errString(checkErr))
+
+				arrived.Do(func() {
+					readBarrier.Done()
+					select {
+					case <-barrierDone:
+					case <-time.After(writeSkewBarrierTimeout):
+					}
+				})
+				if checkErr != nil {
+					return checkErr
+				}
+
+				to := *payee
+				if _, err := postTransfer(tx, &account, &to, writeSkewWithdrawal); err != nil {
+					return err
+				}
+				record(name, attempt, "withdrew %d from account %d, COMMIT", writeSkewWithdrawal, member.ID)
+				return nil
+			})
+			arrived.Do(readBarrier.Done)
+
+			withdrawals[i] = WriteSkewWithdrawal{Tx: name, AccountID: member.ID, Success: err == nil, Attempts: attempts}
+			if err != nil {
+				withdrawals[i].Error = err.Error()
+				record(name, attempt, "ROLLBACK: %v", err)
+			} else {
+				record(name, attempt, "committed")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := h.DB.WithContext(ctx).Raw("SELECT COALESCE(SUM(balance), 0) FROM accounts WHERE joint_account_id = ?", joint.ID).Scan(&result.FinalCombined).Error; err != nil {
+		return nil, err
+	}
+	result.InvariantViolated = result.FinalCombined < joint.MinCombinedBalance
+	result.Withdrawals = withdrawals
+	result.RetryStats = stats.snapshot()
+	return result, nil
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
+
+// WriteSkewDemoHandler write skewの再現デモ（isolation=read-committed|repeatable-read|serializable、省略時は全て）
+func (h *Handler) WriteSkewDemoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := autoMigrate(h.DB); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to migrate: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	levels := []string{"read-committed", "repeatable-read", "serializable"}
+	if isolation := r.URL.Query().Get("isolation"); isolation != "" {
+		levels = []string{isolation}
+	}
+
+	results := make([]*WriteSkewResult, 0, len(levels))
+	for _, level := range levels {
+		result, err := h.runWriteSkew(r.Context(), level)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if _, ok := writeSkewIsolationLevels[level]; !ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		results = append(results, result)
+	}
+
+	jsonResponse(w, map[string]interface{}{
+		"scenario": fmt.Sprintf("2 concurrent withdrawals of %d from joint accounts (%d each, combined minimum %d)", writeSkewWithdrawal, writeSkewInitialBalance, writeSkewMinCombined),
+		"results":  results,
+	})
+}
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/bulkload"
+	"gorm.io/gorm"
+)
+
+// BulkLoadHandler COPYによるテストデータ投入ジョブのAPI
+//
+// 投入はバックグラウンドで行い、進捗は/demo/bulkload/jobs（ポーリング）か
+// /demo/bulkload/jobs/events（SSE）で確認する。
+type BulkLoadHandler struct {
+	DB   *gorm.DB
+	Jobs *bulkload.Manager
+}
+
+// sseInterval SSEで進捗を送る間隔
+const sseInterval = 500 * time.Millisecond
+
+// bulkLoadDatasets インデックス性能デモ用に投入できるDataset
+var bulkLoadDatasets = map[string]func() bulkload.Dataset{
+	"products":  bulkload.Products,
+	"orders":    func() bulkload.Dataset { return bulkload.Orders(10000) },
+	"employees": bulkload.Employees,
+}
+
+// JobAccepted ジョブ起動時のレスポンス
+type JobAccepted struct {
+	Job       bulkload.Progress `json:"job"`
+	StatusURL string            `json:"status_url"`
+	EventsURL string            `json:"events_url"`
+}
+
+// newJobAccepted 進捗確認用のURLを付けたレスポンスを作成
+func newJobAccepted(job *bulkload.Job) JobAccepted {
+	return JobAccepted{
+		Job:       job.Progress(),
+		StatusURL: "/demo/bulkload/jobs?id=" + job.ID,
+		EventsURL: "/demo/bulkload/jobs/events?id=" + job.ID,
+	}
+}
+
+// StartHandler - 指定したテーブルへの投入ジョブを起動するAPI
+//
+// POST /demo/bulkload?dataset=products&rows=1000000&distribution=zipf&skew=1.5
+func (h *BulkLoadHandler) StartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.URL.Query().Get("dataset")
+	newDataset, ok := bulkLoadDatasets[name]
+	if !ok {
+		http.Error(w, "Invalid dataset (products, orders, employees)", http.StatusBadRequest)
+		return
+	}
+	cfg, err := bulkload.ConfigFromQuery(r.URL.Query(), bulkload.Config{Rows: 100000, Distribution: bulkload.DistributionUniform, Truncate: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sqlDB, err := h.DB.DB()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	dataset := newDataset()
+	job, err := h.Jobs.Start(dataset.Table, int64(cfg.Rows), func(ctx context.Context, job *bulkload.Job) error {
+		_, err := bulkload.Copy(ctx, sqlDB, dataset, cfg, job.SetDone)
+		return err
+	})
+	writeJobAccepted(w, r, job, err)
+}
+
+// writeJobAccepted ジョブ起動結果を返す（wait=trueの場合は終了まで待って最終的な進捗を返す）
+func writeJobAccepted(w http.ResponseWriter, r *http.Request, job *bulkload.Job, err error) {
+	if errors.Is(err, bulkload.ErrJobRunning) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		if err := json.NewEncoder(w).Encode(newJobAccepted(job)); err != nil {
+			return
+		}
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("wait") == "true" {
+		select {
+		case <-job.Finished():
+		case <-r.Context().Done():
+			return
+		}
+		progress := job.Progress()
+		if progress.Status != bulkload.StatusCompleted {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			if err := json.NewEncoder(w).Encode(progress); err != nil {
+				return
+			}
+			return
+		}
+		writeJSONResponse(w, progress)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(newJobAccepted(job)); err != nil {
+		return
+	}
+}
+
+// JobsHandler - ジョブの進捗を返すAPI（idを省略すると一覧）
+func (h *BulkLoadHandler) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeJSONResponse(w, h.Jobs.List())
+		return
+	}
+	job, ok := h.Jobs.Get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSONResponse(w, job.Progress())
+}
+
+// CancelJobHandler - 実行中のジョブを中断するAPI（トランザクションごとロールバックされる）
+func (h *BulkLoadHandler) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := h.Jobs.Get(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	job.Cancel()
+	<-job.Finished()
+	writeJSONResponse(w, job.Progress())
+}
+
+// JobEventsHandler - ジョブの進捗をServer-Sent Eventsで配信するAPI
+//
+// 進捗は"progress"イベント、終了時は"done"イベントを送って接続を閉じる。
+func (h *BulkLoadHandler) JobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.Jobs.Get(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	send := func(event string) error {
+		data, err := json.Marshal(job.Progress())
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	ticker := time.NewTicker(sseInterval)
+	defer ticker.Stop()
+	for {
+		if err := send("progress"); err != nil {
+			return
+		}
+		select {
+		case <-job.Finished():
+			_ = send("done")
+			return
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
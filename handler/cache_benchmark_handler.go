@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/benchmark"
+)
+
+const (
+	maxBenchmarkConcurrency = 200
+	maxBenchmarkDuration    = 60 * time.Second
+)
+
+// RunCacheBenchmark キャッシュあり・なしの両APIをプロセス内で負荷をかけて比較する
+//
+// DBクエリ数とRedis往復回数を集計するには、事前にbenchmark.InstrumentGorm/InstrumentRedisを呼んでおく。
+func (h *CacheHandler) RunCacheBenchmark(ctx context.Context, config benchmark.Config) []benchmark.Report {
+	scenarios := []struct {
+		name    string
+		target  string
+		handler http.HandlerFunc
+	}{
+		{"with cache", "/demo/cache/with", h.CacheWithHandler},
+		{"without cache", "/demo/cache/without", h.CacheWithoutHandler},
+	}
+
+	reports := make([]benchmark.Report, 0, len(scenarios))
+	for _, s := range scenarios {
+		reports = append(reports, benchmark.Run(ctx, s.name, config, func(ctx context.Context) error {
+			return invokeInProcess(ctx, s.handler, s.target)
+		}))
+	}
+	return reports
+}
+
+// invokeInProcess HTTPを経由せずにハンドラを直接呼び出す
+func invokeInProcess(ctx context.Context, h http.HandlerFunc, target string) error {
+	req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("%s returned %d", target, rec.Code)
+	}
+	return nil
+}
+
+// CacheBenchmarkHandler - キャッシュあり・なしの負荷比較API（concurrency, duration, format=json|html）
+func (h *CacheHandler) CacheBenchmarkHandler(w http.ResponseWriter, r *http.Request) {
+	config := benchmark.Config{Concurrency: 10, Duration: 5 * time.Second}
+
+	if raw := r.URL.Query().Get("concurrency"); raw != "" {
+		c, err := strconv.Atoi(raw)
+		if err != nil || c <= 0 || c > maxBenchmarkConcurrency {
+			http.Error(w, fmt.Sprintf("Invalid concurrency (1-%d)", maxBenchmarkConcurrency), http.StatusBadRequest)
+			return
+		}
+		config.Concurrency = c
+	}
+	if raw := r.URL.Query().Get("duration"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > maxBenchmarkDuration {
+			http.Error(w, fmt.Sprintf("Invalid duration (e.g. 5s, max %s)", maxBenchmarkDuration), http.StatusBadRequest)
+			return
+		}
+		config.Duration = d
+	}
+
+	reports := h.RunCacheBenchmark(r.Context(), config)
+
+	if r.URL.Query().Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := benchmark.WriteHTML(w, "Cache benchmark", reports); err != nil {
+			return
+		}
+		return
+	}
+	writeJSONResponse(w, map[string]interface{}{
+		"config": map[string]interface{}{
+			"concurrency": config.Concurrency,
+			"duration":    config.Duration.String(),
+		},
+		"reports": reports,
+	})
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/cache"
+)
+
+// CacheCodecCompareHandler - 最新100件のログをCodec・圧縮方式の組み合わせごとにエンコードし、サイズと速度を比較するAPI
+func (h *CacheHandler) CacheCodecCompareHandler(w http.ResponseWriter, r *http.Request) {
+	iterations := 1000
+	if raw := r.URL.Query().Get("iterations"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > 100000 {
+			http.Error(w, "Invalid iterations (1-100000)", http.StatusBadRequest)
+			return
+		}
+		iterations = n
+	}
+
+	logs, err := h.fetchLatestLogs(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	source := "database"
+	if len(logs) == 0 {
+		// テストデータ未作成の場合はサンプルで比較する
+		logs = sampleLogRecords(fetchLimit)
+		source = "sample"
+	}
+
+	reports := cache.CompareCodecs(logs,
+		[]cache.Codec{cache.JSON, cache.Gob, cache.MessagePack, cache.Binary},
+		[]cache.Compression{cache.NoCompression, cache.Snappy, cache.Zstd},
+		iterations,
+	)
+
+	writeJSONResponse(w, map[string]interface{}{
+		"payload":    source,
+		"records":    len(logs),
+		"iterations": iterations,
+		"reports":    reports,
+	})
+}
+
+// sampleLogRecords InitTestDataHandlerと同じ形のログを生成
+func sampleLogRecords(n int) LogRecords {
+	levels := []string{"INFO", "WARN", "ERROR", "DEBUG"}
+	now := time.Now()
+	logs := make(LogRecords, n)
+	for i := range logs {
+		logs[i] = LogRecord{
+			ID:        i + 1,
+			Message:   fmt.Sprintf("Log message #%d", i+1),
+			Level:     levels[i%len(levels)],
+			Timestamp: now.Add(-time.Duration(i) * time.Second),
+		}
+	}
+	return logs
+}
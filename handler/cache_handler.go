@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/keito-isurugi/go-demo/bulkload"
+	"github.com/keito-isurugi/go-demo/cache"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
@@ -21,6 +24,36 @@ type LogRecord struct {
 type CacheHandler struct {
 	DB    *gorm.DB
 	Redis *redis.Client
+
+	// Jobs テストデータ投入ジョブ（BulkLoadHandlerと共有すると進捗APIで確認できる）
+	Jobs *bulkload.Manager
+
+	logs     *cache.Cache[LogRecords]
+	searches *cache.Cache[LogSearchPage]
+}
+
+// NewCacheHandler L1(LRU) + L2(Redis)の2層キャッシュを使うCacheHandlerを作成
+//
+// jobsはテストデータ投入（InitTestDataHandler）で使うジョブの管理。
+func NewCacheHandler(db *gorm.DB, rdb *redis.Client, jobs *bulkload.Manager) *CacheHandler {
+	config := cache.DefaultConfig("logs")
+	config.L2TTL = cacheTTL
+	// キャッシュスタンピード対策
+	config.Coalesce = true
+	config.StaleTTL = 30 * time.Second
+	config.EarlyRefreshBeta = 1.0
+	// protobuf互換のバイナリ + snappy圧縮で保存（ヘッダーで判別するので旧形式のエントリも読める）
+	config.Codec = cache.Binary
+	config.Compression = cache.Snappy
+	searchConfig := cache.DefaultConfig("logs:search")
+	searchConfig.Coalesce = true
+	return &CacheHandler{
+		DB:       db,
+		Redis:    rdb,
+		Jobs:     jobs,
+		logs:     cache.New[LogRecords](rdb, config),
+		searches: cache.New[LogSearchPage](rdb, searchConfig),
+	}
 }
 
 type PerformanceResult struct {
@@ -31,7 +64,7 @@ type PerformanceResult struct {
 }
 
 const (
-	cacheKey       = "logs:latest"
+	cacheKey       = "latest"
 	cacheTTL       = 5 * time.Minute
 	testDataCount  = 100000  // テストデータ10万件
 	fetchLimit     = 100     // 取得件数（実用的なサイズ）
@@ -39,46 +72,43 @@ const (
 
 // CacheWithHandler - キャッシュありのAPI（最新100件のログをキャッシュ）
 func (h *CacheHandler) CacheWithHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
 	start := time.Now()
 
-	// Redisからキャッシュを取得
-	cached, err := h.Redis.Get(ctx, cacheKey).Result()
-	if err == nil {
-		// キャッシュヒット
-		var logs []LogRecord
-		if err := json.Unmarshal([]byte(cached), &logs); err == nil {
-			duration := time.Since(start)
-			result := PerformanceResult{
-				Source:     "cache (Redis)",
-				Count:      len(logs),
-				DurationMs: duration.Milliseconds(),
-				Records:    logs,
-			}
-			writeJSONResponse(w, result)
-			return
-		}
-	}
-
-	// キャッシュミス - DBから最新100件を取得
-	var logs []LogRecord
-	if err := h.DB.Order("timestamp DESC").Limit(fetchLimit).Find(&logs).Error; err != nil {
+	// L1(メモリ) -> L2(Redis) -> DB の順に取得
+	result, err := h.logs.Fetch(r.Context(), cacheKey, h.fetchLatestLogs)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Redisにキャッシュを保存
-	data, _ := json.Marshal(logs)
-	h.Redis.Set(ctx, cacheKey, data, cacheTTL)
+	source := "database (PostgreSQL) - cached for next request"
+	switch result.Source {
+	case cache.SourceL1:
+		source = "cache (in-process LRU)"
+	case cache.SourceL2:
+		source = "cache (Redis)"
+	}
 
-	duration := time.Since(start)
-	result := PerformanceResult{
-		Source:     "database (PostgreSQL) - cached for next request",
-		Count:      len(logs),
-		DurationMs: duration.Milliseconds(),
-		Records:    logs,
+	writeJSONResponse(w, PerformanceResult{
+		Source:     source,
+		Count:      len(result.Value),
+		DurationMs: time.Since(start).Milliseconds(),
+		Records:    result.Value,
+	})
+}
+
+// CacheStatsHandler - キャッシュのヒット・ミス統計を返すAPI
+func (h *CacheHandler) CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, h.logs.Stats())
+}
+
+// fetchLatestLogs DBから最新100件を取得
+func (h *CacheHandler) fetchLatestLogs(ctx context.Context) (LogRecords, error) {
+	var logs LogRecords
+	if err := h.DB.WithContext(ctx).Order("timestamp DESC").Limit(fetchLimit).Find(&logs).Error; err != nil {
+		return nil, err
 	}
-	writeJSONResponse(w, result)
+	return logs, nil
 }
 
 // CacheWithoutHandler - キャッシュなしのAPI（毎回DBから最新100件を取得）
@@ -86,8 +116,8 @@ func (h *CacheHandler) CacheWithoutHandler(w http.ResponseWriter, r *http.Reques
 	start := time.Now()
 
 	// DBから最新100件を取得（キャッシュなし）
-	var logs []LogRecord
-	if err := h.DB.Order("timestamp DESC").Limit(fetchLimit).Find(&logs).Error; err != nil {
+	logs, err := h.fetchLatestLogs(r.Context())
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -104,8 +134,11 @@ func (h *CacheHandler) CacheWithoutHandler(w http.ResponseWriter, r *http.Reques
 
 // ClearCacheHandler - キャッシュクリア用API
 func (h *CacheHandler) ClearCacheHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	if err := h.Redis.Del(ctx, cacheKey).Err(); err != nil {
+	if err := h.logs.Delete(r.Context(), cacheKey); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to clear cache: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.searches.InvalidateTag(r.Context(), searchTagAll); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to clear cache: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -118,8 +151,34 @@ func (h *CacheHandler) ClearCacheHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// logRecordsDataset log_recordsに投入するテストデータ（1秒ずつ古くなるログ）
+func logRecordsDataset() bulkload.Dataset {
+	levels := []string{"INFO", "WARN", "ERROR", "DEBUG"}
+	return bulkload.Dataset{
+		Table:   "log_records",
+		Columns: []string{"message", "level", "timestamp"},
+		Row: func(i int, g *bulkload.Generator) []any {
+			return []any{
+				fmt.Sprintf("Log message #%d", i+1),
+				bulkload.Pick(g, i, levels),
+				g.Now.Add(-time.Duration(i) * time.Second),
+			}
+		},
+	}
+}
+
 // InitTestDataHandler - テストデータ作成用API
+//
+// COPY FROM STDINで投入するジョブをバックグラウンドで起動し、202を返す。
+// rows, distribution(sequential|uniform|zipf|normal), skew, seedで件数とlevelの偏りを指定できる。
+// wait=trueを付けると投入が終わるまで待つ。
 func (h *CacheHandler) InitTestDataHandler(w http.ResponseWriter, r *http.Request) {
+	cfg, err := bulkload.ConfigFromQuery(r.URL.Query(), bulkload.Config{Rows: testDataCount, Distribution: bulkload.DistributionSequential, Truncate: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// logsテーブルが存在しない場合は作成
 	if !h.DB.Migrator().HasTable(&LogRecord{}) {
 		if err := h.DB.AutoMigrate(&LogRecord{}); err != nil {
@@ -127,38 +186,39 @@ func (h *CacheHandler) InitTestDataHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
-
-	// 既存データをクリア
-	h.DB.Exec("TRUNCATE TABLE log_records")
-
-	// テストデータを挿入
-	start := time.Now()
-	logs := make([]LogRecord, testDataCount)
-	levels := []string{"INFO", "WARN", "ERROR", "DEBUG"}
-
-	for i := 0; i < testDataCount; i++ {
-		logs[i] = LogRecord{
-			Message:   fmt.Sprintf("Log message #%d", i+1),
-			Level:     levels[i%len(levels)],
-			Timestamp: time.Now().Add(-time.Duration(i) * time.Second),
-		}
-	}
-
-	// バッチインサート
-	if err := h.DB.CreateInBatches(logs, 1000).Error; err != nil {
-		http.Error(w, fmt.Sprintf("Failed to insert test data: %v", err), http.StatusInternalServerError)
+	sqlDB, err := h.DB.DB()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	duration := time.Since(start)
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":      "Test data created successfully",
-		"record_count": testDataCount,
-		"duration_ms":  duration.Milliseconds(),
-	}); err != nil {
-		return
-	}
+	job, err := h.Jobs.Start("log_records", int64(cfg.Rows), func(ctx context.Context, job *bulkload.Job) error {
+		// 大量の変更通知が飛ばないよう、投入中はトリガーを外す
+		if err := dropLogNotifyTrigger(h.DB); err != nil {
+			return err
+		}
+		// 失敗・中断してもトリガーとキャッシュは元に戻す
+		defer func() {
+			ctx := context.WithoutCancel(ctx)
+			if err := installLogNotifyTrigger(h.DB); err != nil {
+				log.Printf("bulkload: %v", err)
+			}
+			if err := h.logs.Delete(ctx, cacheKey); err != nil {
+				log.Printf("bulkload: failed to clear cache: %v", err)
+			}
+			if _, err := h.searches.InvalidateTag(ctx, searchTagAll); err != nil {
+				log.Printf("bulkload: failed to clear cache: %v", err)
+			}
+		}()
+
+		copied, err := bulkload.Copy(ctx, sqlDB, logRecordsDataset(), cfg, job.SetDone)
+		if err != nil {
+			return err
+		}
+		job.SetResult(map[string]interface{}{"record_count": copied})
+		return nil
+	})
+	writeJobAccepted(w, r, job, err)
 }
 
 func writeJSONResponse(w http.ResponseWriter, data interface{}) {
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/keito-isurugi/go-demo/cache"
+	"gorm.io/gorm"
+)
+
+// logNotifyChannel log_recordsの変更を通知するLISTEN/NOTIFYチャネル
+const logNotifyChannel = "log_records_changed"
+
+// logNotifyTriggerSQL 行の変更とTRUNCATEをpg_notifyで通知するトリガー
+var logNotifyTriggerSQL = []string{
+	`CREATE OR REPLACE FUNCTION notify_log_records_changed() RETURNS trigger AS $$
+DECLARE
+	rec log_records;
+BEGIN
+	IF TG_OP = 'TRUNCATE' THEN
+		PERFORM pg_notify('` + logNotifyChannel + `', json_build_object('op', TG_OP)::text);
+		RETURN NULL;
+	END IF;
+	IF TG_OP = 'DELETE' THEN
+		rec := OLD;
+	ELSE
+		rec := NEW;
+	END IF;
+	PERFORM pg_notify('` + logNotifyChannel + `', json_build_object('op', TG_OP, 'record', row_to_json(rec))::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`,
+	`CREATE TRIGGER log_records_notify AFTER INSERT OR UPDATE OR DELETE ON log_records
+	FOR EACH ROW EXECUTE FUNCTION notify_log_records_changed()`,
+	`CREATE TRIGGER log_records_notify_truncate AFTER TRUNCATE ON log_records
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_log_records_changed()`,
+}
+
+// logChange NOTIFYのペイロード
+type logChange struct {
+	Op     string    `json:"op"` // INSERT, UPDATE, DELETE, TRUNCATE
+	Record LogRecord `json:"record"`
+}
+
+// CreateLogRequest ログ作成リクエスト
+type CreateLogRequest struct {
+	Message string `json:"message"`
+	Level   string `json:"level"`
+}
+
+// installLogNotifyTrigger 変更通知トリガーを作成（既にあれば作り直す）
+func installLogNotifyTrigger(db *gorm.DB) error {
+	if err := dropLogNotifyTrigger(db); err != nil {
+		return err
+	}
+	for _, stmt := range logNotifyTriggerSQL {
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("failed to install log notify trigger: %w", err)
+		}
+	}
+	return nil
+}
+
+// dropLogNotifyTrigger 変更通知トリガーを削除（大量投入時に通知が溢れないようにする）
+func dropLogNotifyTrigger(db *gorm.DB) error {
+	for _, name := range []string{"log_records_notify", "log_records_notify_truncate"} {
+		if err := db.Exec("DROP TRIGGER IF EXISTS " + name + " ON log_records").Error; err != nil {
+			return fmt.Errorf("failed to drop trigger %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// AttachBus Redis Pub/Subで他インスタンスのインプロセスキャッシュも無効化する
+func (h *CacheHandler) AttachBus(b *cache.Bus) {
+	h.logs.AttachBus(b)
+	h.searches.AttachBus(b)
+}
+
+// ListenLogChanges log_recordsの変更通知を受けて「最新100件」のキャッシュを更新し続ける
+//
+// INSERTはキャッシュ済みのリストへ差分で反映し、UPDATE/DELETE/TRUNCATEは無効化する。
+// 接続が切れた場合は再接続する（ctxがキャンセルされるまでブロック）。
+func (h *CacheHandler) ListenLogChanges(ctx context.Context, dsn string) {
+	backoff := time.Second
+	for {
+		if err := h.listenLogChanges(ctx, dsn); err != nil && ctx.Err() == nil {
+			log.Printf("log listener: %v (reconnecting in %s)", err, backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+			backoff = min(backoff*2, 30*time.Second)
+		}
+	}
+}
+
+func (h *CacheHandler) listenLogChanges(ctx context.Context, dsn string) error {
+	if h.DB.Migrator().HasTable(&LogRecord{}) {
+		if err := installLogNotifyTrigger(h.DB); err != nil {
+			return err
+		}
+	}
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+logNotifyChannel); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	// 接続していなかった間の変更は分からないので、一度キャッシュを破棄する
+	if err := h.logs.Delete(ctx, cacheKey); err != nil {
+		log.Printf("log listener: %v", err)
+	}
+	if _, err := h.searches.InvalidateTag(ctx, searchTagAll); err != nil {
+		log.Printf("log listener: %v", err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+		if err := h.applyLogChange(ctx, n.Payload); err != nil {
+			log.Printf("log listener: %v", err)
+		}
+	}
+}
+
+// applyLogChange 変更通知をキャッシュに反映
+func (h *CacheHandler) applyLogChange(ctx context.Context, payload string) error {
+	var change logChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		return fmt.Errorf("invalid payload %q: %w", payload, err)
+	}
+
+	if change.Op == "INSERT" {
+		if err := h.invalidateSearchesFor(ctx, change.Record); err != nil {
+			return err
+		}
+		return h.logs.Update(ctx, cacheKey, func(current LogRecords) (LogRecords, bool) {
+			return insertLatestLog(current, change.Record, fetchLimit), true
+		})
+	}
+
+	if _, err := h.searches.InvalidateTag(ctx, searchTagAll); err != nil {
+		return err
+	}
+	return h.logs.Delete(ctx, cacheKey)
+}
+
+// insertLatestLog timestamp降順・最大limit件のリストに新しいログを差し込んだ新しいスライスを返す
+func insertLatestLog(logs []LogRecord, record LogRecord, limit int) []LogRecord {
+	for _, l := range logs {
+		if l.ID == record.ID {
+			return logs
+		}
+	}
+	if len(logs) >= limit && !record.Timestamp.After(logs[len(logs)-1].Timestamp) {
+		return logs
+	}
+
+	// キャッシュ上のスライスは他のリクエストと共有されているのでコピーして作る
+	result := make([]LogRecord, 0, min(len(logs)+1, limit))
+	inserted := false
+	for _, l := range logs {
+		if !inserted && record.Timestamp.After(l.Timestamp) {
+			result = append(result, record)
+			inserted = true
+		}
+		result = append(result, l)
+	}
+	if !inserted {
+		result = append(result, record)
+	}
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// CreateLogHandler - ログを1件追加するAPI（キャッシュはトリガー経由で更新される）
+func (h *CacheHandler) CreateLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed. Use POST.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateLogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+	if req.Level == "" {
+		req.Level = "INFO"
+	}
+	if !validLogLevels[req.Level] {
+		http.Error(w, "Invalid level. Use INFO, WARN, ERROR or DEBUG", http.StatusBadRequest)
+		return
+	}
+
+	record := LogRecord{
+		Message:   req.Message,
+		Level:     req.Level,
+		Timestamp: time.Now(),
+	}
+	if err := h.DB.WithContext(r.Context()).Create(&record).Error; err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSONResponse(w, record)
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/cache"
+)
+
+// StampedeResult キャッシュスタンピードデモの結果
+type StampedeResult struct {
+	Mode        string      `json:"mode"`
+	Requests    int         `json:"requests"`
+	DBQueries   int64       `json:"db_queries"`
+	StaleServed int         `json:"stale_served"`
+	Errors      int         `json:"errors"`
+	DurationMs  int64       `json:"duration_ms"`
+	CacheStats  cache.Stats `json:"cache_stats"`
+}
+
+// CacheStampedeHandler - キャッシュ期限切れの瞬間にN件の同時リクエストを発生させ、DBクエリ数を比較するAPI
+//
+// - unprotected: 対策なし（全リクエストがDBに到達する）
+// - coalesced: singleflight + Redisロックで読み込みを1本化
+// - stale-while-revalidate: 期限切れの値を返しつつ裏で1回だけ更新
+func (h *CacheHandler) CacheStampedeHandler(w http.ResponseWriter, r *http.Request) {
+	n := 100
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		parsed, err := strconv.Atoi(nStr)
+		if err != nil || parsed <= 0 || parsed > 10000 {
+			http.Error(w, "Invalid n (1-10000)", http.StatusBadRequest)
+			return
+		}
+		n = parsed
+	}
+
+	ctx := r.Context()
+	results := make([]StampedeResult, 0, 3)
+	for _, mode := range []string{"unprotected", "coalesced", "stale-while-revalidate"} {
+		result, err := h.runStampede(ctx, mode, n)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Stampede demo failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, result)
+	}
+
+	writeJSONResponse(w, map[string]interface{}{
+		"requests": n,
+		"results":  results,
+	})
+}
+
+// runStampede 指定したモードのキャッシュを期限切れ状態にしてからN件を同時に実行
+func (h *CacheHandler) runStampede(ctx context.Context, mode string, n int) (StampedeResult, error) {
+	config := cache.DefaultConfig("stampede:" + mode)
+	config.L2TTL = cacheTTL
+	switch mode {
+	case "coalesced":
+		config.Coalesce = true
+	case "stale-while-revalidate":
+		config.Coalesce = true
+		config.StaleTTL = time.Minute
+	}
+	c := cache.New[LogRecords](h.Redis, config)
+
+	var queries atomic.Int64
+	loader := func(ctx context.Context) (LogRecords, error) {
+		queries.Add(1)
+		return h.fetchLatestLogs(ctx)
+	}
+
+	// 期限切れの状態を作る
+	if err := c.Delete(ctx, cacheKey); err != nil {
+		return StampedeResult{}, err
+	}
+	if mode == "stale-while-revalidate" {
+		logs, err := h.fetchLatestLogs(ctx)
+		if err != nil {
+			return StampedeResult{}, err
+		}
+		c.Set(ctx, cacheKey, logs, cache.WithTTL(time.Millisecond))
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stale, errCount := 0, 0
+	ready := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ready
+			result, err := c.Fetch(ctx, cacheKey, loader)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errCount++
+			}
+			if result.Stale {
+				stale++
+			}
+		}()
+	}
+	// 全goroutineを同時にスタートさせる
+	close(ready)
+	wg.Wait()
+	duration := time.Since(start)
+
+	// 裏での更新が終わるのを少し待ってから後片付け
+	time.Sleep(100 * time.Millisecond)
+	if err := c.Delete(context.WithoutCancel(ctx), cacheKey); err != nil {
+		return StampedeResult{}, err
+	}
+
+	return StampedeResult{
+		Mode:        mode,
+		Requests:    n,
+		DBQueries:   queries.Load(),
+		StaleServed: stale,
+		Errors:      errCount,
+		DurationMs:  duration.Milliseconds(),
+		CacheStats:  c.Stats(),
+	}, nil
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/egress"
+)
+
+// defaultEgressGuard Guardを設定していないハンドラが使うSSRF対策（内部のアドレスには接続しない）
+var defaultEgressGuard = egress.New(egress.DefaultConfig())
+
+// egressClient ユーザーが指定したURLへリクエストするクライアント（guardがnilなら既定の設定）
+func egressClient(guard *egress.Guard, timeout time.Duration) *http.Client {
+	if guard == nil {
+		guard = defaultEgressGuard
+	}
+	return guard.Client(timeout)
+}
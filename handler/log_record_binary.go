@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LogRecords ログの一覧（キャッシュのBinary codec用にprotobuf互換のワイヤ形式でエンコードできる）
+//
+//	message LogRecords { repeated LogRecord records = 1; }
+//	message LogRecord {
+//	  int64  id        = 1;
+//	  string message   = 2;
+//	  string level     = 3;
+//	  int64  timestamp = 4; // UnixNano
+//	}
+type LogRecords []LogRecord
+
+// protobufのワイヤタイプ
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendVarintField(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		// proto3ではゼロ値は省略する
+		return b
+	}
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+// MarshalBinary encoding.BinaryMarshalerの実装
+func (logs LogRecords) MarshalBinary() ([]byte, error) {
+	var out, rec []byte
+	for _, l := range logs {
+		rec = rec[:0]
+		rec = appendVarintField(rec, 1, int64(l.ID))
+		if l.Message != "" {
+			rec = appendBytesField(rec, 2, []byte(l.Message))
+		}
+		if l.Level != "" {
+			rec = appendBytesField(rec, 3, []byte(l.Level))
+		}
+		if !l.Timestamp.IsZero() {
+			rec = appendVarintField(rec, 4, l.Timestamp.UnixNano())
+		}
+		out = appendBytesField(out, 1, rec)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary encoding.BinaryUnmarshalerの実装
+func (logs *LogRecords) UnmarshalBinary(data []byte) error {
+	result := LogRecords{}
+	err := walkFields(data, func(field, wireType int, varint uint64, bytes []byte) error {
+		if field != 1 || wireType != wireBytes {
+			return nil
+		}
+		var l LogRecord
+		if err := walkFields(bytes, func(field, wireType int, varint uint64, bytes []byte) error {
+			switch {
+			case field == 1 && wireType == wireVarint:
+				l.ID = int(int64(varint))
+			case field == 2 && wireType == wireBytes:
+				l.Message = string(bytes)
+			case field == 3 && wireType == wireBytes:
+				l.Level = string(bytes)
+			case field == 4 && wireType == wireVarint:
+				l.Timestamp = time.Unix(0, int64(varint))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		result = append(result, l)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*logs = result
+	return nil
+}
+
+var errTruncated = errors.New("protobuf: truncated message")
+
+// walkFields メッセージのフィールドを順に読み、未知のフィールドは読み飛ばす
+func walkFields(data []byte, fn func(field, wireType int, varint uint64, bytes []byte) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errTruncated
+		}
+		data = data[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+
+		var varint uint64
+		var bytes []byte
+		switch wireType {
+		case wireVarint:
+			varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errTruncated
+			}
+			data = data[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errTruncated
+			}
+			bytes = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errTruncated
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d", wireType)
+		}
+
+		if err := fn(field, wireType, varint, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keito-isurugi/go-demo/cache"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+
+	// searchTagAll 全ての検索結果に付けるタグ
+	searchTagAll = "search"
+	// searchTagAnyLevel レベルで絞り込んでいない検索結果に付けるタグ
+	searchTagAnyLevel = "level:*"
+)
+
+// validLogLevels 検索・作成で受け付けるログレベル
+var validLogLevels = map[string]bool{"INFO": true, "WARN": true, "ERROR": true, "DEBUG": true}
+
+// LogSearchQuery 正規化済みのログ検索条件
+type LogSearchQuery struct {
+	Level  string     `json:"level,omitempty"`
+	Q      string     `json:"q,omitempty"`
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+	Limit  int        `json:"limit"`
+	Cursor string     `json:"cursor,omitempty"`
+}
+
+// LogSearchPage 検索結果の1ページ（キャッシュされる単位）
+type LogSearchPage struct {
+	Records    []LogRecord `json:"records"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// LogSearchResult 検索APIのレスポンス
+type LogSearchResult struct {
+	LogSearchPage
+	Query      LogSearchQuery `json:"query"`
+	Source     string         `json:"source"`
+	CacheKey   string         `json:"cache_key"`
+	Shape      string         `json:"shape"`
+	TTLSeconds int64          `json:"ttl_seconds"`
+	DurationMs int64          `json:"duration_ms"`
+}
+
+// logSearchTTLRule クエリの形ごとのTTLルール（上から順に評価）
+type logSearchTTLRule struct {
+	Shape string
+	Match func(q LogSearchQuery, now time.Time) bool
+	TTL   time.Duration
+}
+
+var logSearchTTLRules = []logSearchTTLRule{
+	{
+		// 過去で閉じた期間は新しいログが入っても変わらないので長めに保持
+		Shape: "historical",
+		Match: func(q LogSearchQuery, now time.Time) bool { return q.To != nil && q.To.Before(now.Add(-time.Minute)) },
+		TTL:   time.Hour,
+	},
+	{
+		// 2ページ目以降は先頭ページほど頻繁には変わらない
+		Shape: "paginated",
+		Match: func(q LogSearchQuery, now time.Time) bool { return q.Cursor != "" },
+		TTL:   5 * time.Minute,
+	},
+	{
+		Shape: "substring",
+		Match: func(q LogSearchQuery, now time.Time) bool { return q.Q != "" },
+		TTL:   2 * time.Minute,
+	},
+	{
+		// 最新のログを含む先頭ページ
+		Shape: "latest",
+		Match: func(q LogSearchQuery, now time.Time) bool { return true },
+		TTL:   30 * time.Second,
+	},
+}
+
+// parseLogSearchQuery クエリパラメータを検証・正規化する
+func parseLogSearchQuery(values url.Values) (LogSearchQuery, error) {
+	q := LogSearchQuery{
+		Level:  strings.ToUpper(strings.TrimSpace(values.Get("level"))),
+		Q:      strings.TrimSpace(values.Get("q")),
+		Limit:  defaultSearchLimit,
+		Cursor: strings.TrimSpace(values.Get("cursor")),
+	}
+
+	if q.Level != "" && !validLogLevels[q.Level] {
+		return q, fmt.Errorf("invalid level %q", q.Level)
+	}
+
+	for name, dst := range map[string]**time.Time{"from": &q.From, "to": &q.To} {
+		raw := strings.TrimSpace(values.Get(name))
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s (use RFC3339): %w", name, err)
+		}
+		// タイムゾーン表記の違いで別キーにならないようUTCに揃える
+		t = t.UTC()
+		*dst = &t
+	}
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return q, errors.New("from must be before to")
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > maxSearchLimit {
+			return q, fmt.Errorf("invalid limit (1-%d)", maxSearchLimit)
+		}
+		q.Limit = limit
+	}
+
+	if q.Cursor != "" {
+		if _, _, err := decodeLogCursor(q.Cursor); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// CacheKey 正規化した条件から決定的にキャッシュキーを作る
+//
+// パラメータの順序や表記揺れに関係なく、同じ条件なら同じキーになる。
+func (q LogSearchQuery) CacheKey() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "level=%s\n", q.Level)
+	fmt.Fprintf(&b, "q=%s\n", q.Q)
+	for _, t := range []*time.Time{q.From, q.To} {
+		if t != nil {
+			b.WriteString(t.Format(time.RFC3339Nano))
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "limit=%d\n", q.Limit)
+	fmt.Fprintf(&b, "cursor=%s", q.Cursor)
+
+	sum := sha256.Sum256([]byte(b.String()))
+	level := q.Level
+	if level == "" {
+		level = "any"
+	}
+	return "search:" + strings.ToLower(level) + ":" + hex.EncodeToString(sum[:16])
+}
+
+// Tags 無効化用のタグ（レベル単位 + 全件）
+func (q LogSearchQuery) Tags() []string {
+	if q.Level == "" {
+		return []string{searchTagAll, searchTagAnyLevel}
+	}
+	return []string{searchTagAll, "level:" + q.Level}
+}
+
+// ttlRule クエリの形に合うTTLルールを返す
+func (q LogSearchQuery) ttlRule(now time.Time) logSearchTTLRule {
+	for _, rule := range logSearchTTLRules {
+		if rule.Match(q, now) {
+			return rule
+		}
+	}
+	return logSearchTTLRules[len(logSearchTTLRules)-1]
+}
+
+// encodeLogCursor キーセットページネーション用のカーソル（timestamp, id）
+func encodeLogCursor(record LogRecord) string {
+	raw := fmt.Sprintf("%d:%d", record.Timestamp.UnixNano(), record.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeLogCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor: %w", err)
+	}
+	tsStr, idStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor: %w", err)
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return time.Unix(0, ts), id, nil
+}
+
+// escapeLike LIKEのワイルドカードをエスケープ
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// searchLogs DBを検索（timestamp DESC, id DESC のキーセットページネーション）
+func (h *CacheHandler) searchLogs(ctx context.Context, q LogSearchQuery) (LogSearchPage, error) {
+	db := h.DB.WithContext(ctx).Model(&LogRecord{})
+	if q.Level != "" {
+		db = db.Where("level = ?", q.Level)
+	}
+	if q.Q != "" {
+		db = db.Where("message ILIKE ?", "%"+escapeLike(q.Q)+"%")
+	}
+	if q.From != nil {
+		db = db.Where("timestamp >= ?", *q.From)
+	}
+	if q.To != nil {
+		db = db.Where("timestamp < ?", *q.To)
+	}
+	if q.Cursor != "" {
+		ts, id, err := decodeLogCursor(q.Cursor)
+		if err != nil {
+			return LogSearchPage{}, err
+		}
+		db = db.Where("(timestamp, id) < (?, ?)", ts, id)
+	}
+
+	// 次ページの有無を判定するため1件多く取得
+	var records []LogRecord
+	if err := db.Order("timestamp DESC, id DESC").Limit(q.Limit + 1).Find(&records).Error; err != nil {
+		return LogSearchPage{}, err
+	}
+
+	page := LogSearchPage{Records: records}
+	if len(records) > q.Limit {
+		page.Records = records[:q.Limit]
+		page.NextCursor = encodeLogCursor(page.Records[q.Limit-1])
+	}
+	return page, nil
+}
+
+// LogSearchHandler - 条件付きログ検索API（level, q, from, to, limit, cursor）
+func (h *CacheHandler) LogSearchHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	q, err := parseLogSearchQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := q.CacheKey()
+	rule := q.ttlRule(start)
+	result, err := h.searches.Fetch(r.Context(), key, func(ctx context.Context) (LogSearchPage, error) {
+		return h.searchLogs(ctx, q)
+	}, cache.WithTTL(rule.TTL), cache.WithTags(q.Tags()...))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, LogSearchResult{
+		LogSearchPage: result.Value,
+		Query:         q,
+		Source:        string(result.Source),
+		CacheKey:      key,
+		Shape:         rule.Shape,
+		TTLSeconds:    int64(rule.TTL.Seconds()),
+		DurationMs:    time.Since(start).Milliseconds(),
+	})
+}
+
+// LogSearchInvalidateHandler - 検索結果のキャッシュをレベル単位（省略時は全件）で無効化するAPI
+func (h *CacheHandler) LogSearchInvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed. Use POST.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tag := searchTagAll
+	if level := strings.ToUpper(r.URL.Query().Get("level")); level != "" {
+		if !validLogLevels[level] {
+			http.Error(w, fmt.Sprintf("invalid level %q", level), http.StatusBadRequest)
+			return
+		}
+		tag = "level:" + level
+	}
+
+	n, err := h.searches.InvalidateTag(r.Context(), tag)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to invalidate: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, map[string]interface{}{
+		"message":     "Search cache invalidated",
+		"tag":         tag,
+		"invalidated": n,
+	})
+}
+
+// invalidateSearchesFor ログの追加に合わせて影響する検索結果を無効化
+func (h *CacheHandler) invalidateSearchesFor(ctx context.Context, record LogRecord) error {
+	for _, tag := range []string{"level:" + record.Level, searchTagAnyLevel} {
+		if _, err := h.searches.InvalidateTag(ctx, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,10 +6,14 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/keito-isurugi/go-demo/egress"
 )
 
 // ParallelFetchHandler は複数URLを並列でGETし、最速レスポンスを返す
-type ParallelFetchHandler struct{}
+type ParallelFetchHandler struct {
+	Guard *egress.Guard // 外部へのリクエストの制限（nilなら既定の設定）
+}
 
 // URLRequest はリクエストボディの構造
 type URLRequest struct {
@@ -166,9 +170,8 @@ func (h *ParallelFetchHandler) fetchURL(ctx context.Context, url string) URLResp
 		}
 	}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
+	// 内部のアドレスやリダイレクト先への接続はGuardが拒否する
+	client := egressClient(h.Guard, 10*time.Second)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	"github.com/keito-isurugi/go-demo/benchmark"
 	"github.com/keito-isurugi/go-demo/books"
+	"github.com/keito-isurugi/go-demo/bulkload"
+	"github.com/keito-isurugi/go-demo/cache"
+	"github.com/keito-isurugi/go-demo/egress"
 	"github.com/keito-isurugi/go-demo/handler"
 	"github.com/keito-isurugi/go-demo/handler/bank"
 	"github.com/keito-isurugi/go-demo/middleware"
@@ -14,6 +19,7 @@ import (
 
 	"log"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -38,11 +44,24 @@ func main() {
 		log.Printf("Warning: Redis connection failed: %v", err)
 	}
 
-	// CacheHandlerの初期化
-	cacheHandler := &handler.CacheHandler{
-		DB:    dbConn,
-		Redis: rdb,
+	// ベンチマーク用にDBクエリ数・Redis往復回数を計測できるようにする
+	if err := benchmark.InstrumentGorm(dbConn); err != nil {
+		log.Fatalf("Failed to instrument database: %v", err)
 	}
+	benchmark.InstrumentRedis(rdb)
+
+	// COPYによるテストデータ投入ジョブ（キャッシュ・銀行・インデックスのデモで共有）
+	bulkJobs := bulkload.NewManager(ctx)
+	bulkLoadHandler := &handler.BulkLoadHandler{DB: dbConn, Jobs: bulkJobs}
+
+	// CacheHandlerの初期化
+	cacheHandler := handler.NewCacheHandler(dbConn, rdb, bulkJobs)
+	// 他インスタンスへのキャッシュ無効化通知（Redis Pub/Sub）
+	cacheBus := cache.NewBus(rdb, cache.DefaultBusChannel)
+	cacheHandler.AttachBus(cacheBus)
+	go cacheBus.Run(ctx)
+	// log_recordsの変更通知（LISTEN/NOTIFY）でキャッシュを更新
+	go cacheHandler.ListenLogChanges(ctx, dsn)
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "Hello, World!!!")
@@ -66,6 +85,29 @@ func main() {
 	http.HandleFunc("/demo/cache/without", cacheHandler.CacheWithoutHandler)
 	// Redisキャッシュをクリア
 	http.HandleFunc("/demo/cache/clear", cacheHandler.ClearCacheHandler)
+	// キャッシュのヒット・ミス統計
+	http.HandleFunc("/demo/cache/stats", cacheHandler.CacheStatsHandler)
+	// ログを1件追加（キャッシュはLISTEN/NOTIFY経由で更新）
+	http.HandleFunc("/demo/cache/logs", cacheHandler.CreateLogHandler)
+	// 条件付きログ検索（level, q, from, to, limit, cursor）
+	http.HandleFunc("/demo/cache/logs/search", cacheHandler.LogSearchHandler)
+	// 検索結果キャッシュをレベル単位で無効化（?level=ERROR、省略時は全件）
+	http.HandleFunc("/demo/cache/logs/search/invalidate", cacheHandler.LogSearchInvalidateHandler)
+	// キャッシュスタンピード対策の比較（?n=同時リクエスト数）
+	http.HandleFunc("/demo/cache/stampede", cacheHandler.CacheStampedeHandler)
+	// キャッシュあり・なしの負荷比較（?concurrency=10&duration=5s&format=html）
+	http.HandleFunc("/demo/cache/benchmark", cacheHandler.CacheBenchmarkHandler)
+	// キャッシュ値のCodec・圧縮方式ごとのサイズ・速度比較
+	http.HandleFunc("/demo/cache/codecs", cacheHandler.CacheCodecCompareHandler)
+
+	// インデックス性能デモ用テーブルへの投入ジョブを起動
+	http.HandleFunc("/demo/bulkload", bulkLoadHandler.StartHandler)
+	// 投入ジョブの一覧・進捗（ポーリング）
+	http.HandleFunc("/demo/bulkload/jobs", bulkLoadHandler.JobsHandler)
+	// 投入ジョブの進捗（SSE）
+	http.HandleFunc("/demo/bulkload/jobs/events", bulkLoadHandler.JobEventsHandler)
+	// 投入ジョブの中断
+	http.HandleFunc("/demo/bulkload/jobs/cancel", bulkLoadHandler.CancelJobHandler)
 
 	// レート制限付きAPI (1分間に10回まで)
 	rateLimiter := middleware.NewRateLimiter(10, time.Minute)
@@ -99,40 +141,189 @@ func main() {
 	http.HandleFunc("/api/security/sql-injection/vulnerable", securityHandler.SQLInjectionVulnerableHandler)
 	http.HandleFunc("/api/security/sql-injection/secure", securityHandler.SQLInjectionSecureHandler)
 
+	// 適応型同時実行数リミッタ（ルートグループごと）
+	fetchLimiter := middleware.NewAdaptiveLimiter("fetch", middleware.DefaultAdaptiveLimiterConfig())
+	aggregateLimiter := middleware.NewAdaptiveLimiter("aggregate", middleware.DefaultAdaptiveLimiterConfig())
+	bankLimiterConfig := middleware.DefaultAdaptiveLimiterConfig()
+	// 口座の初期化は最初に捨てる
+	bankLimiterConfig.Classify = middleware.PathClassifier(map[string]middleware.Priority{
+		"/api/bank/init":      middleware.PriorityLow,
+		"/api/bank/init-bulk": middleware.PriorityLow,
+	}, middleware.DefaultClassifier)
+	bankLimiter := middleware.NewAdaptiveLimiter("bank", bankLimiterConfig)
+	// time.Sleepでトランザクションを保持するデモや負荷試験は数秒〜数十秒かかるため、
+	// 振込などのレイテンシの基準を崩さないよう別のリミッタにする
+	bankDemoLimiter := middleware.NewAdaptiveLimiter("bank-demo", middleware.DefaultAdaptiveLimiterConfig())
+	limiters := []*middleware.AdaptiveLimiter{fetchLimiter, aggregateLimiter, bankLimiter, bankDemoLimiter}
+
+	// ヘルスチェック（リミッタの状態も返す）
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		stats := make([]middleware.LimiterStats, 0, len(limiters))
+		for _, l := range limiters {
+			stats = append(stats, l.Stats())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "ok",
+			"limiters": stats,
+		}); err != nil {
+			return
+		}
+	})
+
+	// ユーザーが指定したURLへのリクエストのSSRF対策（並列URL取得APIと集約APIで共有）
+	egressConfig := egress.DefaultConfig()
+	// compose のサービス名・コンテナ名は内部のアドレスとして接続時にも拒否されるが、名前解決する前に弾く
+	egressConfig.DeniedHosts = append(egressConfig.DeniedHosts,
+		"app", "db", "redis", "pgadmin", "go-demo", "go-demo-db", "go-demo-redis", "go-demo-pgadmin")
+	egressGuard := egress.New(egressConfig)
+
 	// 並列URL取得API
-	parallelFetchHandler := &handler.ParallelFetchHandler{}
+	parallelFetchHandler := &handler.ParallelFetchHandler{Guard: egressGuard}
 	// 最速レスポンスを返す
-	http.HandleFunc("/api/fetch/fastest", parallelFetchHandler.FetchFastestHandler)
+	http.Handle("/api/fetch/fastest", fetchLimiter.Middleware(http.HandlerFunc(parallelFetchHandler.FetchFastestHandler)))
 	// 全結果を返す
-	http.HandleFunc("/api/fetch/all", parallelFetchHandler.FetchAllHandler)
+	http.Handle("/api/fetch/all", fetchLimiter.Middleware(http.HandlerFunc(parallelFetchHandler.FetchAllHandler)))
 
 	// 複数API並列実行・集約API
-	aggregateHandler := &handler.AggregateAPIHandler{}
+	aggregateHandler := &handler.AggregateAPIHandler{Guard: egressGuard}
 	// カスタムAPIを並列実行して集約
-	http.HandleFunc("/api/aggregate", aggregateHandler.AggregateHandler)
+	http.Handle("/api/aggregate", aggregateLimiter.Middleware(http.HandlerFunc(aggregateHandler.AggregateHandler)))
 	// プリセットAPIを並列実行して集約（デモ用）
-	http.HandleFunc("/api/aggregate/preset", aggregateHandler.PresetAggregateHandler)
+	http.Handle("/api/aggregate/preset", aggregateLimiter.Middleware(http.HandlerFunc(aggregateHandler.PresetAggregateHandler)))
 
 	// 銀行振込API
-	bankTransferHandler := &bank.Handler{DB: dbConn}
+	// テーブルと、監査ログ・残高の履歴のトリガーは起動時に1回だけ作成する
+	if err := bank.Migrate(dbConn); err != nil {
+		log.Fatalf("Failed to migrate bank tables: %v", err)
+	}
+	bankTransferHandler := &bank.Handler{DB: dbConn, Jobs: bulkJobs}
+	// 監査ログのチェックポイントの署名鍵（再起動しても同じ鍵で検証できるよう、設定から読み込む）
+	if seed := os.Getenv("AUDIT_SIGNING_KEY"); seed != "" {
+		if bankTransferHandler.AuditKey, err = bank.ParseAuditKey(seed); err != nil {
+			log.Fatalf("Invalid AUDIT_SIGNING_KEY: %v", err)
+		}
+	}
+	if bankTransferHandler.AuditTrustedKeys, err = bank.ParseAuditPublicKeys(os.Getenv("AUDIT_TRUSTED_KEYS")); err != nil {
+		log.Fatalf("Invalid AUDIT_TRUSTED_KEYS: %v", err)
+	}
+	// 他の接続のクエリを止められる操作なので、明示的に有効にした場合だけ使えるようにする
+	bankTransferHandler.EnableBackendSignals = os.Getenv("BANK_ENABLE_BACKEND_SIGNALS") == "true"
+	// 保持期間を過ぎたIdempotency-Keyを定期的に削除
+	go bankTransferHandler.RunIdempotencyKeyCleanup(ctx, time.Hour)
+	// 実行日時を過ぎた予約振込・定期振込を実行
+	go bankTransferHandler.RunScheduledTransferWorker(ctx, 30*time.Second, 100)
+	// 口座のイベントをoutboxから配信（送信先はログ。RabbitMQに送る場合はOutboxにPublisherSinkのリレーを設定する）
+	go bankTransferHandler.RunOutboxRelay(ctx, time.Second)
+	// 確定した取引を監査ログ（ハッシュチェーン）に追記し、10分ごとに署名付きチェックポイントを作成
+	go bankTransferHandler.RunAuditSealer(ctx, 10*time.Second, 10*time.Minute)
+	bankRoute := func(pattern string, h http.HandlerFunc) {
+		http.Handle(pattern, bankLimiter.Middleware(h))
+	}
+	bankDemoRoute := func(pattern string, h http.HandlerFunc) {
+		http.Handle(pattern, bankDemoLimiter.Middleware(h))
+	}
 	// テスト用口座を初期化
-	http.HandleFunc("/api/bank/init", bankTransferHandler.InitAccountsHandler)
+	bankRoute("/api/bank/init", bankTransferHandler.InitAccountsHandler)
+	// 大量の口座をCOPYで作成（バックグラウンドジョブ）
+	bankRoute("/api/bank/init-bulk", bankTransferHandler.InitBulkAccountsHandler)
 	// 通常の振込処理
-	http.HandleFunc("/api/bank/transfer", bankTransferHandler.NormalTransferHandler)
+	bankRoute("/api/bank/transfer", bankTransferHandler.NormalTransferHandler)
 	// 口座情報を取得
-	http.HandleFunc("/api/bank/account", bankTransferHandler.GetAccountHandler)
+	bankRoute("/api/bank/account", bankTransferHandler.GetAccountHandler)
 	// 全口座一覧を取得
-	http.HandleFunc("/api/bank/accounts", bankTransferHandler.ListAccountsHandler)
+	bankRoute("/api/bank/accounts", bankTransferHandler.ListAccountsHandler)
 	// Dirty Readデモ
-	http.HandleFunc("/api/bank/dirty-read", bankTransferHandler.DirtyReadDemoHandler)
+	bankDemoRoute("/api/bank/dirty-read", bankTransferHandler.DirtyReadDemoHandler)
 	// Phantom Readデモ
-	http.HandleFunc("/api/bank/phantom-read", bankTransferHandler.PhantomReadDemoHandler)
+	bankDemoRoute("/api/bank/phantom-read", bankTransferHandler.PhantomReadDemoHandler)
+	// 分離レベルごとの異常（lost update, non-repeatable read, read skew, write skew）の再現
+	bankDemoRoute("/api/bank/anomalies", bankTransferHandler.AnomalyDemoHandler)
+	// トランザクションのシナリオをステップ実行してトレースを返す（dirty-read, phantom-read, deadlockなど）
+	bankDemoRoute("/api/bank/scenarios", bankTransferHandler.ScenarioHandler)
 	// デッドロックデモ
-	http.HandleFunc("/api/bank/deadlock", bankTransferHandler.DeadlockDemoHandler)
+	bankDemoRoute("/api/bank/deadlock", bankTransferHandler.DeadlockDemoHandler)
 	// デッドロック回避策1: ロック順序の統一
-	http.HandleFunc("/api/bank/transfer-safe", bankTransferHandler.DeadlockAvoidanceHandler)
+	bankRoute("/api/bank/transfer-safe", bankTransferHandler.DeadlockAvoidanceHandler)
 	// デッドロック回避策2: タイムアウト設定
-	http.HandleFunc("/api/bank/transfer-timeout", bankTransferHandler.DeadlockTimeoutHandler)
+	bankRoute("/api/bank/transfer-timeout", bankTransferHandler.DeadlockTimeoutHandler)
+	// 楽観ロック（versionカラム）による振込
+	bankRoute("/api/bank/transfer-optimistic", bankTransferHandler.OptimisticTransferHandler)
+	// 振込処理のストレステスト（残高の保存・マイナス残高・取引履歴と残高の整合性を検証）
+	bankDemoRoute("/api/bank/stress", bankTransferHandler.StressTestHandler)
+	// 通貨を指定した口座開設
+	bankRoute("/api/bank/accounts/open", bankTransferHandler.OpenAccountHandler)
+	// 為替レートの一覧・登録（有効開始日時つき）
+	bankRoute("/api/bank/fx-rates", bankTransferHandler.FxRatesHandler)
+	// 為替換算の試算
+	bankRoute("/api/bank/fx-quote", bankTransferHandler.FxQuoteHandler)
+	// 予約振込・定期振込の一覧・登録
+	bankRoute("/api/bank/scheduled-transfers", bankTransferHandler.ScheduledTransfersHandler)
+	// 予約振込の取り消し
+	bankRoute("/api/bank/scheduled-transfers/cancel", bankTransferHandler.CancelScheduledTransferHandler)
+	// 実行日時を過ぎた予約振込をすぐに実行
+	bankRoute("/api/bank/scheduled-transfers/run-due", bankTransferHandler.RunDueScheduledTransfersHandler)
+	// 通知の一覧（予約振込の失敗など）
+	bankRoute("/api/bank/notifications", bankTransferHandler.NotificationsHandler)
+	// 振込ルール（限度額・不正検知）の一覧・作成・更新
+	bankRoute("/api/bank/transfer-rules", bankTransferHandler.TransferRulesHandler)
+	// 振込ルールで審査待ちになった振込の一覧
+	bankRoute("/api/bank/transfer-reviews", bankTransferHandler.TransferReviewsHandler)
+	// 審査待ちの振込の承認・却下
+	bankRoute("/api/bank/transfer-reviews/decide", bankTransferHandler.DecideTransferReviewHandler)
+	// 未配信・配信済みのイベント（outbox）の一覧
+	bankRoute("/api/bank/outbox", bankTransferHandler.OutboxHandler)
+	// outboxの未配信のイベントをすぐに配信
+	bankRoute("/api/bank/outbox/relay", bankTransferHandler.OutboxRelayHandler)
+	// 確定した取引をすぐに監査ログに追記してチェックポイントを作成
+	bankRoute("/api/bank/audit/seal", bankTransferHandler.AuditSealHandler)
+	// 監査ログのハッシュチェーンと署名を検証し、最初に壊れている箇所を返す
+	bankRoute("/api/bank/audit/verify", bankTransferHandler.AuditVerifyHandler)
+	// 監査ログのチェックポイントの一覧
+	bankRoute("/api/bank/audit/checkpoints", bankTransferHandler.AuditCheckpointsHandler)
+	// ロック待ちの診断（waits-forグラフ、循環、根本のブロッカー）。?stream=1 でSSEの連続サンプリング
+	// SSEは最大5分つながるため、枠を占有しないようリミッタを通さない
+	lockSnapshot := bankLimiter.Middleware(http.HandlerFunc(bankTransferHandler.LockDiagnosticsHandler))
+	http.HandleFunc("/api/bank/locks", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "" {
+			bankTransferHandler.LockDiagnosticsHandler(w, r)
+			return
+		}
+		lockSnapshot.ServeHTTP(w, r)
+	})
+	// ブロックしているバックエンドをキャンセルまたは終了させる（BANK_ENABLE_BACKEND_SIGNALS=true の場合のみ）
+	bankRoute("/api/bank/locks/signal", bankTransferHandler.SignalBackendHandler)
+	// 悲観ロックと楽観ロックの負荷比較
+	bankDemoRoute("/api/bank/lock-benchmark", bankTransferHandler.LockBenchmarkHandler)
+	// SERIALIZABLE分離レベルでの振込（40001/40P01は自動でやり直す）
+	bankRoute("/api/bank/transfer-serializable", bankTransferHandler.SerializableTransferHandler)
+	// SERIALIZABLE振込のリトライ統計
+	bankRoute("/api/bank/serializable/stats", bankTransferHandler.SerializableStatsHandler)
+	// write skewデモ（共同名義口座の残高合計の下限）
+	bankDemoRoute("/api/bank/write-skew", bankTransferHandler.WriteSkewDemoHandler)
+	// 口座の明細（取引後の残高付き、format=csvでCSVストリーミング）
+	bankRoute("/api/bank/statement", bankTransferHandler.StatementHandler)
+	// 入金
+	bankRoute("/api/bank/deposit", bankTransferHandler.DepositHandler)
+	// 出金（口座種別ごとの当座貸越・最低残高ルール）
+	bankRoute("/api/bank/withdraw", bankTransferHandler.WithdrawHandler)
+	// 元帳の整合性チェック（全記帳の合計が0、口座残高と記帳の一致）
+	bankRoute("/api/bank/ledger/check", bankTransferHandler.LedgerCheckHandler)
+	// 既存口座の残高を開始残高として元帳に移行
+	bankRoute("/api/bank/ledger/migrate", bankTransferHandler.LedgerMigrateHandler)
+	// 有効日時valid_atの残高をknown_atの時点の認識で照会（二時点の履歴）
+	bankRoute("/api/bank/accounts/balance-as-of", bankTransferHandler.BalanceAsOfHandler)
+	// 口座残高の履歴（有効日時と記録日時）
+	bankRoute("/api/bank/accounts/balance-history", bankTransferHandler.BalanceHistoryHandler)
+	// 過去の有効日時に遡る訂正仕訳（履歴は書き換えずに訂正後の版を追記）
+	bankRoute("/api/bank/corrections", bankTransferHandler.CorrectionHandler)
+	// リミッタ経由のヘルスチェック（Criticalとして最後まで受け付ける）
+	bankRoute("/api/bank/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(bankLimiter.Stats()); err != nil {
+			return
+		}
+	})
 
 	fmt.Println("localhost:8080 server runnig ...")
 	log.Fatal(http.ListenAndServe(":8080", nil))
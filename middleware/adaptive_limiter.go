@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority リクエストの優先度クラス（値が小さいほど最後まで捨てられない）
+type Priority int
+
+const (
+	// PriorityCritical ヘルスチェックなど、常に最後まで受け付けるリクエスト
+	PriorityCritical Priority = iota
+	// PriorityHigh 軽量な参照系リクエスト
+	PriorityHigh
+	// PriorityNormal 通常のリクエスト
+	PriorityNormal
+	// PriorityLow 重いデモなど、最初に捨ててよいリクエスト
+	PriorityLow
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityCritical:
+		return "critical"
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	case PriorityLow:
+		return "low"
+	default:
+		return "unknown"
+	}
+}
+
+// prioritySharesは各優先度が使用できる同時実行枠の割合
+var priorityShares = map[Priority]float64{
+	PriorityCritical: 1.0,
+	PriorityHigh:     0.9,
+	PriorityNormal:   0.75,
+	PriorityLow:      0.5,
+}
+
+// AdaptiveLimiterConfig 適応型同時実行数リミッタの設定
+type AdaptiveLimiterConfig struct {
+	InitialLimit int           // 初期の同時実行上限
+	MinLimit     int           // 同時実行上限の下限
+	MaxLimit     int           // 同時実行上限の上限
+	BackoffRatio float64       // レイテンシ悪化時に上限へ掛ける係数（AIMDの乗算減少）
+	Tolerance    float64       // 無負荷レイテンシの何倍までを許容するか
+	MinRetry     time.Duration // Retry-Afterの最小値
+	// Classify リクエストの優先度を決める関数（nilの場合はDefaultClassifier）
+	Classify func(r *http.Request) Priority
+	// Route 無負荷レイテンシを分けて記録する単位を決める関数（nilの場合はURLのパス）
+	Route func(r *http.Request) string
+}
+
+// maxLatencyRoutes 無負荷レイテンシを分けて記録するルートの上限（超えた分は共有の基準を使う）
+const maxLatencyRoutes = 64
+
+// DefaultAdaptiveLimiterConfig デフォルト設定を返す
+func DefaultAdaptiveLimiterConfig() AdaptiveLimiterConfig {
+	return AdaptiveLimiterConfig{
+		InitialLimit: 20,
+		MinLimit:     2,
+		MaxLimit:     200,
+		BackoffRatio: 0.9,
+		Tolerance:    2.0,
+		MinRetry:     time.Second,
+		Classify:     DefaultClassifier,
+	}
+}
+
+// DefaultClassifier ヘルスチェックはCritical、GET/HEADはHigh、それ以外はNormalとする
+func DefaultClassifier(r *http.Request) Priority {
+	if strings.HasSuffix(r.URL.Path, "/health") {
+		return PriorityCritical
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// PathClassifier パスごとに優先度を指定し、未指定のパスはfallbackで判定する
+func PathClassifier(priorities map[string]Priority, fallback func(r *http.Request) Priority) func(r *http.Request) Priority {
+	return func(r *http.Request) Priority {
+		if p, ok := priorities[r.URL.Path]; ok {
+			return p
+		}
+		if fallback == nil {
+			return DefaultClassifier(r)
+		}
+		return fallback(r)
+	}
+}
+
+// AdaptiveLimiter 観測したレイテンシに基づいて同時実行数の上限をAIMDで調整するリミッタ
+//
+// レイテンシがそのルートの無負荷時の Tolerance 倍を超えたら上限を BackoffRatio 倍に下げ（乗算減少）、
+// 上限の半分以上を使っている状態で良好なレイテンシが続けば上限を1ずつ上げる（加算増加）。
+// 上限に達したリクエストは優先度の低いものから 503 + Retry-After で捨てる。
+// 無負荷レイテンシはルートごとに持つため、数ミリ秒の参照と数秒かかる処理が同じグループにあっても、
+// 遅いルートのレイテンシだけで上限が下がり続けることはない。
+type AdaptiveLimiter struct {
+	name   string
+	config AdaptiveLimiterConfig
+
+	mu            sync.Mutex
+	limit         float64
+	inflight      int
+	noLoadLatency map[string]time.Duration // ルートごとに観測した最小レイテンシ（緩やかに忘却する）
+	avgLatency    time.Duration            // 指数移動平均レイテンシ
+	accepted      map[Priority]int64
+	shed          map[Priority]int64
+}
+
+// NewAdaptiveLimiter ルートグループ用の適応型リミッタを作成
+func NewAdaptiveLimiter(name string, config AdaptiveLimiterConfig) *AdaptiveLimiter {
+	defaults := DefaultAdaptiveLimiterConfig()
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = defaults.InitialLimit
+	}
+	if config.MinLimit <= 0 {
+		config.MinLimit = defaults.MinLimit
+	}
+	if config.MaxLimit < config.InitialLimit {
+		config.MaxLimit = max(defaults.MaxLimit, config.InitialLimit)
+	}
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		config.BackoffRatio = defaults.BackoffRatio
+	}
+	if config.Tolerance <= 1 {
+		config.Tolerance = defaults.Tolerance
+	}
+	if config.MinRetry <= 0 {
+		config.MinRetry = defaults.MinRetry
+	}
+	if config.Classify == nil {
+		config.Classify = DefaultClassifier
+	}
+	if config.Route == nil {
+		config.Route = func(r *http.Request) string { return r.URL.Path }
+	}
+
+	return &AdaptiveLimiter{
+		name:          name,
+		config:        config,
+		limit:         float64(config.InitialLimit),
+		noLoadLatency: make(map[string]time.Duration),
+		accepted:      make(map[Priority]int64),
+		shed:          make(map[Priority]int64),
+	}
+}
+
+// tryAcquire 優先度に応じた枠が空いていれば実行中数を増やす
+func (l *AdaptiveLimiter) tryAcquire(p Priority) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	share, ok := priorityShares[p]
+	if !ok {
+		share = priorityShares[PriorityLow]
+	}
+	allowed := int(math.Max(1, math.Floor(l.limit*share)))
+	if l.inflight >= allowed {
+		l.shed[p]++
+		return false
+	}
+
+	l.inflight++
+	l.accepted[p]++
+	return true
+}
+
+// release 実行完了を記録し、観測したレイテンシをそのルートの無負荷レイテンシと比べて上限を調整する
+func (l *AdaptiveLimiter) release(route string, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inflight := l.inflight
+	l.inflight--
+
+	// ルートが多すぎる場合は共有の基準にまとめる
+	noLoad, ok := l.noLoadLatency[route]
+	if !ok && len(l.noLoadLatency) >= maxLatencyRoutes {
+		route = ""
+		noLoad = l.noLoadLatency[route]
+	}
+	// 無負荷レイテンシ: 最小値を採用しつつ、少しずつ上方向へ忘却させる
+	if noLoad == 0 || latency < noLoad {
+		noLoad = latency
+	} else {
+		noLoad += (latency - noLoad) / 100
+	}
+	l.noLoadLatency[route] = noLoad
+
+	if l.avgLatency == 0 {
+		l.avgLatency = latency
+	} else {
+		l.avgLatency += (latency - l.avgLatency) / 10
+	}
+
+	threshold := time.Duration(float64(noLoad) * l.config.Tolerance)
+	switch {
+	case latency > threshold:
+		// 乗算減少
+		l.limit = math.Max(float64(l.config.MinLimit), l.limit*l.config.BackoffRatio)
+	case float64(inflight)*2 >= l.limit:
+		// 枠を使い切りつつある時だけ加算増加
+		l.limit = math.Min(float64(l.config.MaxLimit), l.limit+1)
+	}
+}
+
+// retryAfter 平均レイテンシから再試行までの秒数を見積もる
+func (l *AdaptiveLimiter) retryAfter() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wait := max(l.avgLatency, l.config.MinRetry)
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// Middleware リミッタを適用するミドルウェア
+func (l *AdaptiveLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := l.config.Classify(r)
+		route := l.config.Route(r)
+		if !l.tryAcquire(p) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(l.retryAfter()))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if err := json.NewEncoder(w).Encode(map[string]string{
+				"error":    "Server is overloaded. Please retry later.",
+				"group":    l.name,
+				"priority": p.String(),
+			}); err != nil {
+				return
+			}
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.release(route, time.Since(start))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LimiterStats リミッタの状態
+type LimiterStats struct {
+	Name            string             `json:"name"`
+	Limit           float64            `json:"limit"`
+	Inflight        int                `json:"inflight"`
+	NoLoadLatencyMs map[string]float64 `json:"no_load_latency_ms"` // ルートごと
+	AvgLatencyMs    float64            `json:"avg_latency_ms"`
+	Accepted        map[string]int64   `json:"accepted"`
+	Shed            map[string]int64   `json:"shed"`
+}
+
+// Stats 現在の状態を返す
+func (l *AdaptiveLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := LimiterStats{
+		Name:            l.name,
+		Limit:           l.limit,
+		Inflight:        l.inflight,
+		NoLoadLatencyMs: make(map[string]float64, len(l.noLoadLatency)),
+		AvgLatencyMs:    float64(l.avgLatency.Microseconds()) / 1000,
+		Accepted:        make(map[string]int64),
+		Shed:            make(map[string]int64),
+	}
+	for route, d := range l.noLoadLatency {
+		stats.NoLoadLatencyMs[route] = float64(d.Microseconds()) / 1000
+	}
+	for p, n := range l.accepted {
+		stats.Accepted[p.String()] = n
+	}
+	for p, n := range l.shed {
+		stats.Shed[p.String()] = n
+	}
+	return stats
+}
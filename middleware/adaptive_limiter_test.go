@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter_ShedsLowPriorityFirst(t *testing.T) {
+	l := NewAdaptiveLimiter("test", AdaptiveLimiterConfig{InitialLimit: 4, MinLimit: 1, MaxLimit: 4})
+
+	// 上限4: Low(0.5)は2、Normal(0.75)は3、High(0.9)は3、Criticalは4まで
+	if !l.tryAcquire(PriorityLow) || !l.tryAcquire(PriorityLow) {
+		t.Fatal("expected first two low priority requests to be accepted")
+	}
+	if l.tryAcquire(PriorityLow) {
+		t.Error("expected third low priority request to be shed")
+	}
+	if !l.tryAcquire(PriorityNormal) {
+		t.Error("expected normal priority request to be accepted")
+	}
+	if l.tryAcquire(PriorityHigh) {
+		t.Error("expected high priority request to be shed at 3 inflight")
+	}
+	if !l.tryAcquire(PriorityCritical) {
+		t.Error("expected critical request to be accepted")
+	}
+	if l.tryAcquire(PriorityCritical) {
+		t.Error("expected critical request to be shed when limit is reached")
+	}
+}
+
+func TestAdaptiveLimiter_AIMD(t *testing.T) {
+	l := NewAdaptiveLimiter("test", AdaptiveLimiterConfig{InitialLimit: 10, MinLimit: 2, MaxLimit: 20})
+
+	// 上限の半分以上を使っている状態で良好なレイテンシなら加算増加
+	for i := 0; i < 5; i++ {
+		l.tryAcquire(PriorityCritical)
+	}
+	l.release("/", 10*time.Millisecond)
+	if got := l.Stats().Limit; got != 11 {
+		t.Errorf("limit after good latency = %v; expected 11", got)
+	}
+
+	// 無負荷レイテンシの2倍を超えたら乗算減少
+	l.release("/", 100*time.Millisecond)
+	if got := l.Stats().Limit; got != 11*0.9 {
+		t.Errorf("limit after bad latency = %v; expected %v", got, 11*0.9)
+	}
+
+	// 下限を下回らない
+	for i := 0; i < 20; i++ {
+		l.tryAcquire(PriorityCritical)
+		l.release("/", time.Second)
+	}
+	if got := l.Stats().Limit; got != 2 {
+		t.Errorf("limit = %v; expected to be clamped to 2", got)
+	}
+}
+
+func TestAdaptiveLimiter_PerRouteBaseline(t *testing.T) {
+	l := NewAdaptiveLimiter("test", AdaptiveLimiterConfig{InitialLimit: 10, MinLimit: 2, MaxLimit: 20})
+
+	// 速いルートの基準を作ってから、遅いルートを何度完了させても上限は下がらない
+	l.tryAcquire(PriorityCritical)
+	l.release("/fast", 5*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		l.tryAcquire(PriorityCritical)
+		l.release("/slow", 5*time.Second)
+	}
+	if got := l.Stats().Limit; got != 10 {
+		t.Errorf("limit after slow route = %v; expected 10", got)
+	}
+
+	// 同じルートの中でレイテンシが悪化した場合は乗算減少
+	l.tryAcquire(PriorityCritical)
+	l.release("/fast", 50*time.Millisecond)
+	if got := l.Stats().Limit; got != 10*0.9 {
+		t.Errorf("limit after slow fast-route request = %v; expected %v", got, 10*0.9)
+	}
+
+	stats := l.Stats()
+	if stats.NoLoadLatencyMs["/fast"] >= stats.NoLoadLatencyMs["/slow"] {
+		t.Errorf("no load latency = %v; expected separate baselines per route", stats.NoLoadLatencyMs)
+	}
+}
+
+func TestAdaptiveLimiter_MiddlewareReturns503WithRetryAfter(t *testing.T) {
+	l := NewAdaptiveLimiter("test", AdaptiveLimiterConfig{InitialLimit: 1, MinLimit: 1, MaxLimit: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/aggregate", nil))
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/aggregate", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d; expected %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	close(release)
+	wg.Wait()
+}
@@ -0,0 +1,109 @@
+// Package outbox はトランザクショナルアウトボックスの実装
+//
+// 業務データの更新と同じトランザクションでイベントを outbox_messages に書き込み、
+// Relay が別のトランザクションで未配信の行をSinkに送る。DBのコミットとメッセージの送信が
+// 片方だけ成功することはなく、送信後に配信済みの記録が失敗した場合は再送する（at-least-once）。
+// 受信側はMessage.IDで重複を取り除く。
+//
+// 同じ集約（AggregateType + AggregateID）のメッセージは書き込んだ順に配信し、
+// 先のメッセージが配信できるまで後のメッセージは送らない。書き込み時に集約ごとのアドバイザリロックを
+// コミットまで保持するため、同じ集約ではIDの順とコミットの順が一致する。
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Message アウトボックスの1行
+type Message struct {
+	ID            uint64     `gorm:"primaryKey" json:"id"`
+	AggregateType string     `gorm:"size:50;not null;index:idx_outbox_aggregate,priority:1" json:"aggregate_type"` // account, order など
+	AggregateID   string     `gorm:"size:100;not null;index:idx_outbox_aggregate,priority:2" json:"aggregate_id"`
+	EventType     string     `gorm:"size:100;not null" json:"event_type"`
+	Payload       string     `gorm:"type:jsonb;not null" json:"payload"`
+	OccurredAt    time.Time  `gorm:"not null" json:"occurred_at"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	LastError     string     `gorm:"size:500" json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `gorm:"not null;index" json:"next_attempt_at"` // 送信に失敗した場合、この日時まで再送しない
+	DeliveredAt   *time.Time `gorm:"index" json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// TableName テーブル名
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+// Event 集約で起きた出来事
+//
+// demo/ddd の domain.DomainEvent と同じメソッドを持つので、注文のドメインイベントもそのまま書き込める。
+type Event interface {
+	OccurredAt() time.Time
+	AggregateID() string
+	EventType() string
+}
+
+// Migrate outbox_messages を作成・更新
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Message{})
+}
+
+// Enqueue 呼び出し元のトランザクションでメッセージを書き込む（payloadはJSONにする）
+func Enqueue(tx *gorm.DB, aggregateType, aggregateID, eventType string, payload interface{}) (*Message, error) {
+	return enqueue(tx, aggregateType, aggregateID, eventType, payload, time.Now())
+}
+
+// EnqueueEvent イベント自体をpayloadとして書き込む（発生日時はイベントのものを使う）
+func EnqueueEvent(tx *gorm.DB, aggregateType string, event Event) (*Message, error) {
+	return enqueue(tx, aggregateType, event.AggregateID(), event.EventType(), event, event.OccurredAt())
+}
+
+func enqueue(tx *gorm.DB, aggregateType, aggregateID, eventType string, payload interface{}, occurredAt time.Time) (*Message, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s payload: %w", eventType, err)
+	}
+	// IDを採番してからコミットするまで、同じ集約の書き込みを待たせる
+	// （先に採番したトランザクションが後からコミットすると、リレーが順序を飛ばしてしまうため）
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtextextended(?, 0))", aggregateType+":"+aggregateID).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock aggregate %s %s: %w", aggregateType, aggregateID, err)
+	}
+	msg := &Message{
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		EventType:     eventType,
+		Payload:       string(body),
+		OccurredAt:    occurredAt,
+		NextAttemptAt: time.Now(),
+	}
+	if err := tx.Create(msg).Error; err != nil {
+		return nil, fmt.Errorf("failed to enqueue %s: %w", eventType, err)
+	}
+	return msg, nil
+}
+
+// Envelope Sinkに送る形式（受信側はIDで重複を取り除く）
+type Envelope struct {
+	ID            uint64          `json:"id"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	EventType     string          `json:"event_type"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Envelope メッセージを送信用の形式にする
+func (m *Message) Envelope() Envelope {
+	return Envelope{
+		ID:            m.ID,
+		AggregateType: m.AggregateType,
+		AggregateID:   m.AggregateID,
+		EventType:     m.EventType,
+		OccurredAt:    m.OccurredAt,
+		Payload:       json.RawMessage(m.Payload),
+	}
+}
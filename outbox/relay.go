@@ -0,0 +1,214 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultBatchSize 1回のトランザクションで送る集約の数
+	DefaultBatchSize = 100
+	// DefaultRetention 配信済みの行を残す期間
+	DefaultRetention = 7 * 24 * time.Hour
+
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// Sink メッセージの送信先
+//
+// Publishがエラーを返したメッセージはあとで再送する。nilを返した後でも、
+// 配信済みの記録に失敗すれば同じメッセージをもう一度送ることがある。
+type Sink interface {
+	Publish(ctx context.Context, msg *Message) error
+}
+
+// SinkFunc 関数をSinkとして使う
+type SinkFunc func(ctx context.Context, msg *Message) error
+
+// Publish fを呼ぶ
+func (f SinkFunc) Publish(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// StringPublisher 文字列のメッセージを送るクライアント（demo/rabbitmq の Producer と同じシグネチャ）
+type StringPublisher interface {
+	Publish(message string) error
+}
+
+// PublisherSink EnvelopeをJSONにしてStringPublisherで送る
+//
+//	producer, _ := rabbitmq.NewProducer(url, "bank-events")
+//	relay := outbox.NewRelay(db, outbox.PublisherSink{Publisher: producer})
+type PublisherSink struct {
+	Publisher StringPublisher
+}
+
+// Publish EnvelopeをJSONにして送る
+func (s PublisherSink) Publish(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(msg.Envelope())
+	if err != nil {
+		return err
+	}
+	return s.Publisher.Publish(string(body))
+}
+
+// LogSink ログに出力するだけのSink（送信先を用意していない環境用）
+type LogSink struct{}
+
+// Publish ログに出力
+func (LogSink) Publish(ctx context.Context, msg *Message) error {
+	log.Printf("outbox: %s %s/%s #%d %s", msg.EventType, msg.AggregateType, msg.AggregateID, msg.ID, msg.Payload)
+	return nil
+}
+
+// Relay 未配信のメッセージをSinkに送る
+type Relay struct {
+	DB        *gorm.DB
+	Sink      Sink
+	BatchSize int
+	Retention time.Duration
+}
+
+// NewRelay 既定の設定のRelay
+func NewRelay(db *gorm.DB, sink Sink) *Relay {
+	return &Relay{DB: db, Sink: sink, BatchSize: DefaultBatchSize, Retention: DefaultRetention}
+}
+
+// RelayResult RelayOnceの結果
+type RelayResult struct {
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+}
+
+// retryDelay attempts回失敗した後、次に送るまでの時間
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// RelayOnce 集約ごとの先頭の未配信メッセージを最大BatchSize件送る
+//
+// 選んだ行は FOR UPDATE SKIP LOCKED でロックするので、複数のリレーが同時に動いても同じ行を同時に送らない。
+// 先頭以外の行は選ばないため、同じ集約のメッセージが追い越して配信されることはない。
+func (r *Relay) RelayOnce(ctx context.Context) (RelayResult, error) {
+	var result RelayResult
+	batch := r.BatchSize
+	if batch <= 0 {
+		batch = DefaultBatchSize
+	}
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var messages []Message
+		if err := tx.Raw(`SELECT * FROM outbox_messages m
+			WHERE m.delivered_at IS NULL AND m.next_attempt_at <= ?
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox_messages p
+					WHERE p.aggregate_type = m.aggregate_type AND p.aggregate_id = m.aggregate_id
+						AND p.delivered_at IS NULL AND p.id < m.id
+				)
+			ORDER BY m.id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED`, time.Now(), batch).Scan(&messages).Error; err != nil {
+			return fmt.Errorf("failed to claim outbox messages: %w", err)
+		}
+
+		for i := range messages {
+			msg := &messages[i]
+			if err := r.Sink.Publish(ctx, msg); err != nil {
+				result.Failed++
+				msg.Attempts++
+				if err := tx.Model(msg).Updates(map[string]interface{}{
+					"attempts":        msg.Attempts,
+					"last_error":      truncate(err.Error(), 500),
+					"next_attempt_at": time.Now().Add(retryDelay(msg.Attempts)),
+				}).Error; err != nil {
+					return fmt.Errorf("failed to record outbox failure: %w", err)
+				}
+				continue
+			}
+			result.Delivered++
+			if err := tx.Model(msg).Updates(map[string]interface{}{
+				"attempts":     msg.Attempts + 1,
+				"last_error":   "",
+				"delivered_at": time.Now(),
+			}).Error; err != nil {
+				return fmt.Errorf("failed to mark outbox message delivered: %w", err)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Drain 送れるものが無くなるまでRelayOnceを繰り返す（失敗した行は次の再送日時まで選ばれない）
+func (r *Relay) Drain(ctx context.Context) (RelayResult, error) {
+	var total RelayResult
+	for {
+		result, err := r.RelayOnce(ctx)
+		total.Delivered += result.Delivered
+		total.Failed += result.Failed
+		if err != nil || result.Delivered == 0 {
+			return total, err
+		}
+	}
+}
+
+// Cleanup Retentionより前に配信済みになった行を削除し、削除件数を返す
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	retention := r.Retention
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	result := r.DB.WithContext(ctx).
+		Where("delivered_at IS NOT NULL AND delivered_at < ?", time.Now().Add(-retention)).
+		Delete(&Message{})
+	return result.RowsAffected, result.Error
+}
+
+// Run intervalごとに未配信のメッセージを送り、1時間ごとに配信済みの行を削除する（ctxが終了するまで）
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastCleanup := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := r.Drain(ctx)
+			if err != nil {
+				// テーブル作成前は失敗するので次回に回す
+				log.Printf("outbox relay failed: %v", err)
+				continue
+			}
+			if result.Failed > 0 {
+				log.Printf("outbox relay: %d delivered, %d failed", result.Delivered, result.Failed)
+			}
+			if time.Since(lastCleanup) >= time.Hour {
+				lastCleanup = time.Now()
+				if n, err := r.Cleanup(ctx); err != nil {
+					log.Printf("outbox cleanup failed: %v", err)
+				} else if n > 0 {
+					log.Printf("outbox cleanup: deleted %d delivered messages", n)
+				}
+			}
+		}
+	}
+}
+
+// truncate 文字列をn文字までに切り詰める
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,234 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestRetryDelay(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for i, w := range want {
+		if got := retryDelay(i + 1); got != w {
+			t.Errorf("retryDelay(%d) = %v; expected %v", i+1, got, w)
+		}
+	}
+	if got := retryDelay(100); got != retryMaxDelay {
+		t.Errorf("retryDelay(100) = %v; expected %v", got, retryMaxDelay)
+	}
+}
+
+type recordingPublisher struct{ messages []string }
+
+func (p *recordingPublisher) Publish(message string) error {
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+func TestPublisherSink(t *testing.T) {
+	publisher := &recordingPublisher{}
+	msg := &Message{ID: 7, AggregateType: "order", AggregateID: "o-1", EventType: "OrderConfirmed", Payload: `{"total":1200}`}
+	if err := (PublisherSink{Publisher: publisher}).Publish(t.Context(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.messages) != 1 {
+		t.Fatalf("published %d messages", len(publisher.messages))
+	}
+	var got Envelope
+	if err := json.Unmarshal([]byte(publisher.messages[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 7 || got.AggregateID != "o-1" || got.EventType != "OrderConfirmed" || string(got.Payload) != `{"total":1200}` {
+		t.Errorf("envelope = %+v", got)
+	}
+}
+
+// openTestDB OUTBOX_TEST_DSNのPostgreSQLに接続し、outbox_messagesを空にする（未設定の場合はテストをスキップ）
+//
+// リレーは集約に関係なく未配信の行を送るため、BANK_TEST_DSNとは別のデータベースを指定する。
+//
+// 例: OUTBOX_TEST_DSN="host=localhost user=postgres password=postgres dbname=go_demo_outbox_test port=5432 sslmode=disable" go test ./outbox
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("OUTBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("OUTBOX_TEST_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if err := db.Exec("DELETE FROM outbox_messages").Error; err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// enqueueTestMessages 集約ごとにn件ずつ、集約をまたいで交互に書き込む
+func enqueueTestMessages(t *testing.T, db *gorm.DB, aggregateIDs []string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		for _, id := range aggregateIDs {
+			if _, err := Enqueue(db, "test", id, "test.event", map[string]int{"seq": i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// recordingSink 送ったメッセージのIDを集約ごとに記録する（failが真を返したメッセージは送信に失敗させる）
+type recordingSink struct {
+	delivered map[string][]uint64
+	fail      func(msg *Message) bool
+}
+
+func (s *recordingSink) Publish(ctx context.Context, msg *Message) error {
+	if s.fail != nil && s.fail(msg) {
+		return errors.New("broker unavailable")
+	}
+	s.delivered[msg.AggregateID] = append(s.delivered[msg.AggregateID], msg.ID)
+	return nil
+}
+
+// assertDeliveredInOrder 集約ごとにn件が書き込んだ順に送られたことを確認
+func assertDeliveredInOrder(t *testing.T, delivered map[string][]uint64, aggregateIDs []string, n int) {
+	t.Helper()
+	for _, id := range aggregateIDs {
+		ids := delivered[id]
+		if len(ids) != n {
+			t.Errorf("aggregate %s: delivered %v; expected %d messages", id, ids, n)
+			continue
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] <= ids[i-1] {
+				t.Errorf("aggregate %s: delivered out of order: %v", id, ids)
+			}
+		}
+	}
+}
+
+// 1回のRelayOnceでは集約ごとの先頭だけを送り、集約ごとに書き込んだ順に配信されること
+func TestRelay_OrderPerAggregate(t *testing.T) {
+	db := openTestDB(t)
+	aggregates := []string{"a", "b", "c"}
+	enqueueTestMessages(t, db, aggregates, 3)
+	sink := &recordingSink{delivered: map[string][]uint64{}}
+	relay := NewRelay(db, sink)
+
+	result, err := relay.RelayOnce(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Delivered != len(aggregates) {
+		t.Fatalf("first batch delivered %d; expected one per aggregate (%d)", result.Delivered, len(aggregates))
+	}
+
+	total, err := relay.Drain(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total.Delivered != 6 || total.Failed != 0 {
+		t.Errorf("drain = %+v; expected 6 delivered", total)
+	}
+	assertDeliveredInOrder(t, sink.delivered, aggregates, 3)
+}
+
+// 送信に失敗したメッセージは再送日時まで送らず、その間は同じ集約の後のメッセージも送らないこと
+func TestRelay_RetryAfterPublishFailure(t *testing.T) {
+	db := openTestDB(t)
+	aggregates := []string{"a", "b"}
+	enqueueTestMessages(t, db, aggregates, 2)
+	failed := false
+	sink := &recordingSink{delivered: map[string][]uint64{}, fail: func(msg *Message) bool {
+		if msg.AggregateID == "a" && !failed {
+			failed = true
+			return true
+		}
+		return false
+	}}
+	relay := NewRelay(db, sink)
+
+	result, err := relay.Drain(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Delivered != 2 || result.Failed != 1 {
+		t.Fatalf("drain = %+v; expected 2 delivered and 1 failed", result)
+	}
+	if n := len(sink.delivered["a"]); n != 0 {
+		t.Fatalf("delivered %d messages for a after a failure", n)
+	}
+	var head Message
+	if err := db.Where("aggregate_id = ?", "a").Order("id").First(&head).Error; err != nil {
+		t.Fatal(err)
+	}
+	if head.Attempts != 1 || head.LastError == "" || !head.NextAttemptAt.After(time.Now()) || head.DeliveredAt != nil {
+		t.Fatalf("failed message = %+v; expected a scheduled retry", head)
+	}
+
+	// 再送日時を過ぎたことにする
+	if err := db.Model(&Message{}).Where("id = ?", head.ID).Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.Drain(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	assertDeliveredInOrder(t, sink.delivered, aggregates, 2)
+	if err := db.First(&head, head.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if head.Attempts != 2 || head.LastError != "" || head.DeliveredAt == nil {
+		t.Errorf("retried message = %+v; expected delivered on the second attempt", head)
+	}
+}
+
+// 別のリレーがロックしている行は待たずに飛ばし、その集約の後のメッセージも送らないこと
+func TestRelay_SkipsLockedMessages(t *testing.T) {
+	db := openTestDB(t)
+	aggregates := []string{"a", "b"}
+	enqueueTestMessages(t, db, aggregates, 2)
+
+	// 別のリレーがaの先頭を送信中
+	other := db.Begin()
+	defer other.Rollback()
+	var locked Message
+	if err := other.Raw("SELECT * FROM outbox_messages WHERE aggregate_id = ? ORDER BY id LIMIT 1 FOR UPDATE", "a").
+		Scan(&locked).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &recordingSink{delivered: map[string][]uint64{}}
+	relay := NewRelay(db, sink)
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	result, err := relay.Drain(ctx)
+	if err != nil {
+		t.Fatalf("relay blocked on a locked row: %v", err)
+	}
+	if result.Delivered != 2 || len(sink.delivered["a"]) != 0 {
+		t.Fatalf("drain = %+v, delivered = %v; expected only b", result, sink.delivered)
+	}
+
+	if err := other.Rollback().Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.Drain(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	assertDeliveredInOrder(t, sink.delivered, aggregates, 2)
+}
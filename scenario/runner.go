@@ -0,0 +1,424 @@
+package scenario
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// DefaultStepTimeout 1ステップが完了（またはロック待ちに入る）までの上限
+	DefaultStepTimeout = 5 * time.Second
+
+	pollInterval = 5 * time.Millisecond
+	// ロックが解放されてから待っていた側が動き出すまでの猶予
+	releaseGrace = 100 * time.Millisecond
+)
+
+// Event トレースの1行
+//
+// Phaseは done（完了）/ blocked（ロック待ちに入った）/ resumed（ロック待ちから完了）/
+// failed（エラーでトランザクションが中断）/ skipped（中断済みのため実行しなかった）のいずれか。
+type Event struct {
+	Seq      int     `json:"seq"`
+	Step     int     `json:"step"`
+	Tx       string  `json:"tx"`
+	Name     string  `json:"name"`
+	Phase    string  `json:"phase"`
+	Saw      string  `json:"saw,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	SQLState string  `json:"sqlstate,omitempty"`
+	AtMs     float64 `json:"at_ms"`
+}
+
+// Result シナリオの実行結果
+type Result struct {
+	Scenario    string           `json:"scenario"`
+	Description string           `json:"description,omitempty"`
+	Isolation   string           `json:"isolation"`
+	Occurred    bool             `json:"occurred"`
+	Explanation string           `json:"explanation,omitempty"`
+	Committed   map[string]bool  `json:"committed"`
+	Vars        map[string]int64 `json:"vars"`
+	Trace       []Event          `json:"trace"`
+	DurationMs  int64            `json:"duration_ms"`
+}
+
+// Failure txが最初に失敗したイベント（失敗していなければnil）
+func (r *Result) Failure(tx string) *Event {
+	for i := range r.Trace {
+		if r.Trace[i].Tx == tx && r.Trace[i].Phase == "failed" {
+			return &r.Trace[i]
+		}
+	}
+	return nil
+}
+
+// HasSQLState いずれかのステップがcodeのSQLSTATEで失敗したか
+func (r *Result) HasSQLState(code string) bool {
+	for _, e := range r.Trace {
+		if e.SQLState == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Runner シナリオを実行する（PostgreSQL専用）
+//
+// トランザクションごとに1本、ロック待ちの確認に1本のコネクションを使うので、
+// DBのコネクションプールにはトランザクション数+1本以上の空きが必要。
+type Runner struct {
+	DB          *sql.DB
+	StepTimeout time.Duration
+}
+
+// Run シナリオを実行し、ステップごとのトレースを返す
+func (r *Runner) Run(ctx context.Context, s Scenario) (*Result, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	timeout := r.StepTimeout
+	if timeout <= 0 {
+		timeout = DefaultStepTimeout
+	}
+
+	vars := NewVars()
+	result := &Result{
+		Scenario:    s.Name,
+		Description: s.Description,
+		Isolation:   IsolationName(s.Isolation),
+		Committed:   map[string]bool{},
+		Trace:       []Event{},
+	}
+	for _, name := range txNames(s.Steps) {
+		result.Committed[name] = false
+	}
+
+	if s.Teardown != nil {
+		defer s.Teardown(context.WithoutCancel(ctx), r.DB, vars)
+	}
+	if s.Setup != nil {
+		if err := s.Setup(ctx, r.DB, vars); err != nil {
+			return nil, fmt.Errorf("setup: %w", err)
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	rn := &run{
+		ctx:      runCtx,
+		cancel:   cancel,
+		db:       r.DB,
+		scenario: s,
+		vars:     vars,
+		result:   result,
+		timeout:  timeout,
+		start:    time.Now(),
+		sessions: map[string]*session{},
+		outcomes: make(chan outcome, len(s.Steps)),
+	}
+	defer rn.close()
+
+	for i := range s.Steps {
+		if err := rn.step(i); err != nil {
+			return nil, err
+		}
+	}
+	if err := rn.finish(); err != nil {
+		return nil, err
+	}
+	result.DurationMs = time.Since(rn.start).Milliseconds()
+	result.Vars = vars.Snapshot()
+
+	if s.Check != nil {
+		occurred, explanation, err := s.Check(ctx, r.DB, vars, result)
+		if err != nil {
+			return nil, fmt.Errorf("check: %w", err)
+		}
+		result.Occurred, result.Explanation = occurred, explanation
+	}
+	return result, nil
+}
+
+// session 1つのトランザクション（専用のコネクションとgoroutineを持つ）
+type session struct {
+	name    string
+	conn    *sql.Conn
+	tx      *sql.Tx
+	pid     int
+	jobs    chan int
+	ended   bool
+	pending int // ロック待ち中のステップ（なければ-1）
+}
+
+type outcome struct {
+	step int
+	saw  string
+	err  error
+}
+
+type run struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	db       *sql.DB
+	scenario Scenario
+	vars     *Vars
+	result   *Result
+	timeout  time.Duration
+	start    time.Time
+	sessions map[string]*session
+	outcomes chan outcome
+	wg       sync.WaitGroup
+}
+
+func (rn *run) step(i int) error {
+	st := rn.scenario.Steps[i]
+	s, ok := rn.sessions[st.Tx]
+
+	// 同じトランザクションの前のステップがロック待ちなら、それが終わるまで進めない
+	if ok && s.pending >= 0 {
+		if err := rn.waitFor(s.pending); err != nil {
+			return err
+		}
+	}
+	if ok && s.ended {
+		rn.record(i, "skipped", "transaction already ended", nil)
+		return nil
+	}
+	if !ok {
+		var err error
+		if s, err = rn.open(st.Tx); err != nil {
+			return fmt.Errorf("begin %s: %w", st.Tx, err)
+		}
+	}
+
+	s.jobs <- i
+	if st.Blocks {
+		return rn.waitUntilBlocked(s, i)
+	}
+	if err := rn.waitFor(i); err != nil {
+		return err
+	}
+	return rn.settle()
+}
+
+// open トランザクションを専用のコネクションで開始する
+func (rn *run) open(name string) (*session, error) {
+	conn, err := rn.db.Conn(rn.ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &session{name: name, conn: conn, jobs: make(chan int), pending: -1}
+	if err := conn.QueryRowContext(rn.ctx, "SELECT pg_backend_pid()").Scan(&s.pid); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.tx, err = conn.BeginTx(rn.ctx, &sql.TxOptions{Isolation: rn.scenario.Isolation}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	rn.sessions[name] = s
+
+	rn.wg.Add(1)
+	go func() {
+		defer rn.wg.Done()
+		for i := range s.jobs {
+			saw, err := rn.scenario.Steps[i].Action(rn.ctx, s.tx, rn.vars)
+			rn.outcomes <- outcome{step: i, saw: saw, err: err}
+		}
+	}()
+	return s, nil
+}
+
+// handle ステップの完了をトレースに記録する
+func (rn *run) handle(o outcome) {
+	st := rn.scenario.Steps[o.step]
+	s := rn.sessions[st.Tx]
+
+	phase := "done"
+	if s.pending == o.step {
+		s.pending = -1
+		phase = "resumed"
+	}
+	switch {
+	case o.err != nil:
+		phase = "failed"
+		s.ended = true
+		s.tx.Rollback()
+	case st.end == "commit":
+		s.ended = true
+		rn.result.Committed[st.Tx] = true
+	case st.end == "rollback":
+		s.ended = true
+	}
+	rn.record(o.step, phase, o.saw, o.err)
+}
+
+func (rn *run) record(step int, phase, saw string, err error) {
+	st := rn.scenario.Steps[step]
+	e := Event{
+		Seq:   len(rn.result.Trace) + 1,
+		Step:  step + 1,
+		Tx:    st.Tx,
+		Name:  st.Name,
+		Phase: phase,
+		Saw:   saw,
+		AtMs:  float64(time.Since(rn.start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		e.Error = err.Error()
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			e.SQLState = pgErr.Code
+		}
+	}
+	rn.result.Trace = append(rn.result.Trace, e)
+}
+
+// waitFor stepが完了するまで待つ（その間に完了した他のステップも記録する）
+func (rn *run) waitFor(step int) error {
+	deadline := time.NewTimer(rn.timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case o := <-rn.outcomes:
+			rn.handle(o)
+			if o.step == step {
+				return nil
+			}
+		case <-deadline.C:
+			st := rn.scenario.Steps[step]
+			return fmt.Errorf("step %d (%s: %s) did not finish within %s; mark it Blocking() if it waits for a lock", step+1, st.Tx, st.Name, rn.timeout)
+		case <-rn.ctx.Done():
+			return rn.ctx.Err()
+		}
+	}
+}
+
+// waitUntilBlocked stepがロック待ちに入る（または完了する）まで待つ
+func (rn *run) waitUntilBlocked(s *session, step int) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(rn.timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case o := <-rn.outcomes:
+			rn.handle(o)
+			if o.step == step {
+				// 待たずに完了した
+				return rn.settle()
+			}
+		case <-ticker.C:
+			waiting, err := rn.waiting(s.pid)
+			if err != nil {
+				return err
+			}
+			if waiting {
+				s.pending = step
+				rn.record(step, "blocked", "waiting for lock", nil)
+				return nil
+			}
+		case <-deadline.C:
+			st := rn.scenario.Steps[step]
+			return fmt.Errorf("step %d (%s: %s) neither blocked nor finished within %s", step+1, st.Tx, st.Name, rn.timeout)
+		case <-rn.ctx.Done():
+			return rn.ctx.Err()
+		}
+	}
+}
+
+// settle 直前のステップでロックが解放されたトランザクションがあれば、その完了を先に記録する
+func (rn *run) settle() error {
+	for _, s := range rn.pendingSessions() {
+		released, err := rn.released(s)
+		if err != nil {
+			return err
+		}
+		if released {
+			if err := rn.waitFor(s.pending); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rn *run) released(s *session) (bool, error) {
+	deadline := time.Now().Add(releaseGrace)
+	for {
+		waiting, err := rn.waiting(s.pid)
+		if err != nil {
+			return false, err
+		}
+		if !waiting {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// waiting pidのバックエンドがロック待ちか
+func (rn *run) waiting(pid int) (bool, error) {
+	var waiting bool
+	err := rn.db.QueryRowContext(rn.ctx,
+		"SELECT COALESCE(wait_event_type = 'Lock', false) FROM pg_stat_activity WHERE pid = $1", pid).Scan(&waiting)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return waiting, err
+}
+
+// pendingSessions ロック待ちのステップがあるトランザクション（ステップの順）
+func (rn *run) pendingSessions() []*session {
+	var pending []*session
+	for _, s := range rn.sessions {
+		if s.pending >= 0 {
+			pending = append(pending, s)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].pending < pending[j].pending })
+	return pending
+}
+
+// finish 全ステップを投入した後、ロック待ちのまま残っているステップの完了を待つ
+func (rn *run) finish() error {
+	for {
+		pending := rn.pendingSessions()
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := rn.waitFor(pending[0].pending); err != nil {
+			return err
+		}
+	}
+}
+
+// close 終わっていないトランザクションをロールバックし、コネクションを返す
+func (rn *run) close() {
+	for _, s := range rn.sessions {
+		if !s.ended && s.pending < 0 {
+			s.tx.Rollback()
+			s.ended = true
+		}
+	}
+	// ロック待ちのまま残ったステップはキャンセルで止める
+	rn.cancel()
+	for _, s := range rn.sessions {
+		close(s.jobs)
+	}
+	rn.wg.Wait()
+	for _, s := range rn.sessions {
+		s.tx.Rollback()
+		s.conn.Close()
+	}
+}
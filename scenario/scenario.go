@@ -0,0 +1,215 @@
+// Package scenario は複数のトランザクションを別々のコネクションで開き、
+// 宣言したステップの順に1つずつ進めて、各トランザクションから何が見えたかを記録する
+//
+// sleepで実行のタイミングを合わせる代わりに、ステップの完了（ロック待ちになるステップは
+// pg_stat_activityで待ちに入ったこと）を確認してから次のステップへ進むため、結果が毎回同じになる。
+package scenario
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Vars ステップ間で受け渡す値（セットアップで作った行のID、各トランザクションが読んだ値など）
+type Vars struct {
+	mu sync.Mutex
+	m  map[string]int64
+}
+
+// NewVars 空のVarsを作る
+func NewVars() *Vars {
+	return &Vars{m: map[string]int64{}}
+}
+
+// Get 値を取得（未設定なら0）
+func (v *Vars) Get(key string) int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.m[key]
+}
+
+// Lookup 値を取得し、設定済みかも返す
+func (v *Vars) Lookup(key string) (int64, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	val, ok := v.m[key]
+	return val, ok
+}
+
+// Set 値を設定
+func (v *Vars) Set(key string, val int64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.m[key] = val
+}
+
+// Snapshot 現在の値のコピー
+func (v *Vars) Snapshot() map[string]int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out := make(map[string]int64, len(v.m))
+	for k, val := range v.m {
+		out[k] = val
+	}
+	return out
+}
+
+// Var SQLの引数に渡すと、実行時にVarsの値に置き換わる
+type Var string
+
+func resolveArgs(vars *Vars, args []any) []any {
+	resolved := make([]any, len(args))
+	for i, a := range args {
+		if name, ok := a.(Var); ok {
+			resolved[i] = vars.Get(string(name))
+			continue
+		}
+		resolved[i] = a
+	}
+	return resolved
+}
+
+// Action ステップで実行する処理（戻り値の文字列はトレースに「見えたもの」として記録される）
+type Action func(ctx context.Context, tx *sql.Tx, vars *Vars) (string, error)
+
+// Step 1つのトランザクションが行う1ステップ
+type Step struct {
+	Tx     string
+	Name   string
+	Action Action
+	// Blocks ロック待ちになることを想定するステップ。待ちに入ったのを確認したら完了を待たずに次のステップへ進む
+	Blocks bool
+
+	end string // "commit" または "rollback"（トランザクションを終えるステップ）
+}
+
+// Blocking ロック待ちになるステップとして返す
+func (s Step) Blocking() Step {
+	s.Blocks = true
+	return s
+}
+
+// Scenario 実行するシナリオの定義
+type Scenario struct {
+	Name        string
+	Description string
+	Isolation   sql.IsolationLevel
+	// Setup ステップの前に実行する（テスト用の行を作り、IDをVarsに入れるなど）
+	Setup func(ctx context.Context, db *sql.DB, vars *Vars) error
+	Steps []Step
+	// Check ステップの後に実行し、注目している現象が起きたかを判定する
+	Check func(ctx context.Context, db *sql.DB, vars *Vars, result *Result) (occurred bool, explanation string, err error)
+	// Teardown 最後に必ず実行する
+	Teardown func(ctx context.Context, db *sql.DB, vars *Vars) error
+}
+
+// Validate ステップの定義に矛盾がないか確認する
+func (s Scenario) Validate() error {
+	ended := map[string]bool{}
+	for i, step := range s.Steps {
+		if step.Tx == "" {
+			return fmt.Errorf("step %d: tx is required", i+1)
+		}
+		if step.Action == nil {
+			return fmt.Errorf("step %d: action is required", i+1)
+		}
+		if ended[step.Tx] {
+			return fmt.Errorf("step %d: %s has already ended", i+1, step.Tx)
+		}
+		if step.end != "" {
+			ended[step.Tx] = true
+		}
+	}
+	return nil
+}
+
+// Query 1行1列の整数を読み、keyに保存する
+func Query(tx, name, key, query string, args ...any) Step {
+	return Step{Tx: tx, Name: name, Action: func(ctx context.Context, t *sql.Tx, vars *Vars) (string, error) {
+		var v int64
+		if err := t.QueryRowContext(ctx, query, resolveArgs(vars, args)...).Scan(&v); err != nil {
+			return "", err
+		}
+		vars.Set(key, v)
+		return fmt.Sprintf("%s = %d", key, v), nil
+	}}
+}
+
+// Exec 更新系のSQLを実行し、影響した行数を記録する
+func Exec(tx, name, query string, args ...any) Step {
+	return Step{Tx: tx, Name: name, Action: func(ctx context.Context, t *sql.Tx, vars *Vars) (string, error) {
+		res, err := t.ExecContext(ctx, query, resolveArgs(vars, args)...)
+		if err != nil {
+			return "", err
+		}
+		n, _ := res.RowsAffected()
+		return fmt.Sprintf("%d row(s)", n), nil
+	}}
+}
+
+// Do 任意の処理を行うステップ
+func Do(tx, name string, action Action) Step {
+	return Step{Tx: tx, Name: name, Action: action}
+}
+
+// Commit トランザクションをコミットする
+func Commit(tx string) Step {
+	return Step{Tx: tx, Name: "COMMIT", end: "commit", Action: func(ctx context.Context, t *sql.Tx, vars *Vars) (string, error) {
+		return "", t.Commit()
+	}}
+}
+
+// Rollback トランザクションをロールバックする
+func Rollback(tx string) Step {
+	return Step{Tx: tx, Name: "ROLLBACK", end: "rollback", Action: func(ctx context.Context, t *sql.Tx, vars *Vars) (string, error) {
+		return "", t.Rollback()
+	}}
+}
+
+// 実行したトランザクションの名前（T1, T2, ...）をステップの順に並べる
+func txNames(steps []Step) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, s := range steps {
+		if !seen[s.Tx] {
+			seen[s.Tx] = true
+			names = append(names, s.Tx)
+		}
+	}
+	return names
+}
+
+// IsolationName sql.IsolationLevelをAPIで使う名前に変換する（read-committedなど）
+func IsolationName(level sql.IsolationLevel) string {
+	if level == sql.LevelDefault {
+		return "default"
+	}
+	return strings.ReplaceAll(strings.ToLower(level.String()), " ", "-")
+}
+
+// ParseIsolation IsolationNameの逆変換
+func ParseIsolation(name string) (sql.IsolationLevel, error) {
+	for _, level := range Isolations {
+		if IsolationName(level) == name {
+			return level, nil
+		}
+	}
+	names := make([]string, 0, len(Isolations))
+	for _, level := range Isolations {
+		names = append(names, IsolationName(level))
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("unknown isolation %q (%s)", name, strings.Join(names, ", "))
+}
+
+// Isolations PostgreSQLで指定できる分離レベル（READ UNCOMMITTEDはREAD COMMITTEDと同じ動作）
+var Isolations = []sql.IsolationLevel{
+	sql.LevelReadUncommitted,
+	sql.LevelReadCommitted,
+	sql.LevelRepeatableRead,
+	sql.LevelSerializable,
+}
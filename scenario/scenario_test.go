@@ -0,0 +1,65 @@
+package scenario
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestScenario_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []Step
+		wantErr bool
+	}{
+		{"ok", []Step{Exec("T1", "update", "UPDATE t SET v = 1"), Commit("T1")}, false},
+		{"missing tx", []Step{Exec("", "update", "UPDATE t SET v = 1")}, true},
+		{"missing action", []Step{{Tx: "T1", Name: "noop"}}, true},
+		{"step after commit", []Step{Commit("T1"), Exec("T1", "update", "UPDATE t SET v = 1")}, true},
+		{"step after rollback", []Step{Rollback("T2"), Commit("T2")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Scenario{Name: tt.name, Steps: tt.steps}.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveArgs(t *testing.T) {
+	vars := NewVars()
+	vars.Set("X", 42)
+
+	got := resolveArgs(vars, []any{Var("X"), "literal", 7, Var("missing")})
+	want := []any{int64(42), "literal", 7, int64(0)}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("arg %d = %v (%T); expected %v (%T)", i, got[i], got[i], want[i], want[i])
+		}
+	}
+}
+
+func TestIsolationName(t *testing.T) {
+	for _, level := range Isolations {
+		name := IsolationName(level)
+		parsed, err := ParseIsolation(name)
+		if err != nil || parsed != level {
+			t.Errorf("ParseIsolation(%q) = %v, %v; expected %v", name, parsed, err, level)
+		}
+	}
+	if got := IsolationName(sql.LevelRepeatableRead); got != "repeatable-read" {
+		t.Errorf("IsolationName(RepeatableRead) = %q", got)
+	}
+	if _, err := ParseIsolation("snapshot"); err == nil {
+		t.Error("ParseIsolation(snapshot) expected error")
+	}
+}
+
+func TestTxNames(t *testing.T) {
+	steps := []Step{Exec("T2", "a", "x"), Exec("T1", "b", "x"), Commit("T2"), Commit("T1")}
+	got := txNames(steps)
+	if len(got) != 2 || got[0] != "T2" || got[1] != "T1" {
+		t.Errorf("txNames() = %v; expected [T2 T1]", got)
+	}
+}